@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository/postgres"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/bilbothegreedy/HNS/pkg/utils"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	// Initialize logger
+	utils.InitLogger(zerolog.InfoLevel)
+
+	// Command line flags
+	filePath := flag.String("file", "", "Path to the CSV or JSON file of hostnames to import")
+	templateID := flag.Int64("template", 0, "ID of the template the hostnames were generated from")
+	format := flag.String("format", "", "Input format (csv or json); defaults to the file extension")
+	dryRun := flag.Bool("dry-run", false, "Parse and report without importing anything")
+	importedBy := flag.String("user", "system:import", "Name recorded as the committer of imported hostnames")
+
+	flag.Parse()
+
+	if *filePath == "" || *templateID <= 0 {
+		fmt.Println("Usage: import -file <path> -template <id> [-format csv|json] [-dry-run] [-user <name>]")
+		os.Exit(1)
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*filePath)), ".")
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	// Read rows
+	file, err := os.Open(*filePath)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *filePath).Msg("Failed to open import file")
+	}
+	defer file.Close()
+
+	rows, err := service.ReadImportRows(file, *format)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *filePath).Msg("Failed to read import file")
+	}
+
+	// Initialize database connection
+	db, err := postgres.NewPostgresDB(cfg.Database)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to database")
+	}
+	defer db.Close()
+
+	// Create services
+	auditService := service.NewAuditService(postgres.NewAuditRepository(db))
+	importService := service.NewImportService(postgres.NewHostnameRepository(db), postgres.NewTemplateRepository(db), auditService)
+
+	// Import hostnames
+	ctx := service.WithActor(context.Background(), models.Actor{Username: *importedBy, AuthMethod: "system"})
+	report, err := importService.ImportHostnames(ctx, *templateID, rows, *dryRun, *importedBy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to import hostnames")
+	}
+
+	// Print the report
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal().Err(err).Msg("Failed to write report")
+	}
+
+	if report.Rejected > 0 {
+		os.Exit(2)
+	}
+}
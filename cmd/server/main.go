@@ -99,21 +99,59 @@ func main() {
 	hostRepo := postgres.NewHostnameRepository(db)
 	templateRepo := postgres.NewTemplateRepository(db)
 	userRepo := postgres.NewUserRepository(db)
+	auditRepo := postgres.NewAuditRepository(db)
+	roleRepo := postgres.NewRoleRepository(db)
+	scanJobRepo := postgres.NewScanJobRepository(db)
 
 	// Ensure admin user exists
 	ensureAdminUserExists(userRepo)
 
 	// Create services
-	genService := service.NewGeneratorService(templateRepo)
-	resService := service.NewReservationService(hostRepo, templateRepo)
-	seqService := service.NewSequenceService(hostRepo)
+	auditService := service.NewAuditService(auditRepo)
+	authzService := service.NewAuthorizationService(roleRepo, userRepo, auditService)
+	genService := service.NewGeneratorService(templateRepo, auditService)
+	resService := service.NewReservationService(hostRepo, templateRepo, auditService, cfg.Reservation)
+	resService.SetAuthorizationService(authzService)
+	seqService := service.NewSequenceService(hostRepo, templateRepo, cfg.Reservation)
+	importService := service.NewImportService(hostRepo, templateRepo, auditService)
 
 	// Create auth components
 	jwtManager := auth.NewJWTManager(cfg.Auth.JWTSecret, cfg.Auth.JWTExpiration)
-	apiKeyManager := auth.NewAPIKeyManager(userRepo, cfg.Auth.APIKeyExpiration)
+	apiKeyManager := auth.NewAPIKeyManager(userRepo, auditService, cfg.Auth)
+
+	// Sign users in through the OIDC provider if one is configured
+	var oidcManager *auth.OIDCManager
+	if cfg.Auth.OIDC.Issuer != "" {
+		oidcManager, err = auth.NewOIDCManager(userRepo, auditService, cfg.Auth.OIDC)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure single sign-on")
+		}
+		if err := oidcManager.CheckRoles(context.Background(), authzService); err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure single sign-on")
+		}
+		jwtManager.SetOIDCManager(oidcManager)
+		log.Info().Str("issuer", cfg.Auth.OIDC.Issuer).Msg("OIDC single sign-on enabled")
+	}
 
 	// Create DNS checker
-	dnsChecker := dns.NewDNSChecker(cfg.DNS)
+	dnsChecker, err := dns.NewDNSChecker(cfg.DNS)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to configure DNS resolvers")
+	}
+	resService.SetHostnameResolver(dnsChecker.Uncached())
+
+	// Create scan job manager for running DNS scans in the background
+	scanJobs := dns.NewScanJobManager(dns.NewDNSScanner(dnsChecker, genService), scanJobRepo)
+
+	// Create zone discoverer for seeding the inventory from zone transfers
+	zoneDiscoverer := dns.NewZoneDiscoverer(cfg.DNS, genService, importService)
+	zoneDiscoverer.SetAuthorizationService(authzService)
+
+	// Register committed hostnames in DNS if dynamic updates are configured
+	if len(cfg.DNS.Updates) > 0 {
+		resService.SetDNSRegistrar(dns.NewDNSUpdater(cfg.DNS))
+		log.Info().Int("zones", len(cfg.DNS.Updates)).Msg("DNS dynamic updates enabled")
+	}
 	// Add this to your main function or a debug endpoint
 	templatePaths := []string{
 		"./internal/web/templates/layouts/base/base.html",
@@ -146,10 +184,16 @@ func main() {
 		genService,
 		resService,
 		seqService,
+		importService,
 		userRepo,
 		jwtManager,
 		apiKeyManager,
 		dnsChecker,
+		scanJobs,
+		zoneDiscoverer,
+		auditService,
+		authzService,
+		oidcManager,
 	)
 
 	// Setup Web routes for the UI
@@ -160,8 +204,30 @@ func main() {
 		templateRepo,
 		jwtManager,
 		dnsChecker,
+		authzService,
+		oidcManager,
 	)
 
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	reaper := service.NewReservationReaper(resService, cfg.Reservation.ReaperInterval)
+	go reaper.Run(workerCtx)
+	go scanJobs.Run(workerCtx)
+
+	reconciler := dns.NewDNSReconciler(dnsChecker, resService, cfg.DNS.ReconcileInterval)
+	go reconciler.Run(workerCtx)
+
+	// Answer DNS for committed hostnames if a listen address is configured
+	if cfg.DNS.Authoritative.Listen != "" {
+		dnsServer, err := dns.NewAuthoritativeServer(cfg.DNS.Authoritative, hostRepo)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure authoritative DNS server")
+		}
+		go dnsServer.Run(workerCtx)
+	}
+
 	// Start server in a goroutine
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
@@ -181,6 +247,9 @@ func main() {
 	<-quit
 	log.Info().Msg("Shutting down server...")
 
+	// Stop background workers
+	stopWorkers()
+
 	// Create context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -8,6 +10,7 @@ import (
 	"github.com/bilbothegreedy/HNS/internal/auth"
 	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
@@ -18,14 +21,23 @@ type AuthHandler struct {
 	userRepo      repository.UserRepository
 	jwtManager    *auth.JWTManager
 	apiKeyManager *auth.APIKeyManager
+	auditService  *service.AuditService
+	authz         *service.AuthorizationService
+	oidc          *auth.OIDCManager // nil unless single sign-on is configured
 }
 
+// oidcCallbackPath is where the OIDC provider sends API logins back to
+const oidcCallbackPath = "/auth/oidc/callback"
+
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(userRepo repository.UserRepository, jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManager) *AuthHandler {
+func NewAuthHandler(userRepo repository.UserRepository, jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManager, auditService *service.AuditService, authz *service.AuthorizationService, oidc *auth.OIDCManager) *AuthHandler {
 	return &AuthHandler{
 		userRepo:      userRepo,
 		jwtManager:    jwtManager,
 		apiKeyManager: apiKeyManager,
+		auditService:  auditService,
+		authz:         authz,
+		oidc:          oidc,
 	}
 }
 
@@ -38,6 +50,12 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// Check the role exists
+	if err := h.authz.ValidateRole(c.Request.Context(), req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Check if username already exists
 	existingUser, err := h.userRepo.GetByUsername(c.Request.Context(), req.Username)
 	if err == nil && existingUser != nil {
@@ -80,6 +98,10 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// Registration is unauthenticated, so the new user is recorded as the actor
+	setActor(c, user.ID, user.Username, "none")
+	h.auditService.Record(c.Request.Context(), models.AuditEntityUser, user.ID, models.AuditActionCreate, nil, user)
+
 	// Remove password hash from response
 	user.PasswordHash = ""
 
@@ -114,6 +136,57 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	h.respondLogin(c, user, "password")
+}
+
+// OIDCLogin handles requests to log in through the OIDC provider, redirecting
+// to it with a new authorization code request
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	authURL, _, err := h.oidc.LoginURL(c.Request.Context(), oidcCallbackPath, "")
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCTooManyLogins) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach the single sign-on provider"})
+		log.Error().Err(err).Msg("Failed to start OIDC login")
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback handles the OIDC provider sending a user back after they signed
+// in, and returns a token just as Login does
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed: " + reason + " " + c.Query("error_description")})
+		return
+	}
+
+	// Sign-in is unauthenticated until the provider vouches for the user, but
+	// provisioning them is audited with the request's details
+	setActor(c, 0, "", "oidc")
+
+	user, _, err := h.oidc.CompleteLogin(c.Request.Context(), c.Query("state"), c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserInactive):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is inactive"})
+		case errors.Is(err, auth.ErrOIDCLoginExpired):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+			log.Error().Err(err).Msg("Failed to complete OIDC login")
+		}
+		return
+	}
+
+	h.respondLogin(c, user, "oidc")
+}
+
+// respondLogin issues a token to a user who has just authenticated
+func (h *AuthHandler) respondLogin(c *gin.Context, user *models.User, authMethod string) {
 	// Generate token
 	token, err := h.jwtManager.GenerateToken(user)
 	if err != nil {
@@ -128,6 +201,9 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		log.Warn().Err(err).Int64("userID", user.ID).Msg("Failed to update last login time")
 	}
 
+	setActor(c, user.ID, user.Username, authMethod)
+	h.auditService.Record(c.Request.Context(), models.AuditEntityUser, user.ID, models.AuditActionLogin, nil, nil)
+
 	// Remove password hash from response
 	user.PasswordHash = ""
 
@@ -205,6 +281,7 @@ func (h *AuthHandler) UpdateUser(c *gin.Context) {
 		log.Error().Err(err).Int64("userID", id).Msg("Failed to get user for update")
 		return
 	}
+	before := *user
 
 	// Parse request
 	var req models.UserUpdateRequest
@@ -212,6 +289,12 @@ func (h *AuthHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Role != "" {
+		if err := h.authz.ValidateRole(c.Request.Context(), req.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// Update user fields
 	if req.Email != "" {
@@ -249,6 +332,8 @@ func (h *AuthHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	h.auditService.Record(c.Request.Context(), models.AuditEntityUser, user.ID, models.AuditActionUpdate, &before, user)
+
 	// Remove password hash from response
 	user.PasswordHash = ""
 
@@ -264,6 +349,14 @@ func (h *AuthHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	// Get the user being deleted for the audit trail
+	user, err := h.userRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		log.Error().Err(err).Int64("userID", id).Msg("Failed to get user for delete")
+		return
+	}
+
 	// Delete user
 	if err := h.userRepo.Delete(c.Request.Context(), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
@@ -271,6 +364,8 @@ func (h *AuthHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	h.auditService.Record(c.Request.Context(), models.AuditEntityUser, id, models.AuditActionDelete, user, nil)
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -291,18 +386,14 @@ func (h *AuthHandler) GetApiKeys(c *gin.Context) {
 		return
 	}
 
-	// Remove key value from response for security
-	for _, key := range apiKeys {
-		key.Key = ""
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"api_keys": apiKeys,
 		"count":    len(apiKeys),
 	})
 }
 
-// CreateApiKey handles requests to create a new API key
+// CreateApiKey handles requests to create a new API key. Only a signed-in user
+// may create keys; a caller authenticated by API key has no userID and is refused.
 func (h *AuthHandler) CreateApiKey(c *gin.Context) {
 	// Get authenticated user ID
 	userID, exists := c.Get("userID")
@@ -319,7 +410,7 @@ func (h *AuthHandler) CreateApiKey(c *gin.Context) {
 	}
 
 	// Create API key
-	apiKey, err := h.apiKeyManager.GenerateAPIKey(&req, userID.(int64))
+	apiKey, err := h.apiKeyManager.GenerateAPIKey(c.Request.Context(), &req, userID.(int64))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Error().Err(err).Int64("userID", userID.(int64)).Msg("Failed to create API key")
@@ -346,7 +437,7 @@ func (h *AuthHandler) DeleteApiKey(c *gin.Context) {
 	}
 
 	// Delete API key
-	if err := h.apiKeyManager.DeleteAPIKey(id, userID.(int64)); err != nil {
+	if err := h.apiKeyManager.DeleteAPIKey(c.Request.Context(), id, userID.(int64)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
 		log.Error().Err(err).Int64("apiKeyID", id).Msg("Failed to delete API key")
 		return
@@ -354,3 +445,81 @@ func (h *AuthHandler) DeleteApiKey(c *gin.Context) {
 
 	c.Status(http.StatusNoContent)
 }
+
+// RotateApiKey handles requests to replace an API key with a successor. The old
+// key keeps working for a grace period, so a pipeline can rotate its own key.
+// A caller authenticated by API key may only rotate that key, so the successor,
+// which inherits its scope and grants, is never wider than the caller's access.
+func (h *AuthHandler) RotateApiKey(c *gin.Context) {
+	// Get authenticated user ID
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Parse API key ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	if apiKeyID, exists := c.Get("apiKeyID"); exists && apiKeyID.(int64) != id {
+		c.JSON(http.StatusForbidden, gin.H{"error": "An API key can only rotate itself"})
+		return
+	}
+
+	// Parse request; the body is optional
+	var req models.APIKeyRotateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Rotate API key
+	apiKey, err := h.apiKeyManager.RotateAPIKey(c.Request.Context(), id, &req, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrAPIKeyNotFound), errors.Is(err, auth.ErrAPIKeyNotOwned):
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		case errors.Is(err, auth.ErrAPIKeyRotated), errors.Is(err, auth.ErrAPIKeyExpired):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Error().Err(err).Int64("apiKeyID", id).Msg("Failed to rotate API key")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+// defaultExpiringWithinDays is how far ahead the expiring API key report looks by default
+const defaultExpiringWithinDays = 14
+
+// GetExpiringApiKeys handles requests for the API keys of every user that
+// expire within the given number of days
+func (h *AuthHandler) GetExpiringApiKeys(c *gin.Context) {
+	days := defaultExpiringWithinDays
+	if daysStr := c.Query("days"); daysStr != "" {
+		parsed, err := strconv.Atoi(daysStr)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days, expected a positive number"})
+			return
+		}
+		days = parsed
+	}
+
+	apiKeys, err := h.apiKeyManager.ListExpiringAPIKeys(c.Request.Context(), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get expiring API keys"})
+		log.Error().Err(err).Msg("Failed to get expiring API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": apiKeys,
+		"count":    len(apiKeys),
+		"days":     days,
+	})
+}
@@ -2,8 +2,11 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -19,8 +22,11 @@ type APIHandler struct {
 	generatorService   *service.GeneratorService
 	reservationService *service.ReservationService
 	sequenceService    *service.SequenceService
+	importService      *service.ImportService
 	dnsChecker         *dns.DNSChecker
-	dnsScanner         *dns.DNSScanner
+	scanJobs           *dns.ScanJobManager
+	zoneDiscoverer     *dns.ZoneDiscoverer
+	authz              *service.AuthorizationService
 }
 
 // NewAPIHandler creates a new APIHandler
@@ -28,16 +34,21 @@ func NewAPIHandler(
 	generatorService *service.GeneratorService,
 	reservationService *service.ReservationService,
 	sequenceService *service.SequenceService,
+	importService *service.ImportService,
 	dnsChecker *dns.DNSChecker,
+	scanJobs *dns.ScanJobManager,
+	zoneDiscoverer *dns.ZoneDiscoverer,
+	authz *service.AuthorizationService,
 ) *APIHandler {
-	dnsScanner := dns.NewDNSScanner(dnsChecker, generatorService)
-
 	return &APIHandler{
 		generatorService:   generatorService,
 		reservationService: reservationService,
 		sequenceService:    sequenceService,
+		importService:      importService,
 		dnsChecker:         dnsChecker,
-		dnsScanner:         dnsScanner,
+		scanJobs:           scanJobs,
+		zoneDiscoverer:     zoneDiscoverer,
+		authz:              authz,
 	}
 }
 
@@ -118,9 +129,100 @@ func (h *APIHandler) CreateTemplate(c *gin.Context) {
 		return
 	}
 
+	// Whoever creates a template may go on managing it
+	h.authz.AssignTemplateOwner(c.Request.Context(), template.ID)
+
 	c.JSON(http.StatusCreated, template)
 }
 
+// UpdateTemplate handles requests to update a template, creating a new template version
+func (h *APIHandler) UpdateTemplate(c *gin.Context) {
+	// Parse template ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	// Parse request
+	var req models.TemplateUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	req.UpdatedBy = username
+
+	// Make sure the template exists
+	if _, err := h.generatorService.GetTemplateByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		log.Error().Err(err).Int64("templateID", id).Msg("Failed to get template")
+		return
+	}
+
+	// Update template
+	template, err := h.generatorService.UpdateTemplate(c.Request.Context(), id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Int64("templateID", id).Msg("Failed to update template")
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// GetTemplateVersions handles requests to list the versions of a template
+func (h *APIHandler) GetTemplateVersions(c *gin.Context) {
+	// Parse template ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	versions, err := h.generatorService.GetTemplateVersions(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get template versions"})
+		log.Error().Err(err).Int64("templateID", id).Msg("Failed to get template versions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// GetTemplateVersion handles requests to get a specific version of a template
+func (h *APIHandler) GetTemplateVersion(c *gin.Context) {
+	// Parse template ID and version
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template version"})
+		return
+	}
+
+	templateVersion, err := h.generatorService.GetTemplateVersion(c.Request.Context(), id, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template version not found"})
+		log.Error().Err(err).Int64("templateID", id).Int("version", version).Msg("Failed to get template version")
+		return
+	}
+
+	c.JSON(http.StatusOK, templateVersion)
+}
+
 // GenerateHostname handles requests to generate a hostname
 func (h *APIHandler) GenerateHostname(c *gin.Context) {
 	// Parse request
@@ -137,6 +239,9 @@ func (h *APIHandler) GenerateHostname(c *gin.Context) {
 	// Generate hostname
 	hostname, err := h.generatorService.GenerateHostname(c.Request.Context(), req.TemplateID, req.SequenceNum, req.Params)
 	if err != nil {
+		if respondValidationError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to generate hostname")
 		return
@@ -191,6 +296,9 @@ func (h *APIHandler) ReserveHostname(c *gin.Context) {
 	// Reserve hostname
 	hostname, err := h.reservationService.ReserveHostname(c.Request.Context(), &req)
 	if err != nil {
+		if respondValidationError(c, err) || respondPermissionError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to reserve hostname")
 		return
@@ -222,6 +330,9 @@ func (h *APIHandler) CommitHostname(c *gin.Context) {
 
 	// Commit hostname
 	if err := h.reservationService.CommitHostname(c.Request.Context(), &req); err != nil {
+		if respondPermissionError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Error().Err(err).Int64("hostnameID", req.HostnameID).Msg("Failed to commit hostname")
 		return
@@ -261,6 +372,9 @@ func (h *APIHandler) ReleaseHostname(c *gin.Context) {
 
 	// Release hostname
 	if err := h.reservationService.ReleaseHostname(c.Request.Context(), &req); err != nil {
+		if respondPermissionError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Error().Err(err).Int64("hostnameID", req.HostnameID).Msg("Failed to release hostname")
 		return
@@ -277,6 +391,238 @@ func (h *APIHandler) ReleaseHostname(c *gin.Context) {
 	c.JSON(http.StatusOK, hostname)
 }
 
+// ExtendReservation handles requests to extend a hostname reservation
+func (h *APIHandler) ExtendReservation(c *gin.Context) {
+	// Parse hostname ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hostname ID"})
+		return
+	}
+
+	// Parse request
+	var req models.HostnameExtendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.HostnameID = id
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	req.ExtendedBy = username
+
+	// Extend reservation
+	hostname, err := h.reservationService.ExtendReservation(c.Request.Context(), &req)
+	if err != nil {
+		if respondPermissionError(c, err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Int64("hostnameID", id).Msg("Failed to extend reservation")
+		return
+	}
+
+	c.JSON(http.StatusOK, hostname)
+}
+
+// BulkReserveHostnames handles requests to reserve several hostnames at once
+func (h *APIHandler) BulkReserveHostnames(c *gin.Context) {
+	// Parse request
+	var req models.HostnameBulkReservationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	req.RequestedBy = username
+
+	// Reserve hostnames
+	response, err := h.reservationService.ReserveHostnames(c.Request.Context(), &req)
+	if err != nil {
+		if respondPermissionError(c, err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to reserve hostnames in bulk")
+		return
+	}
+
+	if response.Failed > 0 {
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// BulkCommitHostnames handles requests to commit several reserved hostnames at once
+func (h *APIHandler) BulkCommitHostnames(c *gin.Context) {
+	h.handleBulkTransition(c, h.reservationService.CommitHostnames)
+}
+
+// BulkReleaseHostnames handles requests to release several committed hostnames at once
+func (h *APIHandler) BulkReleaseHostnames(c *gin.Context) {
+	h.handleBulkTransition(c, h.reservationService.ReleaseHostnames)
+}
+
+// handleBulkTransition parses a bulk commit or release request, applies it and
+// responds with the per-item results
+func (h *APIHandler) handleBulkTransition(c *gin.Context, apply func(context.Context, *models.HostnameBulkRequest) (*models.BulkOperationResponse, error)) {
+	// Parse request
+	var req models.HostnameBulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	req.RequestedBy = username
+
+	response, err := apply(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Int("count", len(req.HostnameIDs)).Msg("Failed to apply bulk hostname operation")
+		return
+	}
+
+	// All-or-nothing batches that failed changed nothing; partially applied
+	// best-effort batches report each item's own outcome
+	switch {
+	case response.Failed == 0:
+		c.JSON(http.StatusOK, response)
+	case response.Succeeded == 0:
+		c.JSON(http.StatusConflict, response)
+	default:
+		c.JSON(http.StatusMultiStatus, response)
+	}
+}
+
+// ParseHostname handles requests to split a hostname into its template groups
+func (h *APIHandler) ParseHostname(c *gin.Context) {
+	// Parse request
+	var req models.HostnameParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.AllTemplates {
+		results, err := h.generatorService.ParseHostnameAllTemplates(c.Request.Context(), req.Hostname)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse hostname"})
+			log.Error().Err(err).Str("hostname", req.Hostname).Msg("Failed to parse hostname against active templates")
+			return
+		}
+
+		var matches []models.HostnameParseResult
+		for _, result := range results {
+			if result.Matched {
+				matches = append(matches, result)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"hostname": req.Hostname,
+			"matches":  matches,
+			"results":  results,
+		})
+		return
+	}
+
+	if req.TemplateID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template_id is required unless all_templates is set"})
+		return
+	}
+
+	result, err := h.generatorService.ParseHostnameWithTemplate(c.Request.Context(), req.TemplateID, req.Hostname)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to parse hostname")
+		return
+	}
+
+	if !result.Matched {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ImportHostnames handles requests to import pre-existing hostnames from a CSV or JSON
+// document, sent either as the request body or as a multipart "file" field
+func (h *APIHandler) ImportHostnames(c *gin.Context) {
+	// Parse query parameters
+	templateID, err := strconv.ParseInt(c.Query("template_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+
+	// Work out where the document is and what format it is in
+	body := c.Request.Body
+	format := c.Query("format")
+	if file, err := c.FormFile("file"); err == nil {
+		upload, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer upload.Close()
+		body = upload
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+	}
+	if format == "" {
+		format = service.ImportFormatCSV
+		if strings.Contains(c.ContentType(), "json") {
+			format = service.ImportFormatJSON
+		}
+	}
+
+	// Read rows
+	rows, err := service.ReadImportRows(body, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Import hostnames
+	report, err := h.importService.ImportHostnames(c.Request.Context(), templateID, rows, dryRun, username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Int64("templateID", templateID).Msg("Failed to import hostnames")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // GetReservedHostnames handles requests to get all reserved hostnames
 func (h *APIHandler) GetReservedHostnames(c *gin.Context) {
 	// Parse pagination parameters
@@ -344,8 +690,12 @@ func (h *APIHandler) CheckHostnameDNS(c *gin.Context) {
 		return
 	}
 
-	// Check DNS
-	result, err := h.dnsChecker.CheckHostname(c.Request.Context(), hostname)
+	// Check DNS, skipping cached answers if asked to
+	ctx := c.Request.Context()
+	if fresh, _ := strconv.ParseBool(c.Query("fresh")); fresh {
+		ctx = dns.WithoutCache(ctx)
+	}
+	result, err := h.dnsChecker.CheckHostname(ctx, hostname)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check hostname in DNS"})
 		log.Error().Err(err).Str("hostname", hostname).Msg("Failed to check hostname in DNS")
@@ -355,7 +705,8 @@ func (h *APIHandler) CheckHostnameDNS(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
-// ScanDNS handles requests to scan DNS for hostnames
+// ScanDNS handles requests to scan DNS for hostnames. The scan runs in the
+// background; the job is returned straight away for the client to poll.
 func (h *APIHandler) ScanDNS(c *gin.Context) {
 	// Parse request
 	var req dns.ScanOptions
@@ -379,18 +730,209 @@ func (h *APIHandler) ScanDNS(c *gin.Context) {
 		req.MaxConcurrent = 10 // Default to 10 concurrent checks
 	}
 
-	// Scan DNS
-	result, err := h.dnsScanner.ScanTemplate(c.Request.Context(), req)
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	userID, _ := currentUserID(c)
+
+	// Start the scan
+	job, err := h.scanJobs.Start(c.Request.Context(), req, username, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start DNS scan"})
+		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to start DNS scan")
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/dns/scans/%d", job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetScanJobs handles requests to list DNS scan jobs, optionally for one template
+func (h *APIHandler) GetScanJobs(c *gin.Context) {
+	limit, offset := getPaginationParams(c)
+
+	var templateID int64
+	if templateIDStr := c.Query("template_id"); templateIDStr != "" {
+		id, err := strconv.ParseInt(templateIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+			return
+		}
+		templateID = id
+	}
+
+	// Get jobs
+	jobs, total, err := h.scanJobs.ListJobs(c.Request.Context(), templateID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scan jobs"})
+		log.Error().Err(err).Msg("Failed to get scan jobs")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetScanJob handles requests for the progress and (partial) result of a DNS scan job
+func (h *APIHandler) GetScanJob(c *gin.Context) {
+	// Parse job ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scan job ID"})
+		return
+	}
+
+	// Get job
+	job, err := h.scanJobs.GetJob(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan job not found"})
+		log.Error().Err(err).Int64("jobID", id).Msg("Failed to get scan job")
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelScanJob handles requests to cancel a DNS scan job. Only the account that
+// started the scan, matched by user ID, or a user who may manage DNS for the
+// scanned template may cancel it.
+func (h *APIHandler) CancelScanJob(c *gin.Context) {
+	// Parse job ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scan job ID"})
+		return
+	}
+
+	// Get job
+	job, err := h.scanJobs.GetJob(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan job not found"})
+		log.Error().Err(err).Int64("jobID", id).Msg("Failed to get scan job")
+		return
+	}
+
+	// Check the user may cancel it
+	if userID, ok := currentUserID(c); !ok || job.CreatedByID == 0 || userID != job.CreatedByID {
+		if !authorize(c, h.authz, models.PermissionDNSManage, job.TemplateID) {
+			return
+		}
+	}
+
+	// Cancel job
+	job, err = h.scanJobs.Cancel(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dns.ErrScanJobFinished) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scan job"})
+		log.Error().Err(err).Int64("jobID", id).Msg("Failed to cancel scan job")
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetDNSDriftReport handles requests for hostnames whose DNS records disagree
+// with their status, optionally filtered by kind of drift
+func (h *APIHandler) GetDNSDriftReport(c *gin.Context) {
+	limit, offset := getPaginationParams(c)
+
+	drift := models.DNSDrift(c.Query("drift"))
+	switch drift {
+	case models.DNSDriftNone, models.DNSDriftMissing, models.DNSDriftUnexpected, models.DNSDriftAddressMismatch:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drift, expected missing, unexpected or address-mismatch"})
+		return
+	}
+
+	// Get drifted hostnames
+	hostnames, total, err := h.reservationService.GetDNSDrift(c.Request.Context(), drift, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get DNS drift report"})
+		log.Error().Err(err).Msg("Failed to get DNS drift report")
+		return
+	}
+
+	counts, err := h.reservationService.CountDNSDrift(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get DNS drift report"})
+		log.Error().Err(err).Msg("Failed to count DNS drift")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hostnames": hostnames,
+		"counts":    counts,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+// GetDNSHealth handles requests for the health of the configured DNS servers
+func (h *APIHandler) GetDNSHealth(c *gin.Context) {
+	servers := h.dnsChecker.ServerHealth()
+
+	c.JSON(http.StatusOK, gin.H{
+		"servers": servers,
+		"count":   len(servers),
+		"cache":   h.dnsChecker.CacheStats(),
+	})
+}
+
+// FlushDNSCache handles requests to drop every cached DNS answer
+func (h *APIHandler) FlushDNSCache(c *gin.Context) {
+	flushed := h.dnsChecker.FlushCache()
+
+	username, _ := currentUsername(c)
+	log.Info().Int("entries", flushed).Str("username", username).Msg("DNS cache flushed")
+
+	c.JSON(http.StatusOK, gin.H{"flushed": flushed})
+}
+
+// DiscoverZone handles requests to find hostnames by transferring a DNS zone
+func (h *APIHandler) DiscoverZone(c *gin.Context) {
+	// Parse request
+	var req dns.DiscoveryOptions
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the authenticated user
+	username, ok := currentUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User information not available"})
+		return
+	}
+	req.ImportedBy = username
+
+	// Transfer and match the zone
+	result, err := h.zoneDiscoverer.DiscoverZone(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan DNS"})
-		log.Error().Err(err).Int64("templateID", req.TemplateID).Msg("Failed to scan DNS")
+		if errors.Is(err, dns.ErrZoneNotConfigured) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to discover zone"})
+		log.Error().Err(err).Str("zone", req.Zone).Msg("Failed to discover zone")
 		return
 	}
 
 	c.JSON(http.StatusOK, result)
 }
 
-// GetNextSequenceNumber handles requests to get the next sequence number for a template
+// GetNextSequenceNumber handles requests to get the next sequence number for a template.
+// Group values passed as query parameters select the sequence scope.
 func (h *APIHandler) GetNextSequenceNumber(c *gin.Context) {
 	// Parse template ID
 	templateIDStr := c.Param("templateID")
@@ -401,7 +943,7 @@ func (h *APIHandler) GetNextSequenceNumber(c *gin.Context) {
 	}
 
 	// Get next sequence number
-	nextSeq, err := h.sequenceService.GetNextSequenceNumber(c.Request.Context(), templateID)
+	nextSeq, err := h.sequenceService.GetNextSequenceNumber(c.Request.Context(), templateID, sequenceScopeParams(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get next sequence number"})
 		log.Error().Err(err).Int64("templateID", templateID).Msg("Failed to get next sequence number")
@@ -414,6 +956,72 @@ func (h *APIHandler) GetNextSequenceNumber(c *gin.Context) {
 	})
 }
 
+// GetSequenceUsage handles requests for sequence usage within a template's sequence scope
+func (h *APIHandler) GetSequenceUsage(c *gin.Context) {
+	// Parse template ID
+	templateID, err := strconv.ParseInt(c.Param("templateID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	// Get usage
+	usage, err := h.sequenceService.GetSequenceUsage(c.Request.Context(), templateID, sequenceScopeParams(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sequence usage"})
+		log.Error().Err(err).Int64("templateID", templateID).Msg("Failed to get sequence usage")
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// GetSequenceGaps handles requests for unused sequence numbers within a template's sequence scope
+func (h *APIHandler) GetSequenceGaps(c *gin.Context) {
+	// Parse template ID
+	templateID, err := strconv.ParseInt(c.Param("templateID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	// Parse gap limit
+	maxGaps := 100
+	if maxGapsStr := c.Query("max_gaps"); maxGapsStr != "" {
+		maxGaps, err = strconv.Atoi(maxGapsStr)
+		if err != nil || maxGaps <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_gaps"})
+			return
+		}
+	}
+
+	// Find gaps
+	gaps, err := h.sequenceService.FindSequenceGaps(c.Request.Context(), templateID, sequenceScopeParams(c, "max_gaps"), maxGaps)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find sequence gaps"})
+		log.Error().Err(err).Int64("templateID", templateID).Msg("Failed to find sequence gaps")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template_id": templateID,
+		"gaps":        gaps,
+	})
+}
+
+// sequenceScopeParams collects group values from the query string, skipping the
+// named control parameters
+func sequenceScopeParams(c *gin.Context, skip ...string) map[string]string {
+	params := make(map[string]string)
+	for name, values := range c.Request.URL.Query() {
+		if len(values) == 0 || slices.Contains(skip, name) {
+			continue
+		}
+		params[name] = values[0]
+	}
+	return params
+}
+
 // SearchHostnames handles requests to search for hostnames
 func (h *APIHandler) SearchHostnames(c *gin.Context) {
 	// Parse pagination parameters
@@ -465,6 +1073,77 @@ func (h *APIHandler) SearchHostnames(c *gin.Context) {
 	})
 }
 
+// respondValidationError writes a 422 response if err is a template validation
+// error and reports whether it did
+func respondValidationError(c *gin.Context, err error) bool {
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":       "Hostname parameters failed template validation",
+		"code":        "validation_failed",
+		"template_id": validationErr.TemplateID,
+		"details":     validationErr.Errors,
+	})
+	return true
+}
+
+// respondPermissionError writes a 403 response if err is a denial by the
+// caller's roles or API key grants and reports whether it did
+func respondPermissionError(c *gin.Context, err error) bool {
+	var authErr *service.AuthorizationError
+	if errors.As(err, &authErr) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      err.Error(),
+			"code":       "permission_denied",
+			"permission": authErr.Permission,
+		})
+		return true
+	}
+
+	var permissionErr *service.PermissionError
+	if errors.As(err, &permissionErr) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       err.Error(),
+			"code":        "grant_denied",
+			"template_id": permissionErr.TemplateID,
+		})
+		return true
+	}
+
+	return false
+}
+
+// currentUsername returns the name of the authenticated user, or "api-<userID>"
+// for requests authenticated with an API key
+func currentUsername(c *gin.Context) (string, bool) {
+	if username, exists := c.Get("username"); exists {
+		return username.(string), true
+	}
+
+	if apiKeyUserID, exists := c.Get("apiKeyUserID"); exists {
+		return apiKeyActor(apiKeyUserID.(int64)), true
+	}
+
+	return "", false
+}
+
+// currentUserID returns the ID of the authenticated user, whether they signed in
+// or presented an API key
+func currentUserID(c *gin.Context) (int64, bool) {
+	if userID, exists := c.Get("userID"); exists {
+		return userID.(int64), true
+	}
+
+	if apiKeyUserID, exists := c.Get("apiKeyUserID"); exists {
+		return apiKeyUserID.(int64), true
+	}
+
+	return 0, false
+}
+
 // getPaginationParams extracts pagination parameters from the request
 func getPaginationParams(c *gin.Context) (int, int) {
 	limitStr := c.DefaultQuery("limit", "10")
@@ -492,13 +1171,6 @@ func (h *APIHandler) DeleteTemplate(c *gin.Context) {
 		return
 	}
 
-	// Check if user has admin permissions
-	role, exists := c.Get("role")
-	if !exists || role != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin permission required to delete templates"})
-		return
-	}
-
 	// Get template to verify it exists
 	template, err := h.generatorService.GetTemplateByID(c.Request.Context(), id)
 	if err != nil {
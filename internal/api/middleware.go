@@ -1,11 +1,15 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bilbothegreedy/HNS/internal/auth"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/service"
 	"github.com/bilbothegreedy/HNS/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -18,6 +22,7 @@ func LoggerMiddleware() gin.HandlerFunc {
 		// Generate request ID
 		requestID := uuid.New().String()
 		c.Set("requestID", requestID)
+		c.Header("X-Request-ID", requestID)
 
 		// Get the request logger
 		logger := utils.GetRequestLogger(requestID)
@@ -90,8 +95,8 @@ func JWTAuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		// Validate the token
-		claims, err := jwtManager.VerifyToken(tokenString)
+		// Validate the token, which the OIDC provider may have issued
+		claims, authMethod, err := jwtManager.Authenticate(c.Request.Context(), tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
@@ -103,6 +108,7 @@ func JWTAuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
 		c.Set("role", claims.Role)
+		setActor(c, claims.UserID, claims.Username, authMethod)
 
 		c.Next()
 	}
@@ -121,6 +127,11 @@ func APIKeyAuthMiddleware(apiKeyManager *auth.APIKeyManager, requiredScope strin
 
 		// Validate the API key
 		key, err := apiKeyManager.ValidateAPIKey(apiKey, requiredScope)
+		if errors.Is(err, auth.ErrAPIKeyScope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
 			c.Abort()
@@ -128,15 +139,14 @@ func APIKeyAuthMiddleware(apiKeyManager *auth.APIKeyManager, requiredScope strin
 		}
 
 		// Set API key information in context
-		c.Set("apiKeyID", key.ID)
-		c.Set("apiKeyUserID", key.UserID)
-		c.Set("apiKeyScope", key.Scope)
+		setAPIKey(c, key)
 
 		c.Next()
 	}
 }
 
-// AuthMiddleware is a combined authentication middleware that supports both JWT and API key
+// AuthMiddleware is a combined authentication middleware that supports both JWT and API key.
+// Bearer tokens may be HNS tokens or access tokens issued by the OIDC provider.
 func AuthMiddleware(jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManager, requiredScope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Try API key first
@@ -146,13 +156,18 @@ func AuthMiddleware(jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManag
 			key, err := apiKeyManager.ValidateAPIKey(apiKey, requiredScope)
 			if err == nil {
 				// API key is valid
-				c.Set("apiKeyID", key.ID)
-				c.Set("apiKeyUserID", key.UserID)
-				c.Set("apiKeyScope", key.Scope)
+				setAPIKey(c, key)
 				c.Set("authMethod", "apikey")
 				c.Next()
 				return
 			}
+
+			// A valid key without the scope is refused outright, with the reason
+			if errors.Is(err, auth.ErrAPIKeyScope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
 		}
 
 		// Try JWT token
@@ -163,15 +178,16 @@ func AuthMiddleware(jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManag
 			if len(parts) == 2 && parts[0] == "Bearer" {
 				tokenString := parts[1]
 
-				// Validate the token
-				claims, err := jwtManager.VerifyToken(tokenString)
+				// Validate the token, which the OIDC provider may have issued
+				claims, authMethod, err := jwtManager.Authenticate(c.Request.Context(), tokenString)
 				if err == nil {
 					// Token is valid
 					c.Set("userID", claims.UserID)
 					c.Set("username", claims.Username)
 					c.Set("email", claims.Email)
 					c.Set("role", claims.Role)
-					c.Set("authMethod", "jwt")
+					c.Set("authMethod", authMethod)
+					setActor(c, claims.UserID, claims.Username, authMethod)
 					c.Next()
 					return
 				}
@@ -184,28 +200,81 @@ func AuthMiddleware(jwtManager *auth.JWTManager, apiKeyManager *auth.APIKeyManag
 	}
 }
 
-// RoleMiddleware checks if the authenticated user has the required role
-func RoleMiddleware(requiredRole string) gin.HandlerFunc {
+// setAPIKey records the authenticated API key in the request, and restricts the
+// request context to the key's scope and grants
+func setAPIKey(c *gin.Context, key *models.APIKey) {
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyUserID", key.UserID)
+	c.Set("apiKeyScope", key.Scope)
+	c.Set("apiKeyGrants", key.Grants)
+	setActor(c, key.UserID, apiKeyActor(key.UserID), "apikey")
+	ctx := service.WithAPIKeyGrants(c.Request.Context(), key.Grants)
+	c.Request = c.Request.WithContext(service.WithAPIKeyScope(ctx, key.Scope))
+}
+
+// setActor attaches the authenticated principal to the request context for auditing
+func setActor(c *gin.Context, userID int64, username, authMethod string) {
+	actor := models.Actor{
+		UserID:     userID,
+		Username:   username,
+		AuthMethod: authMethod,
+		RequestID:  c.GetString("requestID"),
+		ClientIP:   c.ClientIP(),
+	}
+	c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), actor))
+}
+
+// apiKeyActor returns the name recorded for requests made with a user's API key
+func apiKeyActor(userID int64) string {
+	return "api-" + strconv.FormatInt(userID, 10)
+}
+
+// PermissionMiddleware checks the authenticated principal holds a permission everywhere
+func PermissionMiddleware(authz *service.AuthorizationService, permission models.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get the user role from context
-		role, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Role information not available"})
+		if !authorize(c, authz, permission, 0) {
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Check if the role matches the required role
-		if role != requiredRole {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+// TemplatePermissionMiddleware checks the authenticated principal holds a
+// permission for the template whose ID is in the named path parameter. An API
+// key must also be granted the template.
+func TemplatePermissionMiddleware(authz *service.AuthorizationService, permission models.Permission, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templateID, err := strconv.ParseInt(c.Param(param), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
 			c.Abort()
 			return
 		}
 
+		if !authorize(c, authz, permission, templateID) {
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+// authorize checks the authenticated principal holds a permission, everywhere
+// or for the template, and responds with the reason if not
+func authorize(c *gin.Context, authz *service.AuthorizationService, permission models.Permission, templateID int64) bool {
+	err := authz.Authorize(c.Request.Context(), permission, templateID)
+	if err == nil {
+		return true
+	}
+
+	if !respondPermissionError(c, err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		log.Error().Err(err).Str("permission", string(permission)).Msg("Failed to check permissions")
+	}
+	return false
+}
+
 // RecoveryMiddleware recovers from panics and logs the error
 func RecoveryMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
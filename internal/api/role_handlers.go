@@ -0,0 +1,210 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// RoleHandler handles role and role assignment requests
+type RoleHandler struct {
+	authz *service.AuthorizationService
+}
+
+// NewRoleHandler creates a new RoleHandler
+func NewRoleHandler(authz *service.AuthorizationService) *RoleHandler {
+	return &RoleHandler{
+		authz: authz,
+	}
+}
+
+// GetRoles handles requests to list roles
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	roles, err := h.authz.ListRoles(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		log.Error().Err(err).Msg("Failed to get roles")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       roles,
+		"permissions": models.Permissions,
+	})
+}
+
+// GetRole handles requests to get a role by ID
+func (h *RoleHandler) GetRole(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	role, err := h.authz.GetRole(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole handles requests to create a custom role
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req models.RoleCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.authz.CreateRole(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrRoleExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Error().Err(err).Str("role", req.Name).Msg("Failed to create role")
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole handles requests to change a custom role
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var req models.RoleUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.authz.UpdateRole(c.Request.Context(), id, &req)
+	if err != nil {
+		respondRoleError(c, err)
+		log.Error().Err(err).Int64("roleID", id).Msg("Failed to update role")
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole handles requests to delete a custom role
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	if err := h.authz.DeleteRole(c.Request.Context(), id); err != nil {
+		respondRoleError(c, err)
+		log.Error().Err(err).Int64("roleID", id).Msg("Failed to delete role")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// GetUserRoles handles requests to list the roles assigned to a user
+func (h *RoleHandler) GetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	assignments, err := h.authz.ListAssignments(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role assignments"})
+		log.Error().Err(err).Int64("userID", userID).Msg("Failed to get role assignments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assignments": assignments})
+}
+
+// AssignUserRole handles requests to give a user a role, everywhere or for one template
+func (h *RoleHandler) AssignUserRole(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.RoleAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	assignment, err := h.authz.AssignRole(c.Request.Context(), userID, &req)
+	if err != nil {
+		respondRoleError(c, err)
+		log.Error().Err(err).Int64("userID", userID).Str("role", req.Role).Msg("Failed to assign role")
+		return
+	}
+
+	c.JSON(http.StatusCreated, assignment)
+}
+
+// UnassignUserRole handles requests to remove one of a user's role assignments
+func (h *RoleHandler) UnassignUserRole(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	assignmentID, err := strconv.ParseInt(c.Param("assignmentID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role assignment ID"})
+		return
+	}
+
+	if err := h.authz.UnassignRole(c.Request.Context(), userID, assignmentID); err != nil {
+		respondRoleError(c, err)
+		log.Error().Err(err).Int64("userID", userID).Int64("assignmentID", assignmentID).Msg("Failed to remove role assignment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role assignment removed successfully"})
+}
+
+// GetMyPermissions handles requests for the authenticated user's own permissions
+func (h *RoleHandler) GetMyPermissions(c *gin.Context) {
+	permissions, err := h.authz.Permissions(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions"})
+		log.Error().Err(err).Msg("Failed to get permissions")
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+// respondRoleError writes the response for a failed role change
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrRoleNotFound), errors.Is(err, service.ErrAssignmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRoleBuiltin):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAssignmentExists), errors.Is(err, repository.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
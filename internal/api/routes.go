@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/bilbothegreedy/HNS/internal/auth"
 	"github.com/bilbothegreedy/HNS/internal/dns"
+	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
 	"github.com/bilbothegreedy/HNS/internal/service"
 	"github.com/gin-gonic/gin"
@@ -14,45 +15,69 @@ func SetupRouter(
 	genService *service.GeneratorService,
 	resService *service.ReservationService,
 	seqService *service.SequenceService,
+	importService *service.ImportService,
 	userRepo repository.UserRepository,
 	jwtManager *auth.JWTManager,
 	apiKeyManager *auth.APIKeyManager,
 	dnsChecker *dns.DNSChecker,
+	scanJobs *dns.ScanJobManager,
+	zoneDiscoverer *dns.ZoneDiscoverer,
+	auditService *service.AuditService,
+	authz *service.AuthorizationService,
+	oidcManager *auth.OIDCManager,
 ) {
 	// Create handlers
-	apiHandler := NewAPIHandler(genService, resService, seqService, dnsChecker)
-	authHandler := NewAuthHandler(userRepo, jwtManager, apiKeyManager)
+	apiHandler := NewAPIHandler(genService, resService, seqService, importService, dnsChecker, scanJobs, zoneDiscoverer, authz)
+	authHandler := NewAuthHandler(userRepo, jwtManager, apiKeyManager, auditService, authz, oidcManager)
+	auditHandler := NewAuditHandler(auditService)
+	roleHandler := NewRoleHandler(authz)
 
 	// Public routes
 	router.GET("/health", apiHandler.HealthCheck)
 
 	// Auth routes
 	authRoutes := router.Group("/auth")
+	authRoutes.Use(LoggerMiddleware())
 	{
 		authRoutes.POST("/register", authHandler.RegisterUser)
 		authRoutes.POST("/login", authHandler.Login)
+
+		// Single sign-on, if configured
+		if oidcManager != nil {
+			authRoutes.GET("/oidc/login", authHandler.OIDCLogin)
+			authRoutes.GET("/oidc/callback", authHandler.OIDCCallback)
+		}
 	}
 
 	// API routes requiring authentication
 	api := router.Group("/api")
-	api.Use(AuthMiddleware(jwtManager, apiKeyManager, "read"))
+	api.Use(LoggerMiddleware(), AuthMiddleware(jwtManager, apiKeyManager, "read"))
 	{
 		// Template routes
 		templates := api.Group("/templates")
 		{
 			templates.GET("", apiHandler.GetTemplates)
 			templates.GET("/:id", apiHandler.GetTemplate)
-			templates.POST("", AuthMiddleware(jwtManager, apiKeyManager, "admin"), apiHandler.CreateTemplate)
-			templates.DELETE("/:id", AuthMiddleware(jwtManager, apiKeyManager, "admin"), apiHandler.DeleteTemplate)
+			templates.GET("/:id/versions", apiHandler.GetTemplateVersions)
+			templates.GET("/:id/versions/:version", apiHandler.GetTemplateVersion)
+			templates.POST("", AuthMiddleware(jwtManager, apiKeyManager, "admin"), PermissionMiddleware(authz, models.PermissionTemplateCreate), apiHandler.CreateTemplate)
+			templates.PUT("/:id", AuthMiddleware(jwtManager, apiKeyManager, "admin"), TemplatePermissionMiddleware(authz, models.PermissionTemplateUpdate, "id"), apiHandler.UpdateTemplate)
+			templates.DELETE("/:id", AuthMiddleware(jwtManager, apiKeyManager, "admin"), TemplatePermissionMiddleware(authz, models.PermissionTemplateDelete, "id"), apiHandler.DeleteTemplate)
 		}
 
 		// Hostname routes
 		hostnames := api.Group("/hostnames")
 		{
 			hostnames.POST("/generate", apiHandler.GenerateHostname)
+			hostnames.POST("/parse", apiHandler.ParseHostname)
 			hostnames.POST("/reserve", AuthMiddleware(jwtManager, apiKeyManager, "reserve"), apiHandler.ReserveHostname)
 			hostnames.POST("/commit", AuthMiddleware(jwtManager, apiKeyManager, "commit"), apiHandler.CommitHostname)
 			hostnames.POST("/release", AuthMiddleware(jwtManager, apiKeyManager, "release"), apiHandler.ReleaseHostname)
+			hostnames.POST("/:id/extend", AuthMiddleware(jwtManager, apiKeyManager, "reserve"), apiHandler.ExtendReservation)
+			hostnames.POST("/bulk/reserve", AuthMiddleware(jwtManager, apiKeyManager, "reserve"), apiHandler.BulkReserveHostnames)
+			hostnames.POST("/bulk/commit", AuthMiddleware(jwtManager, apiKeyManager, "commit"), apiHandler.BulkCommitHostnames)
+			hostnames.POST("/bulk/release", AuthMiddleware(jwtManager, apiKeyManager, "release"), apiHandler.BulkReleaseHostnames)
+			hostnames.POST("/import", AuthMiddleware(jwtManager, apiKeyManager, "admin"), PermissionMiddleware(authz, models.PermissionHostnameImport), apiHandler.ImportHostnames)
 			hostnames.GET("/reserved", apiHandler.GetReservedHostnames)
 			hostnames.GET("/committed", apiHandler.GetCommittedHostnames)
 			hostnames.GET("/:id", apiHandler.GetHostname)
@@ -63,6 +88,8 @@ func SetupRouter(
 		sequences := api.Group("/sequences")
 		{
 			sequences.GET("/next/:templateID", apiHandler.GetNextSequenceNumber)
+			sequences.GET("/usage/:templateID", apiHandler.GetSequenceUsage)
+			sequences.GET("/gaps/:templateID", apiHandler.GetSequenceGaps)
 		}
 
 		// DNS routes
@@ -70,24 +97,62 @@ func SetupRouter(
 		{
 			dnsRoutes.GET("/check/:hostname", apiHandler.CheckHostnameDNS)
 			dnsRoutes.POST("/scan", apiHandler.ScanDNS)
+			dnsRoutes.GET("/scans", apiHandler.GetScanJobs)
+			dnsRoutes.GET("/scans/:id", apiHandler.GetScanJob)
+			dnsRoutes.POST("/scans/:id/cancel", apiHandler.CancelScanJob)
+			dnsRoutes.POST("/discover", AuthMiddleware(jwtManager, apiKeyManager, "admin"), PermissionMiddleware(authz, models.PermissionDNSManage), apiHandler.DiscoverZone)
+			dnsRoutes.GET("/health", PermissionMiddleware(authz, models.PermissionDNSManage), apiHandler.GetDNSHealth)
+			dnsRoutes.DELETE("/cache", PermissionMiddleware(authz, models.PermissionDNSManage), apiHandler.FlushDNSCache)
+		}
+
+		// Report routes
+		reports := api.Group("/reports")
+		{
+			reports.GET("/dns-drift", apiHandler.GetDNSDriftReport)
+			reports.GET("/expiring-apikeys", PermissionMiddleware(authz, models.PermissionUserManage), authHandler.GetExpiringApiKeys)
 		}
 
 		// User routes
 		users := api.Group("/users")
-		users.Use(RoleMiddleware("admin"))
+		users.Use(PermissionMiddleware(authz, models.PermissionUserManage))
 		{
 			users.GET("", authHandler.GetUsers)
 			users.GET("/:id", authHandler.GetUser)
 			users.PUT("/:id", authHandler.UpdateUser)
 			users.DELETE("/:id", authHandler.DeleteUser)
+			users.GET("/:id/roles", roleHandler.GetUserRoles)
+			users.POST("/:id/roles", roleHandler.AssignUserRole)
+			users.DELETE("/:id/roles/:assignmentID", roleHandler.UnassignUserRole)
+		}
+
+		// Role routes
+		roles := api.Group("/roles")
+		roles.Use(PermissionMiddleware(authz, models.PermissionRoleManage))
+		{
+			roles.GET("", roleHandler.GetRoles)
+			roles.GET("/:id", roleHandler.GetRole)
+			roles.POST("", roleHandler.CreateRole)
+			roles.PUT("/:id", roleHandler.UpdateRole)
+			roles.DELETE("/:id", roleHandler.DeleteRole)
 		}
 
+		// The caller's own permissions
+		api.GET("/permissions", roleHandler.GetMyPermissions)
+
 		// API key routes
 		apiKeys := api.Group("/apikeys")
 		{
 			apiKeys.GET("", authHandler.GetApiKeys)
 			apiKeys.POST("", authHandler.CreateApiKey)
 			apiKeys.DELETE("/:id", authHandler.DeleteApiKey)
+			apiKeys.POST("/:id/rotate", authHandler.RotateApiKey)
+		}
+
+		// Audit routes
+		audit := api.Group("/audit")
+		audit.Use(PermissionMiddleware(authz, models.PermissionAuditRead))
+		{
+			audit.GET("", auditHandler.GetAuditEvents)
 		}
 	}
 }
@@ -3,76 +3,236 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/bilbothegreedy/HNS/internal/config"
 	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
 )
 
-// APIKeyManager manages API keys
+const (
+	// apiKeyTag starts every key, so leaked keys are easy to recognise
+	apiKeyTag = "hns"
+
+	// apiKeyPrefixBytes and apiKeySecretBytes size the random parts of a key
+	apiKeyPrefixBytes = 6
+	apiKeySecretBytes = 32
+)
+
+// Errors returned when an API key cannot be rotated
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyNotOwned = errors.New("API key does not belong to the user")
+	ErrAPIKeyRotated  = errors.New("API key has already been rotated")
+	ErrAPIKeyExpired  = errors.New("API key has expired")
+)
+
+// ErrAPIKeyScope is returned when a valid API key lacks the scope a request needs
+var ErrAPIKeyScope = errors.New("API key does not have the required scope")
+
+// APIKeyManager manages API keys. Keys are issued as hns_<prefix>_<secret> and
+// only their SHA-256 hash is stored; the public prefix finds the key to compare
+// a presented key against.
 type APIKeyManager struct {
-	userRepo      repository.UserRepository
-	keyExpiration time.Duration
+	userRepo         repository.UserRepository
+	auditSvc         *service.AuditService
+	keyExpiration    time.Duration
+	maxExpiration    time.Duration
+	rotationGrace    time.Duration
+	acceptLegacyKeys bool
 }
 
-// NewAPIKeyManager creates a new APIKeyManager
-func NewAPIKeyManager(userRepo repository.UserRepository, keyExpiration time.Duration) *APIKeyManager {
+// NewAPIKeyManager creates a new APIKeyManager with the key lifetimes in authConfig
+func NewAPIKeyManager(userRepo repository.UserRepository, auditSvc *service.AuditService, authConfig config.AuthConfig) *APIKeyManager {
 	return &APIKeyManager{
-		userRepo:      userRepo,
-		keyExpiration: keyExpiration,
+		userRepo:         userRepo,
+		auditSvc:         auditSvc,
+		keyExpiration:    authConfig.APIKeyExpiration,
+		maxExpiration:    authConfig.APIKeyMaxExpiration,
+		rotationGrace:    authConfig.APIKeyRotationGrace,
+		acceptLegacyKeys: authConfig.AcceptLegacyAPIKeys,
 	}
 }
 
 // GenerateAPIKey generates a new API key for a user
-func (m *APIKeyManager) GenerateAPIKey(req *models.APIKeyCreateRequest, userID int64) (*models.APIKeyResponse, error) {
-	// Validate scope
+func (m *APIKeyManager) GenerateAPIKey(ctx context.Context, req *models.APIKeyCreateRequest, userID int64) (*models.APIKeyResponse, error) {
+	// Validate scope, grants and expiry
 	if err := m.validateScope(req.Scope); err != nil {
 		return nil, err
 	}
+	if err := validateGrants(req.Grants); err != nil {
+		return nil, err
+	}
+	expiresAt, err := m.expiry(req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate a random API key
-	key, err := generateRandomString(32)
+	key, prefix, err := newAPIKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate API key: %w", err)
 	}
 
-	// Create the API key record
+	// Create the API key record, keeping only the key's hash
 	apiKey := &models.APIKey{
 		UserID:    userID,
 		Name:      req.Name,
-		Key:       key,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
 		Scope:     req.Scope,
-		ExpiresAt: time.Now().Add(m.keyExpiration),
+		Grants:    req.Grants,
+		ExpiresAt: expiresAt,
 	}
 
 	// Save the API key
-	if err := m.userRepo.CreateAPIKey(context.Background(), apiKey); err != nil {
+	if err := m.userRepo.CreateAPIKey(ctx, apiKey); err != nil {
 		return nil, fmt.Errorf("failed to save API key: %w", err)
 	}
 
-	// Create the response
+	m.auditSvc.Record(ctx, models.AuditEntityAPIKey, apiKey.ID, models.AuditActionCreate, nil, apiKey)
+
+	// Create the response, the only place the key is ever shown
 	response := &models.APIKeyResponse{
 		ID:        apiKey.ID,
 		Name:      apiKey.Name,
-		Key:       apiKey.Key,
+		Prefix:    apiKey.Prefix,
+		Key:       key,
 		Scope:     apiKey.Scope,
+		Grants:    apiKey.Grants,
 		ExpiresAt: apiKey.ExpiresAt,
 	}
 
 	return response, nil
 }
 
+// RotateAPIKey issues a successor to a user's API key, with the same name,
+// scope and grants. The old key stays valid for the rotation grace period, so clients can
+// switch over without an outage.
+func (m *APIKeyManager) RotateAPIKey(ctx context.Context, keyID int64, req *models.APIKeyRotateRequest, userID int64) (*models.APIKeyResponse, error) {
+	// Get the API key
+	apiKey, err := m.userRepo.GetAPIKeyByID(ctx, keyID)
+	if err != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	// Check the key belongs to the user and can still be rotated
+	if apiKey.UserID != userID {
+		return nil, ErrAPIKeyNotOwned
+	}
+	if apiKey.RotatedAt != nil {
+		return nil, ErrAPIKeyRotated
+	}
+	now := time.Now()
+	if !apiKey.ExpiresAt.After(now) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	expiresAt, err := m.expiry(req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the successor
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	successor := &models.APIKey{
+		UserID:    userID,
+		Name:      apiKey.Name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
+		Scope:     apiKey.Scope,
+		Grants:    apiKey.Grants,
+		ExpiresAt: expiresAt,
+	}
+
+	// The old key keeps working for the grace period, unless it expires first
+	graceExpiresAt := now.Add(m.rotationGrace)
+	if err := m.userRepo.RotateAPIKey(ctx, keyID, graceExpiresAt, successor); err != nil {
+		return nil, fmt.Errorf("failed to rotate API key: %w", err)
+	}
+
+	rotated := *apiKey
+	rotated.RotatedTo = &successor.ID
+	rotated.RotatedAt = &now
+	if graceExpiresAt.Before(rotated.ExpiresAt) {
+		rotated.ExpiresAt = graceExpiresAt
+	}
+
+	m.auditSvc.Record(ctx, models.AuditEntityAPIKey, apiKey.ID, models.AuditActionRotate, apiKey, &rotated)
+	m.auditSvc.Record(ctx, models.AuditEntityAPIKey, successor.ID, models.AuditActionCreate, nil, successor)
+
+	return &models.APIKeyResponse{
+		ID:                successor.ID,
+		Name:              successor.Name,
+		Prefix:            successor.Prefix,
+		Key:               key,
+		Scope:             successor.Scope,
+		Grants:            successor.Grants,
+		ExpiresAt:         successor.ExpiresAt,
+		RotatedFrom:       apiKey.ID,
+		PreviousExpiresAt: &rotated.ExpiresAt,
+	}, nil
+}
+
+// ListExpiringAPIKeys lists the API keys of every user that expire within the
+// given time, soonest first
+func (m *APIKeyManager) ListExpiringAPIKeys(ctx context.Context, within time.Duration) ([]*models.ExpiringAPIKey, error) {
+	now := time.Now()
+	return m.userRepo.ListExpiringAPIKeys(ctx, now, now.Add(within))
+}
+
+// expiry returns when a new key expires: at the requested time if one is given,
+// which must be in the future and within the maximum lifetime, or else after the
+// default lifetime
+func (m *APIKeyManager) expiry(requested *time.Time) (time.Time, error) {
+	now := time.Now()
+	if requested == nil {
+		return now.Add(m.keyExpiration), nil
+	}
+
+	if !requested.After(now) {
+		return time.Time{}, fmt.Errorf("expires_at must be in the future")
+	}
+	if m.maxExpiration > 0 && requested.After(now.Add(m.maxExpiration)) {
+		return time.Time{}, fmt.Errorf("expires_at may be at most %s from now", m.maxExpiration)
+	}
+
+	return *requested, nil
+}
+
 // ValidateAPIKey validates an API key and checks its scope
 func (m *APIKeyManager) ValidateAPIKey(key string, requiredScope string) (*models.APIKey, error) {
-	// Get the API key
-	apiKey, err := m.userRepo.GetAPIKeyByKey(context.Background(), key)
+	// Find the key by its prefix, or by its hash if it was issued without one
+	keyHash := hashAPIKey(key)
+	var apiKey *models.APIKey
+	var err error
+	if prefix, ok := parseAPIKey(key); ok {
+		apiKey, err = m.userRepo.GetAPIKeyByPrefix(context.Background(), prefix)
+	} else if m.acceptLegacyKeys {
+		apiKey, err = m.userRepo.GetAPIKeyByHash(context.Background(), keyHash)
+	} else {
+		return nil, fmt.Errorf("invalid API key")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid API key")
 	}
 
+	// Compare the whole key, in constant time
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(keyHash)) != 1 {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
 	// Check if the key has expired
 	if apiKey.ExpiresAt.Before(time.Now()) {
 		return nil, fmt.Errorf("API key has expired")
@@ -80,7 +240,7 @@ func (m *APIKeyManager) ValidateAPIKey(key string, requiredScope string) (*model
 
 	// Check if the key has the required scope
 	if !m.hasRequiredScope(apiKey.Scope, requiredScope) {
-		return nil, fmt.Errorf("API key does not have the required scope")
+		return nil, fmt.Errorf("%w %q", ErrAPIKeyScope, requiredScope)
 	}
 
 	// Update last used timestamp
@@ -98,9 +258,9 @@ func (m *APIKeyManager) ListAPIKeys(userID int64) ([]*models.APIKey, error) {
 }
 
 // DeleteAPIKey deletes an API key
-func (m *APIKeyManager) DeleteAPIKey(keyID int64, userID int64) error {
+func (m *APIKeyManager) DeleteAPIKey(ctx context.Context, keyID int64, userID int64) error {
 	// Get the API key
-	apiKey, err := m.userRepo.GetAPIKeyByID(context.Background(), keyID)
+	apiKey, err := m.userRepo.GetAPIKeyByID(ctx, keyID)
 	if err != nil {
 		return fmt.Errorf("API key not found")
 	}
@@ -111,22 +271,50 @@ func (m *APIKeyManager) DeleteAPIKey(keyID int64, userID int64) error {
 	}
 
 	// Delete the API key
-	if err := m.userRepo.DeleteAPIKey(context.Background(), keyID); err != nil {
+	if err := m.userRepo.DeleteAPIKey(ctx, keyID); err != nil {
 		return fmt.Errorf("failed to delete API key: %w", err)
 	}
 
+	m.auditSvc.Record(ctx, models.AuditEntityAPIKey, keyID, models.AuditActionDelete, apiKey, nil)
+
 	return nil
 }
 
-// generateRandomString generates a random string of the specified length
-func generateRandomString(length int) (string, error) {
-	b := make([]byte, length)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", err
+// newAPIKey generates a key and returns it with its public prefix
+func newAPIKey() (string, string, error) {
+	prefix := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
 	}
 
-	return base64.URLEncoding.EncodeToString(b)[:length], nil
+	// The prefix is hex so the underscores separating the parts stay unambiguous
+	prefixHex := hex.EncodeToString(prefix)
+	key := apiKeyTag + "_" + prefixHex + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefixHex, nil
+}
+
+// parseAPIKey returns the prefix of a key, or false if the key was issued
+// before keys had prefixes
+func parseAPIKey(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag || len(parts[1]) != 2*apiKeyPrefixBytes || parts[2] == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// hashAPIKey returns the hex SHA-256 of a key. Keys are long random strings, so
+// a fast hash is enough to make a stolen hash useless.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
 }
 
 // validateScope validates the scope format
@@ -152,17 +340,37 @@ func (m *APIKeyManager) validateScope(scope string) error {
 	return nil
 }
 
-// hasRequiredScope checks if the API key scope includes the required scope
-func (m *APIKeyManager) hasRequiredScope(keyScope, requiredScope string) bool {
-	// If the key has admin scope, it has all scopes
-	if strings.Contains(keyScope, "admin") {
-		return true
+// validateGrants validates the grants restricting a key
+func validateGrants(grants []models.APIKeyGrant) error {
+	for i, grant := range grants {
+		if len(grant.TemplateIDs) == 0 && len(grant.Params) == 0 {
+			return fmt.Errorf("grant %d must list template_ids or params", i)
+		}
+		for _, id := range grant.TemplateIDs {
+			if id <= 0 {
+				return fmt.Errorf("grant %d: invalid template ID %d", i, id)
+			}
+		}
+		for group, values := range grant.Params {
+			if strings.TrimSpace(group) == "" {
+				return fmt.Errorf("grant %d: group name is required", i)
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("grant %d: group %s must allow at least one value", i, group)
+			}
+		}
 	}
 
-	// Split the key scope into individual scopes
+	return nil
+}
+
+// hasRequiredScope checks if the API key scope includes the required scope
+func (m *APIKeyManager) hasRequiredScope(keyScope, requiredScope string) bool {
+	// Split the key scope into individual scopes; the admin scope includes all others
 	scopes := strings.Split(keyScope, ",")
 	for _, scope := range scopes {
-		if strings.TrimSpace(scope) == requiredScope {
+		scope = strings.TrimSpace(scope)
+		if scope == requiredScope || scope == "admin" {
 			return true
 		}
 	}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 type JWTManager struct {
 	secretKey     string
 	tokenDuration time.Duration
+	oidc          *OIDCManager // accepts provider access tokens too, if set
 }
 
 // JWTClaims represents the claims in a JWT token
@@ -85,6 +87,34 @@ func (m *JWTManager) VerifyToken(tokenString string) (*JWTClaims, error) {
 	return claims, nil
 }
 
+// SetOIDCManager makes Authenticate accept access tokens issued by the OIDC
+// provider as well as HNS tokens
+func (m *JWTManager) SetOIDCManager(oidc *OIDCManager) {
+	m.oidc = oidc
+}
+
+// Authenticate verifies a bearer token, either an HNS token or an access token
+// issued by the OIDC provider, and returns its claims with the method that
+// authenticated it, "jwt" or "oidc"
+func (m *JWTManager) Authenticate(ctx context.Context, tokenString string) (*JWTClaims, string, error) {
+	claims, err := m.VerifyToken(tokenString)
+	if err == nil || m.oidc == nil {
+		return claims, "jwt", err
+	}
+
+	user, oidcErr := m.oidc.AuthenticateAccessToken(ctx, tokenString)
+	if oidcErr != nil {
+		return nil, "", fmt.Errorf("%w; %w", err, oidcErr)
+	}
+
+	return &JWTClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     string(user.Role),
+	}, "oidc", nil
+}
+
 // GetTokenExpiration returns the token expiration in seconds
 func (m *JWTManager) GetTokenExpiration() int64 {
 	return int64(m.tokenDuration.Seconds())
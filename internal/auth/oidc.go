@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcLoginTimeout is how long a user has to sign in at the provider
+	oidcLoginTimeout = 10 * time.Minute
+
+	// maxPendingOIDCLogins bounds the logins awaiting their callback, as anyone
+	// can start one
+	maxPendingOIDCLogins = 10000
+)
+
+// Errors returned when signing in through the OIDC provider
+var (
+	ErrOIDCLoginExpired  = errors.New("single sign-on login is unknown or has expired")
+	ErrOIDCTooManyLogins = errors.New("too many single sign-on logins in progress")
+	ErrUserInactive      = errors.New("user account is inactive")
+
+	// ErrOIDCAccessTokensDisabled is returned for a provider access token when
+	// no audience is configured for them
+	ErrOIDCAccessTokensDisabled = errors.New("OIDC access tokens are not accepted without a configured audience")
+)
+
+// pendingLogin is a login sent to the provider, awaiting its callback
+type pendingLogin struct {
+	verifier    string // PKCE code verifier
+	nonce       string
+	redirectURL string
+	returnURL   string
+	expires     time.Time
+}
+
+// oidcIdentity is what the provider's tokens say about a user
+type oidcIdentity struct {
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	Groups        []string
+	HasGroups     bool // the token carries the groups claim
+}
+
+// OIDCManager signs users in through an OpenID Connect provider, with the
+// authorization code flow and PKCE, and accepts the access tokens it issues.
+// Users are provisioned at their first sign-in, with a role mapped from their
+// groups at the provider. Access tokens are only accepted for a configured
+// audience other than the client ID, so that ID tokens are not.
+type OIDCManager struct {
+	config   config.OIDCConfig
+	provider *oidcProvider
+	userRepo repository.UserRepository
+	auditSvc *service.AuditService
+
+	mu      sync.Mutex
+	pending map[string]*pendingLogin // by state
+}
+
+// NewOIDCManager creates a new OIDCManager for the provider in oidcConfig
+func NewOIDCManager(userRepo repository.UserRepository, auditSvc *service.AuditService, oidcConfig config.OIDCConfig) (*OIDCManager, error) {
+	if oidcConfig.ClientID == "" {
+		return nil, fmt.Errorf("OIDC client ID is required")
+	}
+	if u, err := url.Parse(oidcConfig.ExternalURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("OIDC external URL %q must be an absolute URL", oidcConfig.ExternalURL)
+	}
+
+	if oidcConfig.UsernameClaim == "" {
+		oidcConfig.UsernameClaim = "preferred_username"
+	}
+	if oidcConfig.GroupsClaim == "" {
+		oidcConfig.GroupsClaim = "groups"
+	}
+	if oidcConfig.DefaultRole == "" {
+		oidcConfig.DefaultRole = string(models.RoleUser)
+	}
+	if oidcConfig.Audience != "" && oidcConfig.Audience == oidcConfig.ClientID {
+		return nil, fmt.Errorf("OIDC audience must differ from the client ID, which ID tokens are issued for")
+	}
+	if len(oidcConfig.Scopes) == 0 {
+		oidcConfig.Scopes = []string{"profile", "email"}
+	}
+	oidcConfig.ExternalURL = strings.TrimSuffix(oidcConfig.ExternalURL, "/")
+
+	return &OIDCManager{
+		config:   oidcConfig,
+		provider: newOIDCProvider(oidcConfig.Issuer),
+		userRepo: userRepo,
+		auditSvc: auditSvc,
+		pending:  make(map[string]*pendingLogin),
+	}, nil
+}
+
+// CheckRoles checks that the default role and every mapped role exist, so that
+// a misconfiguration fails at startup rather than at sign-in
+func (m *OIDCManager) CheckRoles(ctx context.Context, authz *service.AuthorizationService) error {
+	if err := authz.ValidateRole(ctx, m.config.DefaultRole); err != nil {
+		return fmt.Errorf("invalid OIDC default role: %w", err)
+	}
+	for _, mapping := range m.config.RoleMappings {
+		if err := authz.ValidateRole(ctx, mapping.Role); err != nil {
+			return fmt.Errorf("invalid OIDC role mapping for group %s: %w", mapping.Group, err)
+		}
+	}
+	return nil
+}
+
+// LoginURL starts a login, returning the provider URL to send the user to and
+// the state identifying the login. The provider sends the user back to
+// callbackPath under the external URL; returnURL is handed back when the login
+// completes.
+func (m *OIDCManager) LoginURL(ctx context.Context, callbackPath, returnURL string) (string, string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+
+	login := &pendingLogin{
+		verifier:    verifier,
+		nonce:       nonce,
+		redirectURL: m.config.ExternalURL + callbackPath,
+		returnURL:   returnURL,
+		expires:     time.Now().Add(oidcLoginTimeout),
+	}
+
+	scopes := append([]string{"openid"}, m.config.Scopes...)
+	authURL, err := m.provider.authCodeURL(ctx, m.config.ClientID, login.redirectURL, scopes, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := m.addPending(state, login); err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// CompleteLogin finishes the login identified by state, redeeming the code the
+// provider sent the user back with. It returns the signed-in user, provisioned
+// or updated from their ID token, and the return URL the login was started with.
+func (m *OIDCManager) CompleteLogin(ctx context.Context, state, code string) (*models.User, string, error) {
+	login := m.takePending(state)
+	if login == nil {
+		return nil, "", ErrOIDCLoginExpired
+	}
+
+	idToken, err := m.provider.exchange(ctx, m.config.ClientID, m.config.ClientSecret, login.redirectURL, code, login.verifier)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims, err := m.provider.verify(ctx, idToken, m.config.ClientID)
+	if err != nil {
+		return nil, "", err
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return nil, "", fmt.Errorf("invalid OIDC token: nonce mismatch")
+	}
+
+	user, err := m.provision(ctx, m.identity(claims), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, login.returnURL, nil
+}
+
+// AuthenticateAccessToken returns the user an access token issued by the
+// provider was issued to, provisioning them if it is their first visit. Their
+// role follows the groups in the token, if it carries the groups claim.
+func (m *OIDCManager) AuthenticateAccessToken(ctx context.Context, token string) (*models.User, error) {
+	if m.config.Audience == "" {
+		return nil, ErrOIDCAccessTokensDisabled
+	}
+
+	claims, err := m.provider.verify(ctx, token, m.config.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := m.identity(claims)
+	return m.provision(ctx, identity, identity.HasGroups)
+}
+
+// addPending records a login awaiting its callback, dropping expired ones
+func (m *OIDCManager) addPending(state string, login *pendingLogin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.pending) >= maxPendingOIDCLogins {
+		now := time.Now()
+		for s, l := range m.pending {
+			if now.After(l.expires) {
+				delete(m.pending, s)
+			}
+		}
+		if len(m.pending) >= maxPendingOIDCLogins {
+			return ErrOIDCTooManyLogins
+		}
+	}
+
+	m.pending[state] = login
+	return nil
+}
+
+// takePending removes and returns the unexpired login with the given state
+func (m *OIDCManager) takePending(state string) *pendingLogin {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	login, ok := m.pending[state]
+	if !ok {
+		return nil
+	}
+	delete(m.pending, state)
+
+	if time.Now().After(login.expires) {
+		return nil
+	}
+	return login
+}
+
+// identity reads a user's identity from verified token claims
+func (m *OIDCManager) identity(claims jwt.MapClaims) *oidcIdentity {
+	identity := &oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims[m.config.UsernameClaim].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.FirstName, _ = claims["given_name"].(string)
+	identity.LastName, _ = claims["family_name"].(string)
+
+	// The groups claim may be nested, such as realm_access.roles
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(m.config.GroupsClaim, ".") {
+		object, _ := value.(map[string]interface{})
+		value = object[part]
+	}
+	identity.HasGroups = value != nil
+	switch groups := value.(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+
+	return identity
+}
+
+// role returns the role the first mapping matching one of groups gives, or the default role
+func (m *OIDCManager) role(groups []string) models.Role {
+	for _, mapping := range m.config.RoleMappings {
+		for _, group := range groups {
+			if group == mapping.Group {
+				return models.Role(mapping.Role)
+			}
+		}
+	}
+	return models.Role(m.config.DefaultRole)
+}
+
+// provision returns the user identity belongs to, linking or creating their
+// account at their first sign-in. With syncRole, the role of an existing user
+// follows their groups, when role mappings are configured.
+func (m *OIDCManager) provision(ctx context.Context, identity *oidcIdentity, syncRole bool) (*models.User, error) {
+	user, err := m.userRepo.GetByOIDCSubject(ctx, m.provider.issuer, identity.Subject)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		user, err = m.link(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return m.create(ctx, identity)
+		}
+	}
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	if role := m.role(identity.Groups); syncRole && len(m.config.RoleMappings) > 0 && user.Role != role {
+		before := *user
+		user.Role = role
+		if err := m.userRepo.Update(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to update role: %w", err)
+		}
+		m.auditSvc.Record(actorContext(ctx, user), models.AuditEntityUser, user.ID, models.AuditActionUpdate, &before, user)
+	}
+
+	return user, nil
+}
+
+// link links identity to the existing account with the same verified email,
+// if enabled, returning nil if there is none
+func (m *OIDCManager) link(ctx context.Context, identity *oidcIdentity) (*models.User, error) {
+	if !m.config.LinkByEmail || !identity.EmailVerified || identity.Email == "" {
+		return nil, nil
+	}
+
+	user, err := m.userRepo.GetByEmail(ctx, identity.Email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if user.OIDCSubject != "" {
+		return nil, fmt.Errorf("the account with email %s is linked to another single sign-on identity", identity.Email)
+	}
+
+	before := *user
+	user.OIDCIssuer = m.provider.issuer
+	user.OIDCSubject = identity.Subject
+	if err := m.userRepo.Update(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to link user: %w", err)
+	}
+	m.auditSvc.Record(actorContext(ctx, user), models.AuditEntityUser, user.ID, models.AuditActionUpdate, &before, user)
+
+	return user, nil
+}
+
+// create provisions an account for identity. It has no password, so the user
+// can only sign in through the provider.
+func (m *OIDCManager) create(ctx context.Context, identity *oidcIdentity) (*models.User, error) {
+	if identity.Username == "" {
+		return nil, fmt.Errorf("OIDC token has no %s claim to name the user by", m.config.UsernameClaim)
+	}
+	if len(identity.Username) > 50 {
+		return nil, fmt.Errorf("username %q is longer than 50 characters", identity.Username)
+	}
+	if identity.Email == "" {
+		return nil, fmt.Errorf("OIDC token has no email claim")
+	}
+
+	if _, err := m.userRepo.GetByUsername(ctx, identity.Username); err == nil {
+		return nil, fmt.Errorf("username %s is already taken by another account", identity.Username)
+	} else if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if _, err := m.userRepo.GetByEmail(ctx, identity.Email); err == nil {
+		return nil, fmt.Errorf("email %s is already used by another account", identity.Email)
+	} else if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	user := &models.User{
+		Username:    identity.Username,
+		Email:       identity.Email,
+		FirstName:   identity.FirstName,
+		LastName:    identity.LastName,
+		Role:        m.role(identity.Groups),
+		IsActive:    true,
+		OIDCIssuer:  m.provider.issuer,
+		OIDCSubject: identity.Subject,
+	}
+	if err := m.userRepo.Create(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	m.auditSvc.Record(actorContext(ctx, user), models.AuditEntityUser, user.ID, models.AuditActionCreate, nil, user)
+
+	return user, nil
+}
+
+// actorContext returns ctx with user as the actor, to audit changes made as
+// they sign in. Request details already in ctx are kept.
+func actorContext(ctx context.Context, user *models.User) context.Context {
+	actor, _ := service.ActorFromContext(ctx)
+	actor.UserID = user.ID
+	actor.Username = user.Username
+	actor.AuthMethod = "oidc"
+	return service.WithActor(ctx, actor)
+}
@@ -0,0 +1,604 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "hns"
+	testAudience = "hns-api"
+	testKeyID    = "test-key"
+)
+
+// authorization is a code the test issuer handed out, with what it was bound to
+type authorization struct {
+	challenge   string // PKCE S256 challenge
+	redirectURL string
+	claims      jwt.MapClaims // ID token claims beside iss, aud, exp and iat
+}
+
+// testIssuer is a local OIDC provider serving discovery, its signing keys and a
+// token endpoint that redeems codes registered with authorize
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// startTestIssuer starts a test issuer
+func startTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	issuer := &testIssuer{key: key, codes: make(map[string]*authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kid: testKeyID,
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// token redeems a code, checking the PKCE verifier against its challenge
+func (ti *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	refuse := func(description string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidcTokenResponse{Error: "invalid_grant", ErrorDescription: description})
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		refuse("unsupported grant")
+		return
+	}
+
+	ti.mu.Lock()
+	auth, ok := ti.codes[r.PostForm.Get("code")]
+	delete(ti.codes, r.PostForm.Get("code"))
+	ti.mu.Unlock()
+	if !ok {
+		refuse("unknown code")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		refuse("code verifier does not match the challenge")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.redirectURL || r.PostForm.Get("client_id") != testClientID {
+		refuse("wrong client or redirect URI")
+		return
+	}
+
+	claims := ti.claims(testClientID)
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	json.NewEncoder(w).Encode(oidcTokenResponse{IDToken: ti.sign(claims, ti.key, testKeyID), AccessToken: "opaque"})
+}
+
+// authorize plays the user signing in at authURL: it returns a code bound to the
+// login's PKCE challenge and redirect URI, redeemed for an ID token carrying the
+// login's nonce and claims
+func (ti *testIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("failed to parse auth URL: %v", err)
+	}
+	query := u.Query()
+	if !strings.HasPrefix(authURL, ti.server.URL+"/authorize?") {
+		t.Fatalf("expected the provider's authorization endpoint, got %s", authURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("expected an S256 PKCE challenge, got %q", query.Get("code_challenge_method"))
+	}
+	if query.Get("client_id") != testClientID || query.Get("scope") != "openid profile email" {
+		t.Fatalf("unexpected client or scopes: %s", u.RawQuery)
+	}
+
+	idClaims := jwt.MapClaims{"nonce": query.Get("nonce")}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+	ti.mu.Lock()
+	ti.codes[code] = &authorization{
+		challenge:   query.Get("code_challenge"),
+		redirectURL: query.Get("redirect_uri"),
+		claims:      idClaims,
+	}
+	ti.mu.Unlock()
+
+	return code
+}
+
+// claims returns valid registered claims for a token issued for audience
+func (ti *testIssuer) claims(audience string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": ti.server.URL,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// sign signs claims with key, naming kid
+func (ti *testIssuer) sign(claims jwt.MapClaims, key *rsa.PrivateKey, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// fakeUserRepository keeps users in memory; lookups fail with err when it is set
+type fakeUserRepository struct {
+	repository.UserRepository
+
+	users []*models.User
+	err   error
+}
+
+// find returns a copy of the first user match accepts
+func (r *fakeUserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, user := range r.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *fakeUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username })
+}
+
+func (r *fakeUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Email == email })
+}
+
+func (r *fakeUserRepository) GetByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.OIDCIssuer == issuer && u.OIDCSubject == subject })
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *models.User) error {
+	user.ID = int64(len(r.users) + 1)
+	created := *user
+	r.users = append(r.users, &created)
+	return nil
+}
+
+func (r *fakeUserRepository) Update(ctx context.Context, user *models.User) error {
+	for i, existing := range r.users {
+		if existing.ID == user.ID {
+			updated := *user
+			r.users[i] = &updated
+			return nil
+		}
+	}
+	return repository.ErrUserNotFound
+}
+
+// fakeRoleRepository knows the roles it is given by name
+type fakeRoleRepository struct {
+	repository.RoleRepository
+
+	roles []string
+}
+
+func (r *fakeRoleRepository) GetByName(ctx context.Context, name string) (*models.RoleDefinition, error) {
+	for _, role := range r.roles {
+		if role == name {
+			return &models.RoleDefinition{Name: models.Role(name)}, nil
+		}
+	}
+	return nil, errors.New("role not found")
+}
+
+// newTestOIDCManager returns a manager for issuer, mapping hns-admins to admin
+func newTestOIDCManager(t *testing.T, issuer *testIssuer, users *fakeUserRepository) *OIDCManager {
+	t.Helper()
+
+	m, err := NewOIDCManager(users, nil, config.OIDCConfig{
+		Issuer:       issuer.server.URL,
+		ClientID:     testClientID,
+		ExternalURL:  "https://hns.example.com/",
+		Audience:     testAudience,
+		RoleMappings: []config.OIDCRoleMapping{{Group: "hns-admins", Role: "admin"}},
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCManager failed: %v", err)
+	}
+	return m
+}
+
+// signIn runs a login through the provider for a user with claims
+func signIn(t *testing.T, m *OIDCManager, issuer *testIssuer, claims jwt.MapClaims) (*models.User, error) {
+	t.Helper()
+
+	authURL, state, err := m.LoginURL(context.Background(), "/auth/oidc/callback", "/dashboard")
+	if err != nil {
+		t.Fatalf("LoginURL failed: %v", err)
+	}
+	user, _, err := m.CompleteLogin(context.Background(), state, issuer.authorize(t, authURL, claims))
+	return user, err
+}
+
+// aliceClaims are the ID token claims of a provider user in the hns-admins group
+func aliceClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":                "alice-sub",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"groups":             []string{"staff", "hns-admins"},
+	}
+}
+
+func TestOIDCLoginProvisionsUserWithMappedRole(t *testing.T) {
+	issuer := startTestIssuer(t)
+	users := &fakeUserRepository{}
+	m := newTestOIDCManager(t, issuer, users)
+
+	authURL, state, err := m.LoginURL(context.Background(), "/auth/oidc/callback", "/dashboard")
+	if err != nil {
+		t.Fatalf("LoginURL failed: %v", err)
+	}
+	if redirect := mustQuery(t, authURL).Get("redirect_uri"); redirect != "https://hns.example.com/auth/oidc/callback" {
+		t.Errorf("expected the callback under the external URL, got %s", redirect)
+	}
+
+	code := issuer.authorize(t, authURL, aliceClaims())
+	user, returnURL, err := m.CompleteLogin(context.Background(), state, code)
+	if err != nil {
+		t.Fatalf("CompleteLogin failed: %v", err)
+	}
+	if returnURL != "/dashboard" {
+		t.Errorf("expected return URL /dashboard, got %s", returnURL)
+	}
+	if user.Username != "alice" || user.Role != models.RoleAdmin || user.OIDCSubject != "alice-sub" || user.OIDCIssuer != issuer.server.URL {
+		t.Fatalf("expected alice provisioned as admin and linked to her subject, got %+v", user)
+	}
+	if len(users.users) != 1 {
+		t.Fatalf("expected one provisioned user, got %d", len(users.users))
+	}
+
+	// A state is good for one login only
+	if _, _, err := m.CompleteLogin(context.Background(), state, code); !errors.Is(err, ErrOIDCLoginExpired) {
+		t.Fatalf("expected a reused state to be refused with ErrOIDCLoginExpired, got %v", err)
+	}
+}
+
+func TestOIDCLoginDefaultRoleAndRoleSync(t *testing.T) {
+	issuer := startTestIssuer(t)
+	users := &fakeUserRepository{}
+	m := newTestOIDCManager(t, issuer, users)
+
+	claims := aliceClaims()
+	claims["groups"] = []string{"staff"}
+	user, err := signIn(t, m, issuer, claims)
+	if err != nil {
+		t.Fatalf("first sign-in failed: %v", err)
+	}
+	if user.Role != models.RoleUser {
+		t.Fatalf("expected the default role, got %s", user.Role)
+	}
+
+	// Joining the mapped group promotes her at her next sign-in
+	user, err = signIn(t, m, issuer, aliceClaims())
+	if err != nil {
+		t.Fatalf("second sign-in failed: %v", err)
+	}
+	if user.Role != models.RoleAdmin || users.users[0].Role != models.RoleAdmin {
+		t.Fatalf("expected alice promoted to admin, got %s", user.Role)
+	}
+	if len(users.users) != 1 {
+		t.Fatalf("expected alice's account to be reused, got %d users", len(users.users))
+	}
+}
+
+func TestOIDCLoginRefusals(t *testing.T) {
+	issuer := startTestIssuer(t)
+	m := newTestOIDCManager(t, issuer, &fakeUserRepository{})
+	ctx := context.Background()
+
+	t.Run("unknown state", func(t *testing.T) {
+		if _, _, err := m.CompleteLogin(ctx, "unknown", "code"); !errors.Is(err, ErrOIDCLoginExpired) {
+			t.Fatalf("expected ErrOIDCLoginExpired, got %v", err)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		// The ID token carries a nonce other than the one the login sent
+		claims := aliceClaims()
+		claims["nonce"] = "replayed-nonce"
+		if _, err := signIn(t, m, issuer, claims); err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+			t.Fatalf("expected a nonce mismatch, got %v", err)
+		}
+	})
+
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		// The code was issued to another login, so this one's verifier does not match
+		otherURL, _, err := m.LoginURL(ctx, "/auth/oidc/callback", "")
+		if err != nil {
+			t.Fatalf("LoginURL failed: %v", err)
+		}
+		code := issuer.authorize(t, otherURL, aliceClaims())
+		_, state, err := m.LoginURL(ctx, "/auth/oidc/callback", "")
+		if err != nil {
+			t.Fatalf("LoginURL failed: %v", err)
+		}
+
+		if _, _, err := m.CompleteLogin(ctx, state, code); err == nil || !strings.Contains(err.Error(), "code verifier") {
+			t.Fatalf("expected the provider to refuse the code verifier, got %v", err)
+		}
+	})
+
+	t.Run("missing username", func(t *testing.T) {
+		claims := aliceClaims()
+		claims["sub"] = "nameless-sub"
+		delete(claims, "preferred_username")
+		if _, err := signIn(t, m, issuer, claims); err == nil || !strings.Contains(err.Error(), "preferred_username") {
+			t.Fatalf("expected a missing username claim to be refused, got %v", err)
+		}
+	})
+}
+
+func TestOIDCAccessTokens(t *testing.T) {
+	issuer := startTestIssuer(t)
+	users := &fakeUserRepository{}
+	m := newTestOIDCManager(t, issuer, users)
+	ctx := context.Background()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	// accessToken returns a token for alice issued for the API audience, changed by edit
+	accessToken := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+		claims := issuer.claims(testAudience)
+		for name, value := range aliceClaims() {
+			claims[name] = value
+		}
+		if edit != nil {
+			edit(claims)
+		}
+		return claims
+	}
+
+	user, err := m.AuthenticateAccessToken(ctx, issuer.sign(accessToken(nil), issuer.key, testKeyID))
+	if err != nil {
+		t.Fatalf("AuthenticateAccessToken failed: %v", err)
+	}
+	if user.Username != "alice" || user.Role != models.RoleAdmin {
+		t.Fatalf("expected alice provisioned as admin, got %+v", user)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "ID token",
+			token: issuer.sign(accessToken(func(c jwt.MapClaims) { c["aud"] = testClientID }), issuer.key, testKeyID),
+			want:  "audience",
+		},
+		{
+			name:  "wrong audience",
+			token: issuer.sign(accessToken(func(c jwt.MapClaims) { c["aud"] = "other-api" }), issuer.key, testKeyID),
+			want:  "audience",
+		},
+		{
+			name:  "wrong issuer",
+			token: issuer.sign(accessToken(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }), issuer.key, testKeyID),
+			want:  "issuer",
+		},
+		{
+			name:  "unknown key",
+			token: issuer.sign(accessToken(nil), otherKey, "other-key"),
+			want:  "unknown signing key",
+		},
+		{
+			name:  "known key ID, wrong key",
+			token: issuer.sign(accessToken(nil), otherKey, testKeyID),
+			want:  "signature",
+		},
+		{
+			name:  "expired",
+			token: issuer.sign(accessToken(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), issuer.key, testKeyID),
+			want:  "expired",
+		},
+		{
+			name:  "no expiry",
+			token: issuer.sign(accessToken(func(c jwt.MapClaims) { delete(c, "exp") }), issuer.key, testKeyID),
+			want:  "no expiry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.AuthenticateAccessToken(ctx, tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("role follows groups claim", func(t *testing.T) {
+		// Without the groups claim the role is left alone
+		user, err := m.AuthenticateAccessToken(ctx, issuer.sign(accessToken(func(c jwt.MapClaims) { delete(c, "groups") }), issuer.key, testKeyID))
+		if err != nil {
+			t.Fatalf("AuthenticateAccessToken failed: %v", err)
+		}
+		if user.Role != models.RoleAdmin {
+			t.Fatalf("expected alice to stay admin, got %s", user.Role)
+		}
+
+		// Leaving the mapped group demotes her on her next request
+		user, err = m.AuthenticateAccessToken(ctx, issuer.sign(accessToken(func(c jwt.MapClaims) { c["groups"] = []string{} }), issuer.key, testKeyID))
+		if err != nil {
+			t.Fatalf("AuthenticateAccessToken failed: %v", err)
+		}
+		if user.Role != models.RoleUser || users.users[0].Role != models.RoleUser {
+			t.Fatalf("expected alice demoted to user, got %s", user.Role)
+		}
+	})
+}
+
+func TestOIDCAccessTokenAudienceConfiguration(t *testing.T) {
+	issuer := startTestIssuer(t)
+	oidcConfig := config.OIDCConfig{
+		Issuer:      issuer.server.URL,
+		ClientID:    testClientID,
+		ExternalURL: "https://hns.example.com",
+	}
+
+	// Without an audience, provider access tokens are refused outright
+	m, err := NewOIDCManager(&fakeUserRepository{}, nil, oidcConfig)
+	if err != nil {
+		t.Fatalf("NewOIDCManager failed: %v", err)
+	}
+	token := issuer.sign(issuer.claims(testClientID), issuer.key, testKeyID)
+	if _, err := m.AuthenticateAccessToken(context.Background(), token); !errors.Is(err, ErrOIDCAccessTokensDisabled) {
+		t.Fatalf("expected ErrOIDCAccessTokensDisabled, got %v", err)
+	}
+
+	// The client ID as audience would let ID tokens through
+	oidcConfig.Audience = testClientID
+	if _, err := NewOIDCManager(&fakeUserRepository{}, nil, oidcConfig); err == nil {
+		t.Fatal("expected the client ID to be refused as audience")
+	}
+}
+
+func TestOIDCLookupFailureIsNotNotFound(t *testing.T) {
+	issuer := startTestIssuer(t)
+	users := &fakeUserRepository{err: errors.New("connection refused")}
+	m := newTestOIDCManager(t, issuer, users)
+
+	_, err := signIn(t, m, issuer, aliceClaims())
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the lookup failure to be returned, got %v", err)
+	}
+	if len(users.users) != 0 {
+		t.Fatalf("expected no user to be provisioned, got %d", len(users.users))
+	}
+}
+
+func TestOIDCLinkByEmail(t *testing.T) {
+	issuer := startTestIssuer(t)
+	users := &fakeUserRepository{users: []*models.User{{ID: 1, Username: "alice.local", Email: "alice@example.com", Role: models.RoleUser, IsActive: true}}}
+	m, err := NewOIDCManager(users, nil, config.OIDCConfig{
+		Issuer:      issuer.server.URL,
+		ClientID:    testClientID,
+		ExternalURL: "https://hns.example.com",
+		LinkByEmail: true,
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCManager failed: %v", err)
+	}
+
+	claims := aliceClaims()
+	claims["email_verified"] = false
+	if _, err := signIn(t, m, issuer, claims); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("expected an unverified email not to link, got %v", err)
+	}
+
+	user, err := signIn(t, m, issuer, aliceClaims())
+	if err != nil {
+		t.Fatalf("sign-in failed: %v", err)
+	}
+	if user.ID != 1 || users.users[0].OIDCSubject != "alice-sub" {
+		t.Fatalf("expected the existing account to be linked, got %+v", users.users[0])
+	}
+}
+
+func TestOIDCCheckRoles(t *testing.T) {
+	issuer := startTestIssuer(t)
+	authz := service.NewAuthorizationService(&fakeRoleRepository{roles: []string{"admin", "user"}}, nil, nil)
+	ctx := context.Background()
+
+	m := newTestOIDCManager(t, issuer, &fakeUserRepository{})
+	if err := m.CheckRoles(ctx, authz); err != nil {
+		t.Fatalf("expected existing roles to pass, got %v", err)
+	}
+
+	m.config.RoleMappings = append(m.config.RoleMappings, config.OIDCRoleMapping{Group: "hns-ops", Role: "operator"})
+	if err := m.CheckRoles(ctx, authz); !errors.Is(err, service.ErrRoleNotFound) || !strings.Contains(err.Error(), "hns-ops") {
+		t.Fatalf("expected the unknown mapped role to be refused, got %v", err)
+	}
+
+	m.config.RoleMappings = nil
+	m.config.DefaultRole = "guest"
+	if err := m.CheckRoles(ctx, authz); !errors.Is(err, service.ErrRoleNotFound) {
+		t.Fatalf("expected the unknown default role to be refused, got %v", err)
+	}
+}
+
+// mustQuery returns the query parameters of rawURL
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", rawURL, err)
+	}
+	return u.Query()
+}
@@ -9,11 +9,12 @@ import (
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Auth     AuthConfig
-	DNS      DNSConfig
-	Logging  LoggingConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Auth        AuthConfig
+	DNS         DNSConfig
+	Reservation ReservationConfig
+	Logging     LoggingConfig
 }
 
 // ServerConfig holds the server configuration
@@ -38,15 +39,128 @@ type DatabaseConfig struct {
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
-	JWTSecret        string
-	JWTExpiration    time.Duration
-	APIKeyExpiration time.Duration
+	JWTSecret           string
+	JWTExpiration       time.Duration
+	APIKeyExpiration    time.Duration // lifetime of a new key unless its creator picks one
+	APIKeyMaxExpiration time.Duration // longest lifetime a creator may pick
+	APIKeyRotationGrace time.Duration // how long a rotated key stays valid beside its successor
+
+	// AcceptLegacyAPIKeys lets keys issued before key prefixes keep working until
+	// rotated; turn it off to force their rotation
+	AcceptLegacyAPIKeys bool
+
+	OIDC OIDCConfig // single sign-on, disabled unless an issuer is set
+}
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider.
+// Empty claim names and DefaultRole take defaults.
+type OIDCConfig struct {
+	Issuer       string   `mapstructure:"issuer"` // provider URL, discovered at <issuer>/.well-known/openid-configuration
+	ClientID     string   `mapstructure:"clientID"`
+	ClientSecret string   `mapstructure:"clientSecret"` // empty for a public client, which PKCE alone protects
+	ExternalURL  string   `mapstructure:"externalURL"`  // URL HNS is reached at, under which the login callbacks are registered
+	Scopes       []string `mapstructure:"scopes"`       // requested beside openid
+	Audience     string   `mapstructure:"audience"`     // audience access tokens must be issued for; none are accepted if empty
+
+	UsernameClaim string `mapstructure:"usernameClaim"` // preferred_username if empty
+	GroupsClaim   string `mapstructure:"groupsClaim"`   // groups if empty; a dotted path reaches a nested claim
+
+	// Users are given the role of the first mapping whose group they are in, or
+	// DefaultRole, which is user if empty. Roles are updated at every sign-in,
+	// and by access tokens that carry the groups claim.
+	RoleMappings []OIDCRoleMapping `mapstructure:"roleMappings"`
+	DefaultRole  string            `mapstructure:"defaultRole"`
+
+	// LinkByEmail links a first sign-in to an existing account with the same,
+	// verified, email instead of provisioning a new one
+	LinkByEmail bool `mapstructure:"linkByEmail"`
+}
+
+// OIDCRoleMapping gives members of a provider group an HNS role
+type OIDCRoleMapping struct {
+	Group string `mapstructure:"group"`
+	Role  string `mapstructure:"role"`
 }
 
 // DNSConfig holds DNS configuration
 type DNSConfig struct {
-	Servers []string
-	Timeout time.Duration
+	Servers       []string            // plain DNS servers, as host or host:port
+	Resolvers     []DNSResolverConfig // servers reached over a chosen protocol, tried after Servers
+	SearchDomains []string            // tried in order when checking names that aren't fully qualified
+	Timeout       time.Duration
+	Updates       []DNSUpdateConfig   // dynamic updates, empty to leave DNS records alone
+	Transfers     []DNSTransferConfig // zones that may be transferred for discovery
+	Authoritative DNSServerConfig     // built-in server answering for committed hostnames
+
+	// A server is skipped for BreakerCooldown after BreakerThreshold consecutive failures
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	ReconcileInterval time.Duration // how often hostnames are checked for DNS drift, 0 to disable
+
+	CacheSize   int           // answers kept by the lookup cache, 0 to disable it
+	CacheMaxTTL time.Duration // upper bound on how long an answer is cached, whatever its TTL
+}
+
+// DNSResolverConfig configures a DNS server the checker queries and how it is reached
+type DNSResolverConfig struct {
+	Address    string `mapstructure:"address"`    // host or IP, or for https a full URL
+	Protocol   string `mapstructure:"protocol"`   // udp (the default), tcp, tls or https
+	Port       int    `mapstructure:"port"`       // 53, 853 for tls and 443 for https if not set
+	ServerName string `mapstructure:"serverName"` // name the TLS certificate must match, the address's host if empty
+	CABundle   string `mapstructure:"caBundle"`   // PEM file of the CAs trusted for tls and https, the system roots if empty
+}
+
+// DNSKeyConfig identifies the TSIG key used to sign messages to a primary server
+type DNSKeyConfig struct {
+	KeyName      string `mapstructure:"keyName"`
+	KeySecret    string `mapstructure:"keySecret"` // base64, as in a BIND key statement
+	KeyAlgorithm string `mapstructure:"keyAlgorithm"`
+}
+
+// DNSUpdateConfig configures RFC 2136 dynamic updates for the hostnames of one template
+type DNSUpdateConfig struct {
+	Template     string   `mapstructure:"template"`     // template name, or "*" for templates without their own entry
+	Server       string   `mapstructure:"server"`       // primary server accepting updates, as host or host:port
+	Zone         string   `mapstructure:"zone"`         // forward zone the A and AAAA records are added to
+	ReverseZones []string `mapstructure:"reverseZones"` // PTR records are added when the address falls in one of these
+	TTL          uint32   `mapstructure:"ttl"`
+	DNSKeyConfig `mapstructure:",squash"`
+}
+
+// DNSTransferConfig configures AXFR and IXFR transfers of one zone
+type DNSTransferConfig struct {
+	Zone         string `mapstructure:"zone"`
+	Server       string `mapstructure:"server"` // primary server allowing transfers, as host or host:port
+	DNSKeyConfig `mapstructure:",squash"`
+}
+
+// DNSServerConfig configures the built-in authoritative DNS server, which answers
+// for a zone from the hostnames table. Zero SOA timers and TTLs take defaults.
+type DNSServerConfig struct {
+	Listen        string   `mapstructure:"listen"`        // address to serve on over UDP and TCP, such as ":53"; empty to disable
+	Zone          string   `mapstructure:"zone"`          // committed hostnames are served as names in this zone
+	Nameservers   []string `mapstructure:"nameservers"`   // NS records of the zone, ns.<zone> if empty
+	Hostmaster    string   `mapstructure:"hostmaster"`    // SOA mailbox, hostmaster.<zone> if empty
+	TTL           uint32   `mapstructure:"ttl"`           // of the address, SOA and NS records
+	AllowTransfer []string `mapstructure:"allowTransfer"` // addresses or CIDR ranges of secondaries allowed AXFR
+
+	// SOA timers in seconds; Minimum is also the TTL of negative answers
+	Refresh uint32 `mapstructure:"refresh"`
+	Retry   uint32 `mapstructure:"retry"`
+	Expire  uint32 `mapstructure:"expire"`
+	Minimum uint32 `mapstructure:"minimum"`
+
+	// TSIG key transfer requests must be signed with, if set
+	DNSKeyConfig `mapstructure:",squash"`
+}
+
+// ReservationConfig holds hostname reservation configuration
+type ReservationConfig struct {
+	DefaultTTL      time.Duration
+	MaxTTL          time.Duration
+	ReaperInterval  time.Duration
+	ReleaseCooldown time.Duration // how long released sequence numbers stay unavailable
 }
 
 // LoggingConfig holds logging configuration
@@ -89,6 +203,27 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	var dnsUpdates []DNSUpdateConfig
+	if err := viper.UnmarshalKey("dns.updates", &dnsUpdates); err != nil {
+		return nil, fmt.Errorf("error reading DNS update configuration: %v", err)
+	}
+	var dnsTransfers []DNSTransferConfig
+	if err := viper.UnmarshalKey("dns.transfers", &dnsTransfers); err != nil {
+		return nil, fmt.Errorf("error reading DNS transfer configuration: %v", err)
+	}
+	var dnsResolvers []DNSResolverConfig
+	if err := viper.UnmarshalKey("dns.resolvers", &dnsResolvers); err != nil {
+		return nil, fmt.Errorf("error reading DNS resolver configuration: %v", err)
+	}
+	var oidc OIDCConfig
+	if err := viper.UnmarshalKey("auth.oidc", &oidc); err != nil {
+		return nil, fmt.Errorf("error reading OIDC configuration: %v", err)
+	}
+	var dnsAuthoritative DNSServerConfig
+	if err := viper.UnmarshalKey("dns.authoritative", &dnsAuthoritative); err != nil {
+		return nil, fmt.Errorf("error reading authoritative DNS server configuration: %v", err)
+	}
+
 	config := &Config{
 		Server: ServerConfig{
 			Port:            viper.GetInt("server.port"),
@@ -110,10 +245,33 @@ func LoadConfig() (*Config, error) {
 			JWTSecret:        viper.GetString("auth.jwtSecret"),
 			JWTExpiration:    viper.GetDuration("auth.jwtExpiration"),
 			APIKeyExpiration: viper.GetDuration("auth.apiKeyExpiration"),
+
+			// API key lifetimes and rotation
+			APIKeyMaxExpiration: viper.GetDuration("auth.apiKeyMaxExpiration"),
+			APIKeyRotationGrace: viper.GetDuration("auth.apiKeyRotationGrace"),
+			AcceptLegacyAPIKeys: viper.GetBool("auth.acceptLegacyAPIKeys"),
+
+			OIDC: oidc,
 		},
 		DNS: DNSConfig{
-			Servers: viper.GetStringSlice("dns.servers"),
-			Timeout: viper.GetDuration("dns.timeout"),
+			Servers:           viper.GetStringSlice("dns.servers"),
+			Resolvers:         dnsResolvers,
+			SearchDomains:     viper.GetStringSlice("dns.searchDomains"),
+			Timeout:           viper.GetDuration("dns.timeout"),
+			Updates:           dnsUpdates,
+			Transfers:         dnsTransfers,
+			Authoritative:     dnsAuthoritative,
+			BreakerThreshold:  viper.GetInt("dns.breakerThreshold"),
+			BreakerCooldown:   viper.GetDuration("dns.breakerCooldown"),
+			ReconcileInterval: viper.GetDuration("dns.reconcileInterval"),
+			CacheSize:         viper.GetInt("dns.cacheSize"),
+			CacheMaxTTL:       viper.GetDuration("dns.cacheMaxTTL"),
+		},
+		Reservation: ReservationConfig{
+			DefaultTTL:      viper.GetDuration("reservation.defaultTTL"),
+			MaxTTL:          viper.GetDuration("reservation.maxTTL"),
+			ReaperInterval:  viper.GetDuration("reservation.reaperInterval"),
+			ReleaseCooldown: viper.GetDuration("reservation.releaseCooldown"),
 		},
 		Logging: LoggingConfig{
 			Level:  viper.GetString("logging.level"),
@@ -121,6 +279,11 @@ func LoadConfig() (*Config, error) {
 		},
 	}
 
+	// Fall back to public resolvers only if none are configured at all
+	if len(config.DNS.Servers) == 0 && len(config.DNS.Resolvers) == 0 {
+		config.DNS.Servers = []string{"8.8.8.8", "8.8.4.4"}
+	}
+
 	return config, nil
 }
 
@@ -145,11 +308,26 @@ func setDefaults() {
 	// Auth defaults
 	viper.SetDefault("auth.jwtSecret", "supersecretkey")
 	viper.SetDefault("auth.jwtExpiration", "24h")
-	viper.SetDefault("auth.apiKeyExpiration", "720h") // 30 days
+	viper.SetDefault("auth.apiKeyExpiration", "720h")     // 30 days
+	viper.SetDefault("auth.apiKeyMaxExpiration", "8760h") // 1 year
+	viper.SetDefault("auth.apiKeyRotationGrace", "24h")
+	viper.SetDefault("auth.acceptLegacyAPIKeys", true)
 
 	// DNS defaults
-	viper.SetDefault("dns.servers", []string{"8.8.8.8", "8.8.4.4"})
+	// dns.servers is defaulted in LoadConfig, so configuring only encrypted
+	// resolvers doesn't leave plain ones in use
 	viper.SetDefault("dns.timeout", "5s")
+	viper.SetDefault("dns.breakerThreshold", 3)
+	viper.SetDefault("dns.breakerCooldown", "30s")
+	viper.SetDefault("dns.reconcileInterval", "1h")
+	viper.SetDefault("dns.cacheSize", 10000)
+	viper.SetDefault("dns.cacheMaxTTL", "1h")
+
+	// Reservation defaults
+	viper.SetDefault("reservation.defaultTTL", "24h")
+	viper.SetDefault("reservation.maxTTL", "720h") // 30 days
+	viper.SetDefault("reservation.reaperInterval", "1m")
+	viper.SetDefault("reservation.releaseCooldown", "720h") // 30 days
 
 	// Logging defaults
 	viper.SetDefault("logging.level", "info")
@@ -0,0 +1,251 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// bypassCacheKey marks a context whose lookups must skip the cache
+type bypassCacheKey struct{}
+
+// WithoutCache returns a context whose DNS lookups go to the servers rather than
+// the cache; the fresh answers still refresh the cache
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+// bypassCache reports whether lookups made with ctx must skip the cache
+func bypassCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
+
+// CacheStats reports how the DNS answer cache is performing
+type CacheStats struct {
+	Capacity     int     `json:"capacity"`
+	Entries      int     `json:"entries"`
+	Hits         int64   `json:"hits"`
+	NegativeHits int64   `json:"negative_hits"` // hits on cached NXDOMAIN or no-data answers
+	Misses       int64   `json:"misses"`
+	Evictions    int64   `json:"evictions"`
+	HitRate      float64 `json:"hit_rate"`
+}
+
+// cacheEntry is a cached answer and when it stops being valid
+type cacheEntry struct {
+	key      string
+	msg      *dns.Msg
+	server   string
+	storedAt time.Time
+	expires  time.Time
+	negative bool
+}
+
+// answerCache is a size-bounded LRU cache of DNS answers. Answers are kept for
+// the lowest TTL among their records; NXDOMAIN and no-data answers for the SOA
+// minimum of their authority section (RFC 2308), or not at all without one.
+// A nil *answerCache caches nothing.
+type answerCache struct {
+	mu       sync.Mutex
+	capacity int
+	maxTTL   time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // most recently used at the front
+	now      func() time.Time
+
+	hits, negativeHits, misses, evictions int64
+}
+
+// newAnswerCache creates a cache holding up to capacity answers for at most
+// maxTTL each, or nil if capacity is not positive
+func newAnswerCache(capacity int, maxTTL time.Duration) *answerCache {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &answerCache{
+		capacity: capacity,
+		maxTTL:   maxTTL,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// cacheKey identifies the answer to a question
+func cacheKey(name string, qtype uint16) string {
+	return strings.ToLower(dns.Fqdn(name)) + "/" + dns.TypeToString[qtype]
+}
+
+// get returns a copy of the cached answer for key, with TTLs reduced by the time
+// it has been cached, and the server that gave it
+func (c *answerCache) get(key string) (*dns.Msg, string, bool) {
+	if c == nil {
+		return nil, "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, "", false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(element)
+		c.misses++
+		return nil, "", false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits++
+	if entry.negative {
+		c.negativeHits++
+	}
+
+	msg := entry.msg.Copy()
+	elapsed := uint32(now.Sub(entry.storedAt) / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			// The TTL field of an OPT record holds flags
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if header := rr.Header(); header.Ttl > elapsed {
+				header.Ttl -= elapsed
+			} else {
+				header.Ttl = 0
+			}
+		}
+	}
+
+	return msg, entry.server, true
+}
+
+// put caches an answer if it may be cached, evicting the least recently used
+// answer when full
+func (c *answerCache) put(key string, msg *dns.Msg, server string) {
+	if c == nil {
+		return
+	}
+
+	ttl, negative, ok := cacheTTL(msg)
+	if !ok {
+		return
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	entry := &cacheEntry{
+		key:      key,
+		msg:      msg.Copy(),
+		server:   server,
+		storedAt: now,
+		expires:  now.Add(ttl),
+		negative: negative,
+	}
+
+	if element, exists := c.entries[key]; exists {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// remove drops an entry; the caller must hold mu
+func (c *answerCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// flush drops every cached answer, returning how many there were
+func (c *answerCache) flush() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flushed := c.order.Len()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	return flushed
+}
+
+// stats returns the cache's counters
+func (c *answerCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Capacity:     c.capacity,
+		Entries:      c.order.Len(),
+		Hits:         c.hits,
+		NegativeHits: c.negativeHits,
+		Misses:       c.misses,
+		Evictions:    c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// cacheTTL returns how long an answer may be cached and whether it is negative.
+// Answers without records are negative and are cached for the lower of the SOA
+// record's TTL and its minimum field.
+func cacheTTL(msg *dns.Msg) (time.Duration, bool, bool) {
+	if msg.Truncated {
+		return 0, false, false
+	}
+
+	negative := msg.Rcode == dns.RcodeNameError || len(msg.Answer) == 0
+	if !negative {
+		ttl := msg.Answer[0].Header().Ttl
+		for _, rr := range msg.Answer[1:] {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		return time.Duration(ttl) * time.Second, false, ttl > 0
+	}
+
+	for _, rr := range msg.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		ttl := soa.Hdr.Ttl
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+		return time.Duration(ttl) * time.Second, true, ttl > 0
+	}
+
+	return 0, true, false
+}
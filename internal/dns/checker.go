@@ -3,6 +3,7 @@ package dns
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bilbothegreedy/HNS/internal/config"
@@ -14,22 +15,75 @@ import (
 // DNSChecker is responsible for DNS-related operations
 type DNSChecker struct {
 	dnsConfig config.DNSConfig
-	dnsClient *dns.Client
+	resolvers map[string]*resolver
+	health    *serverHealth
+	cache     *answerCache
 }
 
-// NewDNSChecker creates a new DNSChecker
-func NewDNSChecker(dnsConfig config.DNSConfig) *DNSChecker {
-	return &DNSChecker{
+// NewDNSChecker creates a new DNSChecker, failing if a resolver is misconfigured
+func NewDNSChecker(dnsConfig config.DNSConfig) (*DNSChecker, error) {
+	resolvers, err := newResolvers(dnsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DNSChecker{
 		dnsConfig: dnsConfig,
-		dnsClient: &dns.Client{
-			Timeout: dnsConfig.Timeout,
-		},
+		resolvers: make(map[string]*resolver, len(resolvers)),
+		cache:     newAnswerCache(dnsConfig.CacheSize, dnsConfig.CacheMaxTTL),
+	}
+
+	names := make([]string, 0, len(resolvers))
+	for _, r := range resolvers {
+		c.resolvers[r.name] = r
+		names = append(names, r.name)
 	}
+	c.health = newServerHealth(names, dnsConfig.BreakerThreshold, dnsConfig.BreakerCooldown)
+
+	return c, nil
+}
+
+// CacheStats returns the hit, miss and eviction counts of the lookup cache
+func (c *DNSChecker) CacheStats() CacheStats {
+	return c.cache.stats()
+}
+
+// FlushCache drops every cached answer, returning how many there were
+func (c *DNSChecker) FlushCache() int {
+	return c.cache.flush()
+}
+
+// Uncached returns a checker whose lookups always go to the DNS servers, for
+// checks that must not rely on cached answers
+func (c *DNSChecker) Uncached() *UncachedChecker {
+	return &UncachedChecker{checker: c}
 }
 
-// CheckHostname checks if a hostname exists in DNS
+// UncachedChecker checks hostnames without consulting the lookup cache
+type UncachedChecker struct {
+	checker *DNSChecker
+}
+
+// CheckHostname checks if a hostname exists in DNS, bypassing the cache
+func (u *UncachedChecker) CheckHostname(ctx context.Context, hostname string) (*models.DNSVerificationResult, error) {
+	return u.checker.CheckHostname(WithoutCache(ctx), hostname)
+}
+
+// ServerHealth returns the latency, failure counts and circuit breaker state of
+// each configured DNS server
+func (c *DNSChecker) ServerHealth() []ResolverHealth {
+	return c.health.snapshot()
+}
+
+// maxCNAMEChain bounds how many aliases are followed before giving up
+const maxCNAMEChain = 8
+
+// CheckHostname checks if a hostname exists in DNS. Names that aren't fully
+// qualified are also tried with each configured search domain: short names with
+// the search domains first, dotted names as given first. For the first name that
+// resolves, the A, AAAA and CNAME records (following the alias chain) and the PTR
+// records of every address found are returned.
 func (c *DNSChecker) CheckHostname(ctx context.Context, hostname string) (*models.DNSVerificationResult, error) {
-	// Add domain suffix if needed
 	if hostname == "" {
 		return nil, fmt.Errorf("empty hostname")
 	}
@@ -41,57 +95,227 @@ func (c *DNSChecker) CheckHostname(ctx context.Context, hostname string) (*model
 		VerifiedAt: time.Now(),
 	}
 
-	// Create A record query
+	// Default to not exists if there is nobody to ask
+	if len(c.resolvers) == 0 {
+		return result, nil
+	}
+
+	for _, candidate := range c.searchNames(hostname) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		records, server, err := c.lookupHost(ctx, candidate.name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		result.Exists = true
+		result.MatchedName = candidate.name
+		result.Domain = candidate.domain
+		result.Server = server
+		result.Records = records
+
+		// Reverse lookups for every address found
+		for _, record := range records {
+			if record.Type != dns.TypeToString[dns.TypeA] && record.Type != dns.TypeToString[dns.TypeAAAA] {
+				continue
+			}
+			if result.IPAddress == "" {
+				result.IPAddress = record.Value
+			}
+			result.Records = append(result.Records, c.lookupPTR(ctx, record.Value)...)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	return result, nil
+}
+
+// searchName is a fully qualified name to try and the search domain it was built with
+type searchName struct {
+	name   string
+	domain string
+}
+
+// searchNames lists the fully qualified names to try for hostname, in order
+func (c *DNSChecker) searchNames(hostname string) []searchName {
+	if dns.IsFqdn(hostname) {
+		return []searchName{{name: hostname}}
+	}
+
+	withDomains := make([]searchName, 0, len(c.dnsConfig.SearchDomains))
+	for _, domain := range c.dnsConfig.SearchDomains {
+		domain = strings.Trim(domain, ".")
+		if domain == "" {
+			continue
+		}
+		withDomains = append(withDomains, searchName{name: dns.Fqdn(hostname + "." + domain), domain: domain})
+	}
+
+	asGiven := searchName{name: dns.Fqdn(hostname)}
+	if strings.Contains(hostname, ".") {
+		return append([]searchName{asGiven}, withDomains...)
+	}
+	return append(withDomains, asGiven)
+}
+
+// lookupHost returns the A, AAAA and CNAME records of a name, following aliases,
+// and the server that answered. No records means the name doesn't exist.
+func (c *DNSChecker) lookupHost(ctx context.Context, name string) ([]models.DNSRecord, string, error) {
+	var records []models.DNSRecord
+	var answeredBy string
+	seen := make(map[string]bool)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		target := name
+		for hops := 0; hops <= maxCNAMEChain && target != ""; hops++ {
+			r, server, err := c.exchange(ctx, target, qtype)
+			if err != nil {
+				return nil, "", err
+			}
+			if answeredBy == "" {
+				answeredBy = server
+			}
+
+			// Recursive servers usually return the whole chain; only chase an alias
+			// whose target wasn't answered
+			next := ""
+			answered := make(map[string]bool)
+			for _, rr := range r.Answer {
+				record := toRecord(rr)
+				if record == nil {
+					continue
+				}
+				answered[strings.ToLower(rr.Header().Name)] = true
+				if cname, ok := rr.(*dns.CNAME); ok {
+					next = cname.Target
+				}
+
+				key := record.Type + " " + record.Name + " " + record.Value
+				if !seen[key] {
+					seen[key] = true
+					records = append(records, *record)
+				}
+			}
+			if next == "" || answered[strings.ToLower(next)] {
+				break
+			}
+			target = next
+		}
+	}
+
+	return records, answeredBy, nil
+}
+
+// lookupPTR returns the PTR records of an address; failures are logged and yield none
+func (c *DNSChecker) lookupPTR(ctx context.Context, address string) []models.DNSRecord {
+	reverseName, err := dns.ReverseAddr(address)
+	if err != nil {
+		return nil
+	}
+
+	r, _, err := c.exchange(ctx, reverseName, dns.TypePTR)
+	if err != nil {
+		log.Warn().Err(err).Str("address", address).Msg("PTR lookup failed")
+		return nil
+	}
+
+	var records []models.DNSRecord
+	for _, rr := range r.Answer {
+		if _, ok := rr.(*dns.PTR); ok {
+			records = append(records, *toRecord(rr))
+		}
+	}
+	return records
+}
+
+// exchange answers a query from the cache, unless ctx bypasses it, or else sends it
+// to the healthy servers in turn and returns the first definitive answer (success
+// or NXDOMAIN) along with the server that gave it. It stops as soon as ctx is
+// cancelled or its deadline passes.
+func (c *DNSChecker) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, string, error) {
+	key := cacheKey(name, qtype)
+	if !bypassCache(ctx) {
+		if r, server, ok := c.cache.get(key); ok {
+			return r, server, nil
+		}
+	}
+
 	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(hostname), dns.TypeA)
+	m.SetQuestion(dns.Fqdn(name), qtype)
 	m.RecursionDesired = true
 
 	// Try DNS servers in sequence
 	var lastErr error
-	for _, server := range c.dnsConfig.Servers {
-		r, _, err := c.dnsClient.Exchange(m, server+":53")
+	for _, server := range c.health.available() {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+
+		start := time.Now()
+		r, err := c.resolvers[server].exchange(ctx, m)
+		latency := time.Since(start)
 		if err != nil {
+			// The caller giving up says nothing about the server
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, "", ctxErr
+			}
+			c.health.failure(server, latency, err)
 			lastErr = err
-			log.Warn().Err(err).Str("server", server).Str("hostname", hostname).Msg("DNS query failed")
+			log.Warn().Err(err).Str("server", server).Str("hostname", name).Msg("DNS query failed")
 			continue
 		}
 
-		// Check for successful response
-		if r.Rcode == dns.RcodeSuccess {
-			// Check if we got any answers
-			if len(r.Answer) > 0 {
-				result.Exists = true
-				// Extract IP address if available
-				for _, ans := range r.Answer {
-					if a, ok := ans.(*dns.A); ok {
-						result.IPAddress = a.A.String()
-						break
-					}
-				}
-			}
-			return result, nil
-		} else if r.Rcode == dns.RcodeNameError {
-			// NXDOMAIN - domain definitely doesn't exist
-			result.Exists = false
-			return result, nil
-		} else {
-			// Other error, try next server
-			lastErr = fmt.Errorf("DNS query returned error code: %d", r.Rcode)
-			log.Warn().
-				Int("rcode", r.Rcode).
-				Str("server", server).
-				Str("hostname", hostname).
-				Msg("DNS query returned error code")
+		if r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError {
+			c.health.success(server, latency)
+			c.cache.put(key, r, server)
+			return r, server, nil
 		}
+
+		// Other error, try next server
+		lastErr = fmt.Errorf("DNS query returned error code: %d", r.Rcode)
+		c.health.failure(server, latency, lastErr)
+		log.Warn().
+			Int("rcode", r.Rcode).
+			Str("server", server).
+			Str("hostname", name).
+			Msg("DNS query returned error code")
 	}
 
 	// If we got here, all servers failed
-	if lastErr != nil {
-		return nil, fmt.Errorf("all DNS servers failed: %w", lastErr)
+	return nil, "", fmt.Errorf("all DNS servers failed: %w", lastErr)
+}
+
+// toRecord converts an address, alias or pointer record, returning nil for other types
+func toRecord(rr dns.RR) *models.DNSRecord {
+	record := &models.DNSRecord{
+		Name: rr.Header().Name,
+		Type: dns.TypeToString[rr.Header().Rrtype],
+		TTL:  rr.Header().Ttl,
 	}
 
-	// Default to not exists if we couldn't determine
-	return result, nil
+	switch typed := rr.(type) {
+	case *dns.A:
+		record.Value = typed.A.String()
+	case *dns.AAAA:
+		record.Value = typed.AAAA.String()
+	case *dns.CNAME:
+		record.Value = typed.Target
+	case *dns.PTR:
+		record.Value = typed.Ptr
+	default:
+		return nil
+	}
+
+	return record
 }
 
 // CheckMultipleHostnames checks multiple hostnames in parallel
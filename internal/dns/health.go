@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	// defaultBreakerThreshold is used when no failure threshold is configured
+	defaultBreakerThreshold = 3
+
+	// defaultBreakerCooldown is used when no cooldown is configured
+	defaultBreakerCooldown = 30 * time.Second
+
+	// latencyWeight is the weight of the newest sample in the average latency
+	latencyWeight = 0.2
+)
+
+// ResolverHealth reports how a DNS server has been responding
+type ResolverHealth struct {
+	Server              string     `json:"server"`
+	State               string     `json:"state"`
+	Queries             int64      `json:"queries"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	AvgLatencyMs        float64    `json:"avg_latency_ms"`
+	LastLatencyMs       float64    `json:"last_latency_ms"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// serverHealth tracks the health of the configured DNS servers and trips a
+// circuit breaker for servers that keep failing, so they are skipped until a
+// cooldown has passed. After the cooldown the server is tried again (half-open):
+// a success closes the breaker and a failure opens it straight away.
+type serverHealth struct {
+	mu        sync.Mutex
+	servers   map[string]*ResolverHealth
+	order     []string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+// newServerHealth creates health tracking for servers
+func newServerHealth(servers []string, threshold int, cooldown time.Duration) *serverHealth {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	h := &serverHealth{
+		servers:   make(map[string]*ResolverHealth, len(servers)),
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+	for _, server := range servers {
+		if _, exists := h.servers[server]; exists {
+			continue
+		}
+		h.servers[server] = &ResolverHealth{Server: server, State: BreakerClosed}
+		h.order = append(h.order, server)
+	}
+
+	return h
+}
+
+// available returns the servers to try, in configured order. Servers whose breaker
+// is open are left out; if every breaker is open they are all tried anyway, as an
+// answer from a struggling server beats no answer.
+func (h *serverHealth) available() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	servers := make([]string, 0, len(h.order))
+	for _, server := range h.order {
+		state := h.servers[server]
+		if state.State == BreakerOpen && state.OpenUntil != nil && !now.Before(*state.OpenUntil) {
+			state.State = BreakerHalfOpen
+			state.OpenUntil = nil
+		}
+		if state.State != BreakerOpen {
+			servers = append(servers, server)
+		}
+	}
+
+	if len(servers) == 0 {
+		return append([]string(nil), h.order...)
+	}
+	return servers
+}
+
+// success records a query the server answered
+func (h *serverHealth) success(server string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.servers[server]
+	if !ok {
+		return
+	}
+
+	h.recordLatency(state, latency)
+	state.ConsecutiveFailures = 0
+	state.State = BreakerClosed
+	state.OpenUntil = nil
+}
+
+// failure records a query the server failed, opening its breaker once the
+// threshold is reached or if a half-open trial fails
+func (h *serverHealth) failure(server string, latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.servers[server]
+	if !ok {
+		return
+	}
+
+	now := h.now()
+	h.recordLatency(state, latency)
+	state.Failures++
+	state.ConsecutiveFailures++
+	state.LastError = err.Error()
+	state.LastFailureAt = &now
+
+	if state.State == BreakerHalfOpen || state.ConsecutiveFailures >= h.threshold {
+		openUntil := now.Add(h.cooldown)
+		state.State = BreakerOpen
+		state.OpenUntil = &openUntil
+	}
+}
+
+// recordLatency counts a query and folds its latency into the average
+func (h *serverHealth) recordLatency(state *ResolverHealth, latency time.Duration) {
+	ms := float64(latency) / float64(time.Millisecond)
+	state.Queries++
+	state.LastLatencyMs = ms
+	if state.Queries == 1 {
+		state.AvgLatencyMs = ms
+	} else {
+		state.AvgLatencyMs = latencyWeight*ms + (1-latencyWeight)*state.AvgLatencyMs
+	}
+}
+
+// snapshot returns a copy of the health of every server, in configured order
+func (h *serverHealth) snapshot() []ResolverHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := make([]ResolverHealth, 0, len(h.order))
+	for _, server := range h.order {
+		snapshot = append(snapshot, *h.servers[server])
+	}
+	return snapshot
+}
@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/rs/zerolog/log"
+)
+
+// scanProgressInterval is how often a running job saves its progress
+const scanProgressInterval = time.Second
+
+// ErrScanJobFinished is returned when cancelling a scan job that has already finished
+var ErrScanJobFinished = errors.New("scan job has already finished")
+
+// ScanJobManager runs DNS scans in the background as jobs whose progress and
+// results are saved, so clients can poll for them and compare them later
+type ScanJobManager struct {
+	scanner *DNSScanner
+	repo    repository.ScanJobRepository
+
+	// ctx is the parent of every job and is cancelled when the manager stops
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[int64]*runningScan
+}
+
+// runningScan is a job being run by this process
+type runningScan struct {
+	cancel    context.CancelFunc
+	cancelled bool // cancelled on request rather than by shutdown
+	done      chan struct{}
+}
+
+// NewScanJobManager creates a new ScanJobManager
+func NewScanJobManager(scanner *DNSScanner, repo repository.ScanJobRepository) *ScanJobManager {
+	ctx, stop := context.WithCancel(context.Background())
+
+	return &ScanJobManager{
+		scanner: scanner,
+		repo:    repo,
+		ctx:     ctx,
+		stop:    stop,
+		running: make(map[int64]*runningScan),
+	}
+}
+
+// Run marks jobs left unfinished by a previous process as failed, then waits until
+// ctx is cancelled and stops every running job
+func (m *ScanJobManager) Run(ctx context.Context) {
+	failed, err := m.repo.FailUnfinished(ctx, "interrupted by server restart")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to clean up interrupted scan jobs")
+	} else if failed > 0 {
+		log.Warn().Int("jobs", failed).Msg("Marked interrupted scan jobs as failed")
+	}
+
+	<-ctx.Done()
+
+	m.stop()
+	m.wg.Wait()
+	log.Info().Msg("Scan jobs stopped")
+}
+
+// Start validates the options and starts a scan job, returning it in pending state.
+// createdByID is the ID of the user (or API key owner) starting it.
+func (m *ScanJobManager) Start(ctx context.Context, options ScanOptions, createdBy string, createdByID int64) (*models.ScanJob, error) {
+	if m.ctx.Err() != nil {
+		return nil, fmt.Errorf("scan jobs are shutting down")
+	}
+
+	template, options, err := m.scanner.prepareScan(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode scan options: %w", err)
+	}
+
+	job := &models.ScanJob{
+		TemplateID:  template.ID,
+		Status:      models.ScanJobPending,
+		Options:     optionsJSON,
+		Total:       options.EndSeq - options.StartSeq + 1,
+		CreatedBy:   createdBy,
+		CreatedByID: createdByID,
+		CreatedAt:   time.Now(),
+	}
+	if err := m.repo.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	// Run detached from the request, until cancelled or the manager stops
+	jobCtx, cancel := context.WithCancel(m.ctx)
+	run := &runningScan{cancel: cancel, done: make(chan struct{})}
+
+	m.mu.Lock()
+	m.running[job.ID] = run
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func(job models.ScanJob) {
+		defer func() {
+			cancel()
+			m.mu.Lock()
+			delete(m.running, job.ID)
+			m.mu.Unlock()
+			close(run.done)
+			m.wg.Done()
+		}()
+
+		m.run(jobCtx, &job, template, options, run)
+	}(*job)
+
+	log.Info().
+		Int64("jobID", job.ID).
+		Int64("templateID", template.ID).
+		Int("total", job.Total).
+		Str("createdBy", createdBy).
+		Msg("DNS scan job started")
+
+	return job, nil
+}
+
+// GetJob retrieves a scan job with its (partial) result
+func (m *ScanJobManager) GetJob(ctx context.Context, id int64) (*models.ScanJob, error) {
+	return m.repo.GetByID(ctx, id)
+}
+
+// ListJobs retrieves scan jobs without their results, newest first
+func (m *ScanJobManager) ListJobs(ctx context.Context, templateID int64, limit, offset int) ([]*models.ScanJob, int, error) {
+	return m.repo.List(ctx, templateID, limit, offset)
+}
+
+// Cancel stops a pending or running scan job, keeping the results it has so far,
+// and returns the job as it was left
+func (m *ScanJobManager) Cancel(ctx context.Context, id int64) (*models.ScanJob, error) {
+	m.mu.Lock()
+	run, ok := m.running[id]
+	if ok {
+		run.cancelled = true
+		run.cancel()
+	}
+	m.mu.Unlock()
+
+	if ok {
+		// The scan stops quickly as in-flight queries are abandoned
+		select {
+		case <-run.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return m.repo.GetByID(ctx, id)
+	}
+
+	job, err := m.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status.Finished() {
+		return nil, ErrScanJobFinished
+	}
+
+	// Not run by this process, so nothing is left to stop
+	now := time.Now()
+	job.Status = models.ScanJobCancelled
+	job.CompletedAt = &now
+	if err := m.repo.Update(ctx, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// run performs a job's scan, saving progress every scanProgressInterval
+func (m *ScanJobManager) run(ctx context.Context, job *models.ScanJob, template *models.Template, options ScanOptions, run *runningScan) {
+	// Saves use their own context, so a job's final state is recorded even once
+	// it has been cancelled
+	saveCtx := context.Background()
+
+	startTime := time.Now()
+	job.Status = models.ScanJobRunning
+	job.StartedAt = &startTime
+	if err := m.repo.Update(saveCtx, job); err != nil {
+		log.Error().Err(err).Int64("jobID", job.ID).Msg("Failed to start scan job")
+		return
+	}
+
+	result := newScanResult(template)
+	var mu sync.Mutex
+
+	// save records the job's progress. The job is copied under the lock so checks
+	// carry on while the copy is written.
+	save := func() {
+		mu.Lock()
+		result.ScanDuration = time.Since(startTime).String()
+		resultJSON, err := json.Marshal(result)
+		snapshot := *job
+		mu.Unlock()
+
+		if err != nil {
+			log.Error().Err(err).Int64("jobID", job.ID).Msg("Failed to encode scan result")
+			return
+		}
+		snapshot.Result = resultJSON
+		if err := m.repo.Update(saveCtx, &snapshot); err != nil {
+			log.Error().Err(err).Int64("jobID", job.ID).Msg("Failed to save scan job progress")
+		}
+	}
+
+	// Save progress periodically while the scan runs
+	stopProgress := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		ticker := time.NewTicker(scanProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopProgress:
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+
+	err := m.scanner.scan(ctx, options, func(item *ScanItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		job.Checked++
+		if item != nil {
+			result.add(*item)
+			job.Existing = result.ExistingHostnames
+		}
+	})
+
+	close(stopProgress)
+	<-progressDone
+
+	// Record the outcome with whatever was found
+	m.mu.Lock()
+	cancelled := run.cancelled
+	m.mu.Unlock()
+
+	completedAt := time.Now()
+	job.CompletedAt = &completedAt
+	switch {
+	case err == nil:
+		job.Status = models.ScanJobCompleted
+	case cancelled:
+		job.Status = models.ScanJobCancelled
+	default:
+		job.Status = models.ScanJobFailed
+		job.Error = "interrupted by server shutdown"
+	}
+	save()
+
+	log.Info().
+		Int64("jobID", job.ID).
+		Str("status", string(job.Status)).
+		Int("checked", job.Checked).
+		Int("existing", job.Existing).
+		Dur("duration", completedAt.Sub(startTime)).
+		Msg("DNS scan job finished")
+}
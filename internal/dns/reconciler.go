@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// reconcileBatchSize is how many hostnames are loaded at a time during a sweep
+	reconcileBatchSize = 200
+
+	// reconcileConcurrency bounds the DNS checks a sweep runs at once
+	reconcileConcurrency = 10
+)
+
+// DNSReconciler periodically checks every hostname in DNS, keeping dns_verified
+// up to date and flagging drift: committed names missing from DNS or resolving
+// elsewhere, and names not committed that resolve anyway
+type DNSReconciler struct {
+	dnsChecker     *DNSChecker
+	reservationSvc *service.ReservationService
+	interval       time.Duration
+}
+
+// NewDNSReconciler creates a new DNSReconciler
+func NewDNSReconciler(dnsChecker *DNSChecker, reservationSvc *service.ReservationService, interval time.Duration) *DNSReconciler {
+	return &DNSReconciler{
+		dnsChecker:     dnsChecker,
+		reservationSvc: reservationSvc,
+		interval:       interval,
+	}
+}
+
+// Run reconciles all hostnames every interval until ctx is cancelled
+func (r *DNSReconciler) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		log.Info().Msg("DNS reconciler disabled")
+		return
+	}
+
+	log.Info().Dur("interval", r.interval).Msg("DNS reconciler started")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("DNS reconciler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep checks every hostname once, a batch at a time
+func (r *DNSReconciler) sweep(ctx context.Context) {
+	startTime := time.Now()
+	var checked, failed int
+	drifted := make(map[models.DNSDrift]int)
+	var mu sync.Mutex
+
+	var afterID int64
+	for ctx.Err() == nil {
+		hostnames, err := r.reservationSvc.HostnamesForDNSCheck(ctx, afterID, reconcileBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Msg("Failed to load hostnames for DNS reconciliation")
+			}
+			return
+		}
+		if len(hostnames) == 0 {
+			break
+		}
+		afterID = hostnames[len(hostnames)-1].ID
+
+		// Check the batch with bounded concurrency
+		sem := make(chan struct{}, reconcileConcurrency)
+		var wg sync.WaitGroup
+		for _, hostname := range hostnames {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+
+			go func(hostname *models.Hostname) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				drift, err := r.check(ctx, hostname)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failed++
+					return
+				}
+				checked++
+				if drift != models.DNSDriftNone {
+					drifted[drift]++
+				}
+			}(hostname)
+		}
+		wg.Wait()
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	log.Info().
+		Int("checked", checked).
+		Int("failed", failed).
+		Int("missing", drifted[models.DNSDriftMissing]).
+		Int("unexpected", drifted[models.DNSDriftUnexpected]).
+		Int("addressMismatch", drifted[models.DNSDriftAddressMismatch]).
+		Dur("duration", time.Since(startTime)).
+		Msg("DNS reconciliation completed")
+}
+
+// check looks a hostname up and records the outcome. A lookup that fails leaves
+// the previous outcome in place, as nothing was learned.
+func (r *DNSReconciler) check(ctx context.Context, hostname *models.Hostname) (models.DNSDrift, error) {
+	// Drift is recorded against what the servers say now, not a cached answer
+	result, err := r.dnsChecker.CheckHostname(WithoutCache(ctx), hostname.Name)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn().Err(err).Str("hostname", hostname.Name).Msg("DNS reconciliation lookup failed")
+		}
+		return models.DNSDriftNone, err
+	}
+
+	drift, err := r.reservationSvc.RecordDNSCheck(ctx, hostname, result)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Int64("hostnameID", hostname.ID).Msg("Failed to record DNS check")
+		}
+		return drift, err
+	}
+
+	if drift != hostname.DNSDrift {
+		log.Info().
+			Int64("hostnameID", hostname.ID).
+			Str("hostname", hostname.Name).
+			Str("status", string(hostname.Status)).
+			Str("drift", string(drift)).
+			Str("previousDrift", string(hostname.DNSDrift)).
+			Msg("DNS drift changed")
+	}
+
+	return drift, nil
+}
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/miekg/dns"
+)
+
+// Resolver protocols
+const (
+	ProtocolUDP   = "udp"
+	ProtocolTCP   = "tcp"
+	ProtocolTLS   = "tls"   // DNS over TLS (RFC 7858)
+	ProtocolHTTPS = "https" // DNS over HTTPS (RFC 8484)
+)
+
+const (
+	// dohMediaType is the content type of DNS messages sent over HTTPS
+	dohMediaType = "application/dns-message"
+
+	// maxDoHResponse bounds the size of a DNS over HTTPS response body
+	maxDoHResponse = dns.MaxMsgSize
+)
+
+// resolver is a DNS server the checker queries, together with the transport
+// that reaches it
+type resolver struct {
+	name    string // identifies the server in health reports and logs
+	address string // host:port, or the URL for https
+	client  *dns.Client
+	tcp     *dns.Client // retries truncated UDP answers
+	http    *http.Client
+}
+
+// newResolvers creates the resolvers for the plain servers and the resolvers
+// configured in dnsConfig, in that order
+func newResolvers(dnsConfig config.DNSConfig) ([]*resolver, error) {
+	resolvers := make([]*resolver, 0, len(dnsConfig.Servers)+len(dnsConfig.Resolvers))
+
+	for _, server := range dnsConfig.Servers {
+		resolvers = append(resolvers, &resolver{
+			name:    server,
+			address: serverAddress(server),
+			client:  &dns.Client{Net: "udp", Timeout: dnsConfig.Timeout},
+			tcp:     &dns.Client{Net: "tcp", Timeout: dnsConfig.Timeout},
+		})
+	}
+
+	for _, resolverConfig := range dnsConfig.Resolvers {
+		r, err := newResolver(resolverConfig, dnsConfig.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("resolver %s: %w", resolverConfig.Address, err)
+		}
+		resolvers = append(resolvers, r)
+	}
+
+	return resolvers, nil
+}
+
+// newResolver creates a resolver reached over the configured protocol
+func newResolver(resolverConfig config.DNSResolverConfig, timeout time.Duration) (*resolver, error) {
+	if resolverConfig.Address == "" {
+		return nil, fmt.Errorf("an address is required")
+	}
+
+	protocol := strings.ToLower(resolverConfig.Protocol)
+	if protocol == "" {
+		protocol = ProtocolUDP
+	}
+
+	switch protocol {
+	case ProtocolUDP, ProtocolTCP:
+		address := resolverAddress(resolverConfig.Address, resolverConfig.Port, 53)
+		return &resolver{
+			name:    protocol + "://" + address,
+			address: address,
+			client:  &dns.Client{Net: protocol, Timeout: timeout},
+			tcp:     &dns.Client{Net: "tcp", Timeout: timeout},
+		}, nil
+
+	case ProtocolTLS:
+		tlsConfig, err := resolverTLSConfig(resolverConfig)
+		if err != nil {
+			return nil, err
+		}
+		address := resolverAddress(resolverConfig.Address, resolverConfig.Port, 853)
+		return &resolver{
+			name:    protocol + "://" + address,
+			address: address,
+			client:  &dns.Client{Net: "tcp-tls", Timeout: timeout, TLSConfig: tlsConfig},
+		}, nil
+
+	case ProtocolHTTPS:
+		tlsConfig, err := resolverTLSConfig(resolverConfig)
+		if err != nil {
+			return nil, err
+		}
+		url := resolverConfig.Address
+		if !strings.HasPrefix(strings.ToLower(url), "https://") {
+			url = "https://" + resolverAddress(url, resolverConfig.Port, 443) + "/dns-query"
+		}
+		return &resolver{
+			name:    url,
+			address: url,
+			http: &http.Client{
+				Timeout:   timeout,
+				Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+			},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q, expected udp, tcp, tls or https", resolverConfig.Protocol)
+	}
+}
+
+// resolverAddress joins a host with the configured port, or the default port if
+// none is configured. A port already in the address is kept.
+func resolverAddress(address string, port, defaultPort int) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(port))
+}
+
+// resolverTLSConfig returns the TLS settings of a tls or https resolver
+func resolverTLSConfig(resolverConfig config.DNSResolverConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: resolverConfig.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if resolverConfig.CABundle != "" {
+		pem, err := os.ReadFile(resolverConfig.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", resolverConfig.CABundle)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	return tlsConfig, nil
+}
+
+// exchange sends a query to the resolver and returns its answer
+func (r *resolver) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if r.http != nil {
+		return r.exchangeHTTPS(ctx, m)
+	}
+
+	answer, _, err := r.client.ExchangeContext(ctx, m, r.address)
+	if err != nil {
+		return nil, err
+	}
+
+	// A truncated UDP answer is asked for again over TCP
+	if answer.Truncated && r.tcp != nil && r.client.Net == "udp" {
+		answer, _, err = r.tcp.ExchangeContext(ctx, m, r.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return answer, nil
+}
+
+// exchangeHTTPS sends a query as an RFC 8484 POST request
+func (r *resolver) exchangeHTTPS(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// The ID is zero over HTTPS so identical queries can be cached
+	query := m.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack DNS query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.address, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS over HTTPS request: %w", err)
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS request returned status %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, dohMediaType) {
+		return nil, fmt.Errorf("DNS over HTTPS response has content type %q", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS over HTTPS response: %w", err)
+	}
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack DNS over HTTPS response: %w", err)
+	}
+	answer.Id = m.Id
+
+	return answer, nil
+}
@@ -0,0 +1,340 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/miekg/dns"
+)
+
+// testAddress is the address local stand-in servers answer with
+const testAddress = "192.0.2.1"
+
+// answerQuery builds the reply a stand-in server sends: testAddress for A queries
+// for www.example.com., NXDOMAIN for anything else
+func answerQuery(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	if q.Name != "www.example.com." || q.Qtype != dns.TypeA {
+		m.SetRcode(r, dns.RcodeNameError)
+		return m
+	}
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(testAddress),
+	})
+	return m
+}
+
+// writeCABundle writes cert to a PEM file for a resolver's caBundle setting
+func writeCABundle(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	return path
+}
+
+// newSelfSignedCertificate returns a certificate for 127.0.0.1 and dns.example.com
+func newSelfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.com"},
+		DNSNames:              []string{"dns.example.com"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// startDoTServer starts a DNS over TLS server presenting cert and returns its address
+func startDoTServer(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener:          listener,
+		Net:               "tcp-tls",
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			w.WriteMsg(answerQuery(r))
+		}),
+	}
+
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return listener.Addr().String()
+}
+
+// query asks r for the A record of name
+func query(t *testing.T, r *resolver, name string) (*dns.Msg, *dns.Msg, error) {
+	t.Helper()
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	answer, err := r.exchange(ctx, m)
+	return m, answer, err
+}
+
+// assertAddress checks that answer holds the single A record testAddress
+func assertAddress(t *testing.T, answer *dns.Msg) {
+	t.Helper()
+
+	if answer.Rcode != dns.RcodeSuccess || len(answer.Answer) != 1 {
+		t.Fatalf("expected one answer, got %s with %d records", dns.RcodeToString[answer.Rcode], len(answer.Answer))
+	}
+	a, ok := answer.Answer[0].(*dns.A)
+	if !ok || a.A.String() != testAddress {
+		t.Fatalf("expected A %s, got %s", testAddress, answer.Answer[0])
+	}
+}
+
+func TestResolverDNSOverTLS(t *testing.T) {
+	cert := newSelfSignedCertificate(t)
+	address := startDoTServer(t, cert)
+	caBundle := writeCABundle(t, cert.Leaf)
+
+	r, err := newResolver(config.DNSResolverConfig{Address: address, Protocol: "TLS", CABundle: caBundle}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("newResolver failed: %v", err)
+	}
+	if r.name != "tls://"+address {
+		t.Errorf("expected name tls://%s, got %s", address, r.name)
+	}
+
+	_, answer, err := query(t, r, "www.example.com.")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	assertAddress(t, answer)
+
+	_, answer, err = query(t, r, "missing.example.com.")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if answer.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", dns.RcodeToString[answer.Rcode])
+	}
+}
+
+func TestResolverDNSOverTLSCertificateErrors(t *testing.T) {
+	cert := newSelfSignedCertificate(t)
+	address := startDoTServer(t, cert)
+	caBundle := writeCABundle(t, cert.Leaf)
+
+	tests := []struct {
+		name           string
+		resolverConfig config.DNSResolverConfig
+	}{
+		{"untrusted CA", config.DNSResolverConfig{Address: address, Protocol: ProtocolTLS}},
+		{"wrong server name", config.DNSResolverConfig{Address: address, Protocol: ProtocolTLS, CABundle: caBundle, ServerName: "other.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newResolver(tt.resolverConfig, 2*time.Second)
+			if err != nil {
+				t.Fatalf("newResolver failed: %v", err)
+			}
+			if _, _, err := query(t, r, "www.example.com."); err == nil {
+				t.Fatal("expected the certificate to be rejected")
+			}
+		})
+	}
+}
+
+func TestResolverDNSOverHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/dns-query" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if req.Header.Get("Content-Type") != dohMediaType || req.Header.Get("Accept") != dohMediaType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		r := new(dns.Msg)
+		if err := r.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Id != 0 {
+			http.Error(w, "query ID must be zero", http.StatusBadRequest)
+			return
+		}
+
+		packed, _ := answerQuery(r).Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(packed)
+	}))
+	defer server.Close()
+	caBundle := writeCABundle(t, server.Certificate())
+
+	// A bare host:port gets the standard /dns-query path
+	address := strings.TrimPrefix(server.URL, "https://")
+	r, err := newResolver(config.DNSResolverConfig{Address: address, Protocol: ProtocolHTTPS, CABundle: caBundle}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("newResolver failed: %v", err)
+	}
+	if r.address != server.URL+"/dns-query" {
+		t.Errorf("expected URL %s/dns-query, got %s", server.URL, r.address)
+	}
+
+	m, answer, err := query(t, r, "www.example.com.")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	assertAddress(t, answer)
+	if answer.Id != m.Id {
+		t.Errorf("expected the answer to carry the query ID %d, got %d", m.Id, answer.Id)
+	}
+
+	_, answer, err = query(t, r, "missing.example.com.")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if answer.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", dns.RcodeToString[answer.Rcode])
+	}
+}
+
+func TestResolverDNSOverHTTPSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			want: "status 503",
+		},
+		{
+			name: "wrong content type",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<html></html>"))
+			},
+			want: "content type",
+		},
+		{
+			name: "malformed message",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", dohMediaType)
+				w.Write([]byte{0x00, 0x01})
+			},
+			want: "unpack",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(tt.handler)
+			defer server.Close()
+
+			r, err := newResolver(config.DNSResolverConfig{
+				Address:  server.URL + "/dns-query",
+				Protocol: ProtocolHTTPS,
+				CABundle: writeCABundle(t, server.Certificate()),
+			}, 2*time.Second)
+			if err != nil {
+				t.Fatalf("newResolver failed: %v", err)
+			}
+
+			_, _, err = query(t, r, "www.example.com.")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("untrusted CA", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		defer server.Close()
+
+		r, err := newResolver(config.DNSResolverConfig{Address: server.URL, Protocol: ProtocolHTTPS}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("newResolver failed: %v", err)
+		}
+		if _, _, err := query(t, r, "www.example.com."); err == nil {
+			t.Fatal("expected the certificate to be rejected")
+		}
+	})
+}
+
+func TestNewResolverConfigErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		resolverConfig config.DNSResolverConfig
+		want           string
+	}{
+		{"missing address", config.DNSResolverConfig{Protocol: ProtocolTLS}, "address is required"},
+		{"unsupported protocol", config.DNSResolverConfig{Address: "127.0.0.1", Protocol: "quic"}, "unsupported protocol"},
+		{"missing CA bundle", config.DNSResolverConfig{Address: "127.0.0.1", Protocol: ProtocolTLS, CABundle: "/nonexistent/ca.pem"}, "failed to read CA bundle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newResolver(tt.resolverConfig, time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
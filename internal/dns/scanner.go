@@ -3,7 +3,6 @@ package dns
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -51,18 +50,46 @@ type ScanOptions struct {
 	EndSeq        int               `json:"end_seq"`
 	Params        map[string]string `json:"params"`
 	MaxConcurrent int               `json:"max_concurrent"`
+	BypassCache   bool              `json:"bypass_cache"` // query the DNS servers even for cached answers
 }
 
 // ScanTemplate scans DNS for hostnames based on a template
 func (s *DNSScanner) ScanTemplate(ctx context.Context, options ScanOptions) (*ScanResult, error) {
 	startTime := time.Now()
 
+	template, options, err := s.prepareScan(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize result
+	result := newScanResult(template)
+	var resultsMutex sync.Mutex
+
+	err = s.scan(ctx, options, func(item *ScanItem) {
+		if item == nil {
+			return
+		}
+		resultsMutex.Lock()
+		result.add(*item)
+		resultsMutex.Unlock()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan of template %s stopped: %w", template.Name, err)
+	}
+	result.ScanDuration = time.Since(startTime).String()
+
+	return result, nil
+}
+
+// prepareScan validates scan options, filling in defaults, and returns the template to scan
+func (s *DNSScanner) prepareScan(ctx context.Context, options ScanOptions) (*models.Template, ScanOptions, error) {
 	// Validate options
 	if options.TemplateID <= 0 {
-		return nil, fmt.Errorf("invalid template ID")
+		return nil, options, fmt.Errorf("invalid template ID")
 	}
 	if options.EndSeq < options.StartSeq {
-		return nil, fmt.Errorf("end sequence must be greater than or equal to start sequence")
+		return nil, options, fmt.Errorf("end sequence must be greater than or equal to start sequence")
 	}
 	if options.MaxConcurrent <= 0 {
 		options.MaxConcurrent = 10 // Default to 10 concurrent checks
@@ -71,25 +98,54 @@ func (s *DNSScanner) ScanTemplate(ctx context.Context, options ScanOptions) (*Sc
 	// Get template
 	template, err := s.generatorSvc.GetTemplateByID(ctx, options.TemplateID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get template: %w", err)
+		return nil, options, fmt.Errorf("failed to get template: %w", err)
 	}
 
-	// Initialize result
-	result := &ScanResult{
-		TemplateID:   options.TemplateID,
+	return template, options, nil
+}
+
+// newScanResult creates an empty result for a scan of template
+func newScanResult(template *models.Template) *ScanResult {
+	return &ScanResult{
+		TemplateID:   template.ID,
 		TemplateName: template.Name,
 		Results:      []ScanItem{},
 	}
+}
+
+// add records a checked hostname in the result
+func (r *ScanResult) add(item ScanItem) {
+	r.Results = append(r.Results, item)
+	r.TotalHostnames = len(r.Results)
+	if item.Exists {
+		r.ExistingHostnames++
+	}
+}
+
+// scan generates and checks the hostname for each sequence number in the options,
+// calling record once per sequence number with the outcome, or nil if the hostname
+// couldn't be generated or checked. record may be called concurrently. Dispatching
+// stops once ctx is done, in which case its error is returned.
+func (s *DNSScanner) scan(ctx context.Context, options ScanOptions, record func(item *ScanItem)) error {
+	if options.BypassCache {
+		ctx = WithoutCache(ctx)
+	}
 
 	// Create a semaphore to limit concurrency
 	sem := make(chan struct{}, options.MaxConcurrent)
 	var wg sync.WaitGroup
-	var resultsMutex sync.Mutex
 
-	// Generate and check hostnames for each sequence number
-	for seq := options.StartSeq; seq <= options.EndSeq; seq++ {
+	// Generate and check hostnames for each sequence number, stopping if the
+	// caller goes away
+	cancelled := false
+	for seq := options.StartSeq; seq <= options.EndSeq && !cancelled; seq++ {
+		select {
+		case sem <- struct{}{}: // Acquire semaphore
+		case <-ctx.Done():
+			cancelled = true
+			continue
+		}
 		wg.Add(1)
-		sem <- struct{}{} // Acquire semaphore
 
 		go func(sequenceNum int) {
 			defer func() {
@@ -101,36 +157,32 @@ func (s *DNSScanner) ScanTemplate(ctx context.Context, options ScanOptions) (*Sc
 			hostname, err := s.generatorSvc.GenerateHostname(ctx, options.TemplateID, sequenceNum, options.Params)
 			if err != nil {
 				log.Error().Err(err).Int("sequence", sequenceNum).Msg("Failed to generate hostname")
+				record(nil)
 				return
 			}
 
 			// Check if hostname exists in DNS
 			dnsResult, err := s.dnsChecker.CheckHostname(ctx, hostname)
 			if err != nil {
-				log.Error().Err(err).Str("hostname", hostname).Msg("Failed to check hostname in DNS")
+				if ctx.Err() == nil {
+					log.Error().Err(err).Str("hostname", hostname).Msg("Failed to check hostname in DNS")
+					record(nil)
+				}
 				return
 			}
 
-			// Add to results
-			resultsMutex.Lock()
-			result.Results = append(result.Results, ScanItem{
+			record(&ScanItem{
 				Hostname:  hostname,
 				Exists:    dnsResult.Exists,
 				IPAddress: dnsResult.IPAddress,
 			})
-			if dnsResult.Exists {
-				result.ExistingHostnames++
-			}
-			resultsMutex.Unlock()
 		}(seq)
 	}
 
 	// Wait for all checks to complete
 	wg.Wait()
-	result.TotalHostnames = len(result.Results)
-	result.ScanDuration = time.Since(startTime).String()
 
-	return result, nil
+	return ctx.Err()
 }
 
 // DiscoverSequenceRange attempts to discover the range of sequence numbers in use
@@ -154,6 +206,10 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 	// First do a quick scan to find if there are any hostnames at all
 	foundAny := false
 	for i := low; i <= low+10; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
 		hostname, err := s.generatorSvc.GenerateHostname(ctx, templateID, i, params)
 		if err != nil {
 			continue
@@ -175,6 +231,10 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 	if !foundAny {
 		// Try a wider range
 		for i := low; i <= high; i += 100 {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, err
+			}
+
 			hostname, err := s.generatorSvc.GenerateHostname(ctx, templateID, i, params)
 			if err != nil {
 				continue
@@ -200,6 +260,10 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 
 	// Now find lower bound, starting from the found point and going down
 	for i := lowestFound - 1; i >= startSeq; i-- {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
 		hostname, err := s.generatorSvc.GenerateHostname(ctx, templateID, i, params)
 		if err != nil {
 			break
@@ -215,6 +279,10 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 
 	// Find upper bound, starting from found point and going up
 	for i := highestFound + 1; i <= highestFound+1000; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
 		hostname, err := s.generatorSvc.GenerateHostname(ctx, templateID, i, params)
 		if err != nil {
 			break
@@ -225,6 +293,10 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 			// If we get 10 consecutive non-existent hostnames, assume we've reached the end
 			consecutive := 1
 			for j := i + 1; j <= i+10; j++ {
+				if err := ctx.Err(); err != nil {
+					return 0, 0, err
+				}
+
 				hostname, err := s.generatorSvc.GenerateHostname(ctx, templateID, j, params)
 				if err != nil {
 					continue
@@ -249,32 +321,14 @@ func (s *DNSScanner) DiscoverSequenceRange(ctx context.Context, templateID int64
 	return lowestFound, highestFound, nil
 }
 
-// ParseHostnameSequence attempts to extract the sequence number from a hostname
+// ParseHostnameSequence extracts the sequence number from a hostname using the template layout
 func (s *DNSScanner) ParseHostnameSequence(hostname string, template *models.Template) (int, error) {
-	// This is a simplified implementation that assumes the sequence is numeric
-	// and is at the end of the hostname
-
-	// Extract the trailing digits
-	digits := ""
-	for i := len(hostname) - 1; i >= 0; i-- {
-		if hostname[i] >= '0' && hostname[i] <= '9' {
-			digits = string(hostname[i]) + digits
-		} else {
-			break
-		}
-	}
-
-	if digits == "" {
-		return 0, fmt.Errorf("no sequence number found in hostname")
-	}
-
-	// Convert to integer
-	seq, err := strconv.Atoi(digits)
+	parsed, err := s.generatorSvc.ParseHostname(template, hostname)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse sequence number: %w", err)
+		return 0, fmt.Errorf("failed to parse hostname: %w", err)
 	}
 
-	return seq, nil
+	return parsed.SequenceNum, nil
 }
 
 // AnalyzeTemplateUsage analyzes DNS to determine hostname usage patterns
@@ -313,9 +367,15 @@ func (s *DNSScanner) AnalyzeTemplateUsage(ctx context.Context, templateID int64,
 	// Create a semaphore to limit concurrency
 	sem := make(chan struct{}, 10)
 
-	for seq := low; seq <= high; seq++ {
+	cancelled := false
+	for seq := low; seq <= high && !cancelled; seq++ {
+		select {
+		case sem <- struct{}{}: // Acquire semaphore
+		case <-ctx.Done():
+			cancelled = true
+			continue
+		}
 		wg.Add(1)
-		sem <- struct{}{} // Acquire semaphore
 
 		go func(sequenceNum int) {
 			defer func() {
@@ -350,6 +410,9 @@ func (s *DNSScanner) AnalyzeTemplateUsage(ctx context.Context, templateID int64,
 
 	// Wait for all checks to complete
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	return usage, nil
 }
@@ -0,0 +1,481 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Defaults for zone settings left out of the configuration
+	defaultServerTTL = 300
+	defaultRefresh   = 3600
+	defaultRetry     = 600
+	defaultExpire    = 604800
+	defaultMinimum   = 60
+
+	// serverQueryTimeout bounds the database work behind a single answer
+	serverQueryTimeout = 5 * time.Second
+
+	// transferBatchSize is how many hostnames are loaded, and sent in one
+	// message, at a time during a zone transfer
+	transferBatchSize = 100
+)
+
+// AuthoritativeServer answers DNS queries for a zone from the hostnames table.
+// Committed hostnames with an address resolve to it, so names appear the moment
+// they are committed and vanish once released. The zone can be transferred with
+// AXFR by the secondaries allowed to.
+type AuthoritativeServer struct {
+	listen        string
+	zone          string
+	nameservers   []string
+	hostmaster    string
+	ttl           uint32
+	refresh       uint32
+	retry         uint32
+	expire        uint32
+	minimum       uint32
+	allowTransfer []*net.IPNet
+	keyName       string
+	tsigSecret    map[string]string
+	hostnameRepo  repository.HostnameRepository
+}
+
+// NewAuthoritativeServer creates an AuthoritativeServer from serverConfig
+func NewAuthoritativeServer(serverConfig config.DNSServerConfig, hostnameRepo repository.HostnameRepository) (*AuthoritativeServer, error) {
+	if serverConfig.Zone == "" {
+		return nil, fmt.Errorf("a zone is required to serve DNS")
+	}
+	zone := strings.ToLower(dns.Fqdn(serverConfig.Zone))
+	if _, ok := dns.IsDomainName(zone); !ok {
+		return nil, fmt.Errorf("invalid zone %q", serverConfig.Zone)
+	}
+
+	s := &AuthoritativeServer{
+		listen:       serverConfig.Listen,
+		zone:         zone,
+		hostmaster:   dns.Fqdn(serverConfig.Hostmaster),
+		ttl:          orDefault(serverConfig.TTL, defaultServerTTL),
+		refresh:      orDefault(serverConfig.Refresh, defaultRefresh),
+		retry:        orDefault(serverConfig.Retry, defaultRetry),
+		expire:       orDefault(serverConfig.Expire, defaultExpire),
+		minimum:      orDefault(serverConfig.Minimum, defaultMinimum),
+		hostnameRepo: hostnameRepo,
+	}
+	if serverConfig.Hostmaster == "" {
+		s.hostmaster = "hostmaster." + zone
+	}
+
+	for _, nameserver := range serverConfig.Nameservers {
+		s.nameservers = append(s.nameservers, strings.ToLower(dns.Fqdn(nameserver)))
+	}
+	if len(s.nameservers) == 0 {
+		s.nameservers = []string{"ns." + zone}
+	}
+
+	// Secondaries may be given as single addresses or as ranges
+	for _, allowed := range serverConfig.AllowTransfer {
+		if !strings.Contains(allowed, "/") {
+			ip := net.ParseIP(allowed)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid transfer address %q", allowed)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			s.allowTransfer = append(s.allowTransfer, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(allowed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transfer range %q: %w", allowed, err)
+		}
+		s.allowTransfer = append(s.allowTransfer, network)
+	}
+
+	if serverConfig.KeyName != "" {
+		s.keyName = dns.Fqdn(serverConfig.KeyName)
+		s.tsigSecret = map[string]string{s.keyName: serverConfig.KeySecret}
+	}
+
+	return s, nil
+}
+
+// orDefault returns value, or fallback if value is zero
+func orDefault(value, fallback uint32) uint32 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// Run serves DNS over UDP and TCP until ctx is cancelled
+func (s *AuthoritativeServer) Run(ctx context.Context) {
+	servers := []*dns.Server{
+		{Addr: s.listen, Net: "udp", Handler: s, TsigSecret: s.tsigSecret},
+		{Addr: s.listen, Net: "tcp", Handler: s, TsigSecret: s.tsigSecret},
+	}
+
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *dns.Server) {
+			if err := server.ListenAndServe(); err != nil {
+				failed <- fmt.Errorf("%s: %w", server.Net, err)
+			}
+		}(server)
+	}
+
+	log.Info().Str("listen", s.listen).Str("zone", s.zone).Msg("Authoritative DNS server started")
+
+	select {
+	case <-ctx.Done():
+	case err := <-failed:
+		log.Error().Err(err).Str("listen", s.listen).Msg("Authoritative DNS server failed")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverQueryTimeout)
+	defer cancel()
+	for _, server := range servers {
+		// A server that failed to start has nothing to shut down
+		_ = server.ShutdownContext(shutdownCtx)
+	}
+
+	log.Info().Msg("Authoritative DNS server stopped")
+}
+
+// ServeDNS answers a query
+func (s *AuthoritativeServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if len(r.Question) != 1 {
+		s.reply(w, r, new(dns.Msg).SetRcode(r, dns.RcodeFormatError))
+		return
+	}
+
+	question := r.Question[0]
+	if question.Qtype == dns.TypeAXFR || question.Qtype == dns.TypeIXFR {
+		s.transfer(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverQueryTimeout)
+	defer cancel()
+
+	m, err := s.answer(ctx, r)
+	if err != nil {
+		log.Error().Err(err).Str("name", question.Name).Str("type", dns.TypeToString[question.Qtype]).Msg("Failed to answer DNS query")
+		m = new(dns.Msg).SetRcode(r, dns.RcodeServerFailure)
+	}
+	s.reply(w, r, m)
+}
+
+// reply sends m, signing it if the query was signed with a valid key
+func (s *AuthoritativeServer) reply(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(m); err != nil {
+		log.Debug().Err(err).Msg("Failed to write DNS response")
+	}
+}
+
+// answer builds the response to a standard query
+func (s *AuthoritativeServer) answer(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	question := r.Question[0]
+	name := strings.ToLower(question.Name)
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if question.Qclass != dns.ClassINET && question.Qclass != dns.ClassANY {
+		m.Rcode = dns.RcodeRefused
+		return m, nil
+	}
+	if !dns.IsSubDomain(s.zone, name) {
+		m.Rcode = dns.RcodeRefused
+		return m, nil
+	}
+	m.Authoritative = true
+
+	// The apex holds the SOA and NS records
+	if name == s.zone {
+		soa, err := s.soa(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch question.Qtype {
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, soa)
+			m.Ns = s.nsRecords()
+		case dns.TypeNS:
+			m.Answer = s.nsRecords()
+			m.Extra = s.glue(ctx)
+		case dns.TypeANY:
+			m.Answer = append([]dns.RR{soa}, s.nsRecords()...)
+		default:
+			m.Ns = []dns.RR{s.negativeSOA(soa)}
+		}
+		return m, nil
+	}
+
+	// Every other name is a committed hostname or doesn't exist
+	rr, err := s.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if rr != nil && (question.Qtype == rr.Header().Rrtype || question.Qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, rr)
+		return m, nil
+	}
+
+	// Negative answers carry the SOA record
+	soa, err := s.soa(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		m.Rcode = dns.RcodeNameError
+	}
+	m.Ns = []dns.RR{s.negativeSOA(soa)}
+	return m, nil
+}
+
+// lookup returns the address record of the committed hostname a name in the
+// zone belongs to, or nil if there is none
+func (s *AuthoritativeServer) lookup(ctx context.Context, name string) (dns.RR, error) {
+	relative := strings.TrimSuffix(name, "."+s.zone)
+	if relative == name {
+		return nil, nil
+	}
+
+	hostname, err := s.hostnameRepo.GetCommittedByName(ctx, relative)
+	if err != nil {
+		return nil, err
+	}
+	if hostname == nil {
+		return nil, nil
+	}
+
+	return s.addressRecord(hostname), nil
+}
+
+// addressRecord returns the A or AAAA record of a hostname, or nil if it has no
+// valid address
+func (s *AuthoritativeServer) addressRecord(hostname *models.Hostname) dns.RR {
+	ip := net.ParseIP(hostname.IPAddress)
+	if ip == nil {
+		return nil
+	}
+
+	name := strings.ToLower(hostname.Name) + "." + s.zone
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil
+	}
+
+	header := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: s.ttl}
+	if ip4 := ip.To4(); ip4 != nil {
+		header.Rrtype = dns.TypeA
+		return &dns.A{Hdr: header, A: ip4}
+	}
+	header.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: header, AAAA: ip}
+}
+
+// soa returns the zone's SOA record. The serial increases with every change to
+// a committed hostname's name or address, so secondaries notice commits and
+// releases; it wraps around as serial arithmetic allows (RFC 1982).
+func (s *AuthoritativeServer) soa(ctx context.Context) (*dns.SOA, error) {
+	serial, err := s.hostnameRepo.ZoneSerial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: s.ttl},
+		Ns:      s.nameservers[0],
+		Mbox:    s.hostmaster,
+		Serial:  uint32(serial),
+		Refresh: s.refresh,
+		Retry:   s.retry,
+		Expire:  s.expire,
+		Minttl:  s.minimum,
+	}, nil
+}
+
+// negativeSOA returns the SOA record sent with negative answers, whose TTL tells
+// resolvers how long to cache them (RFC 2308)
+func (s *AuthoritativeServer) negativeSOA(soa *dns.SOA) *dns.SOA {
+	negative := *soa
+	if negative.Minttl < negative.Hdr.Ttl {
+		negative.Hdr.Ttl = negative.Minttl
+	}
+	return &negative
+}
+
+// nsRecords returns the zone's NS records
+func (s *AuthoritativeServer) nsRecords() []dns.RR {
+	records := make([]dns.RR, 0, len(s.nameservers))
+	for _, nameserver := range s.nameservers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: s.zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: s.ttl},
+			Ns:  nameserver,
+		})
+	}
+	return records
+}
+
+// glue returns the addresses of the nameservers inside the zone that are
+// committed hostnames. Failed lookups only cost the glue, not the answer.
+func (s *AuthoritativeServer) glue(ctx context.Context) []dns.RR {
+	var records []dns.RR
+	for _, nameserver := range s.nameservers {
+		rr, err := s.lookup(ctx, nameserver)
+		if err != nil {
+			log.Warn().Err(err).Str("nameserver", nameserver).Msg("Failed to look up nameserver address")
+			continue
+		}
+		if rr != nil {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// transfer sends the whole zone to an allowed secondary. IXFR requests are
+// answered with the whole zone too, which RFC 1995 permits.
+func (s *AuthoritativeServer) transfer(w dns.ResponseWriter, r *dns.Msg) {
+	question := r.Question[0]
+	remote := w.RemoteAddr().String()
+
+	if !strings.EqualFold(question.Name, s.zone) {
+		s.reply(w, r, new(dns.Msg).SetRcode(r, dns.RcodeNotAuth))
+		return
+	}
+	if reason := s.refuseTransfer(w, r); reason != "" {
+		log.Warn().Str("remote", remote).Str("reason", reason).Msg("Zone transfer refused")
+		s.reply(w, r, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverQueryTimeout)
+	soa, err := s.soa(ctx)
+	cancel()
+	if err != nil {
+		log.Error().Err(err).Str("remote", remote).Msg("Failed to start zone transfer")
+		s.reply(w, r, new(dns.Msg).SetRcode(r, dns.RcodeServerFailure))
+		return
+	}
+
+	// Transfer.Out writes each envelope as its own message
+	ch := make(chan *dns.Envelope)
+	var wg sync.WaitGroup
+	var writeErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tr := &dns.Transfer{TsigSecret: s.tsigSecret}
+		writeErr = tr.Out(w, r, ch)
+		// Keep draining if the secondary went away, so sending never blocks
+		for range ch {
+		}
+	}()
+
+	records, err := s.sendZone(ch, soa)
+	close(ch)
+	wg.Wait()
+	_ = w.Close()
+
+	switch {
+	case err != nil:
+		log.Error().Err(err).Str("remote", remote).Msg("Zone transfer aborted")
+	case writeErr != nil:
+		log.Warn().Err(writeErr).Str("remote", remote).Msg("Zone transfer interrupted")
+	default:
+		log.Info().
+			Str("remote", remote).
+			Str("zone", s.zone).
+			Uint32("serial", soa.Serial).
+			Int("records", records).
+			Msg("Zone transferred")
+	}
+}
+
+// refuseTransfer returns why a transfer request may not be served, or "" if it may
+func (s *AuthoritativeServer) refuseTransfer(w dns.ResponseWriter, r *dns.Msg) string {
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+		return "not over TCP"
+	}
+
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return "unknown address"
+	}
+	ip := net.ParseIP(host)
+	allowed := false
+	for _, network := range s.allowTransfer {
+		if network.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "address not allowed"
+	}
+
+	if s.keyName != "" {
+		tsig := r.IsTsig()
+		if tsig == nil {
+			return "not signed"
+		}
+		if !strings.EqualFold(tsig.Hdr.Name, s.keyName) || w.TsigStatus() != nil {
+			return "bad signature"
+		}
+	}
+
+	return ""
+}
+
+// sendZone sends the zone's records, starting and ending with its SOA record,
+// and returns how many were sent
+func (s *AuthoritativeServer) sendZone(ch chan<- *dns.Envelope, soa *dns.SOA) (int, error) {
+	ch <- &dns.Envelope{RR: append([]dns.RR{soa}, s.nsRecords()...)}
+	records := 1 + len(s.nameservers)
+
+	var afterID int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), serverQueryTimeout)
+		hostnames, err := s.hostnameRepo.ListCommitted(ctx, afterID, transferBatchSize)
+		cancel()
+		if err != nil {
+			return records, err
+		}
+		if len(hostnames) == 0 {
+			break
+		}
+		afterID = hostnames[len(hostnames)-1].ID
+
+		batch := make([]dns.RR, 0, len(hostnames))
+		for _, hostname := range hostnames {
+			if rr := s.addressRecord(hostname); rr != nil {
+				batch = append(batch, rr)
+			}
+		}
+		if len(batch) > 0 {
+			ch <- &dns.Envelope{RR: batch}
+			records += len(batch)
+		}
+	}
+
+	ch <- &dns.Envelope{RR: []dns.RR{soa}}
+	return records + 1, nil
+}
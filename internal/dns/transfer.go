@@ -0,0 +1,381 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/service"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// Zone transfer types
+const (
+	TransferAXFR = "axfr"
+	TransferIXFR = "ixfr"
+)
+
+// maxUnmatchedReported bounds how many unmatched names a discovery result lists
+const maxUnmatchedReported = 100
+
+// ErrZoneNotConfigured is returned when discovery is requested for a zone without transfer settings
+var ErrZoneNotConfigured = errors.New("zone is not configured for transfers")
+
+// ZoneDiscoverer finds hostnames by transferring whole zones from their primary
+// and matching every host name against the template layouts
+type ZoneDiscoverer struct {
+	zones        map[string]config.DNSTransferConfig
+	timeout      time.Duration
+	generatorSvc *service.GeneratorService
+	importSvc    *service.ImportService
+	authz        *service.AuthorizationService
+}
+
+// NewZoneDiscoverer creates a new ZoneDiscoverer for the zones configured in dnsConfig
+func NewZoneDiscoverer(dnsConfig config.DNSConfig, generatorSvc *service.GeneratorService, importSvc *service.ImportService) *ZoneDiscoverer {
+	d := &ZoneDiscoverer{
+		zones:        make(map[string]config.DNSTransferConfig),
+		timeout:      dnsConfig.Timeout,
+		generatorSvc: generatorSvc,
+		importSvc:    importSvc,
+	}
+
+	for _, zone := range dnsConfig.Transfers {
+		d.zones[strings.ToLower(dns.Fqdn(zone.Zone))] = zone
+	}
+
+	return d
+}
+
+// SetAuthorizationService enables checking the caller may import into each
+// template before discovered names are imported
+func (d *ZoneDiscoverer) SetAuthorizationService(authz *service.AuthorizationService) {
+	d.authz = authz
+}
+
+// DiscoveryOptions represents options for a zone discovery
+type DiscoveryOptions struct {
+	Zone       string `json:"zone" binding:"required"`
+	TemplateID int64  `json:"template_id"` // 0 matches against every active template
+	Type       string `json:"type" binding:"omitempty,oneof=axfr ixfr"`
+	Serial     uint32 `json:"serial"` // serial already seen, for IXFR
+	Import     bool   `json:"import"` // import the matches as committed hostnames
+	ImportedBy string `json:"-"`
+}
+
+// DiscoveryResult represents the result of a zone discovery
+type DiscoveryResult struct {
+	Zone           string              `json:"zone"`
+	TransferType   string              `json:"transfer_type"`
+	Serial         uint32              `json:"serial"`
+	TotalNames     int                 `json:"total_names"`
+	MatchedNames   int                 `json:"matched_names"`
+	UnmatchedNames int                 `json:"unmatched_names"`
+	Unmatched      []string            `json:"unmatched,omitempty"` // the first names that matched no template
+	Templates      []TemplateDiscovery `json:"templates"`
+	Duration       string              `json:"duration"`
+}
+
+// TemplateDiscovery lists the names in a zone that match one template
+type TemplateDiscovery struct {
+	TemplateID      int64                        `json:"template_id"`
+	TemplateName    string                       `json:"template_name"`
+	LowestSequence  int                          `json:"lowest_sequence"`
+	HighestSequence int                          `json:"highest_sequence"`
+	Hostnames       []DiscoveredHostname         `json:"hostnames"`
+	Import          *models.HostnameImportReport `json:"import,omitempty"`
+	ImportError     string                       `json:"import_error,omitempty"` // why the names were not imported
+}
+
+// DiscoveredHostname is a name from a zone split into its template groups
+type DiscoveredHostname struct {
+	Hostname    string            `json:"hostname"`
+	IPAddress   string            `json:"ip_address,omitempty"`
+	SequenceNum int               `json:"sequence_num"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+// DiscoverZone transfers a zone and matches its host names against one template, or
+// every active template, reporting the sequence range in use for each. Only names
+// directly below the zone with A, AAAA or CNAME records are considered; a name is
+// assigned to the first template it matches. With Import set, the matches are
+// imported as committed hostnames, one template at a time; a template the caller
+// may not import into, or whose import fails, is reported with an import error
+// and the others are still imported.
+func (d *ZoneDiscoverer) DiscoverZone(ctx context.Context, options DiscoveryOptions) (*DiscoveryResult, error) {
+	startTime := time.Now()
+
+	zoneName := strings.ToLower(dns.Fqdn(options.Zone))
+	zone, ok := d.zones[zoneName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrZoneNotConfigured, options.Zone)
+	}
+	if options.Type == "" {
+		options.Type = TransferAXFR
+	}
+
+	// Get the templates to match against
+	var templates []*models.Template
+	if options.TemplateID > 0 {
+		template, err := d.generatorSvc.GetTemplateByID(ctx, options.TemplateID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template: %w", err)
+		}
+		templates = []*models.Template{template}
+	} else {
+		var err error
+		templates, err = d.generatorSvc.GetActiveTemplates(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get active templates: %w", err)
+		}
+	}
+
+	// Transfer the zone
+	hosts, serial, err := d.transfer(zone, zoneName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiscoveryResult{
+		Zone:         zoneName,
+		TransferType: options.Type,
+		Serial:       serial,
+		TotalNames:   len(hosts),
+	}
+
+	// Match each name against the templates
+	byTemplate := make(map[int64]*TemplateDiscovery)
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		label := strings.TrimSuffix(name, "."+zoneName)
+		if label == name || strings.Contains(label, ".") {
+			d.unmatched(result, name)
+			continue
+		}
+
+		matched := false
+		for _, template := range templates {
+			parsed, err := d.generatorSvc.ParseHostname(template, label)
+			if err != nil {
+				continue
+			}
+
+			discovery, exists := byTemplate[template.ID]
+			if !exists {
+				discovery = &TemplateDiscovery{
+					TemplateID:      template.ID,
+					TemplateName:    template.Name,
+					LowestSequence:  parsed.SequenceNum,
+					HighestSequence: parsed.SequenceNum,
+				}
+				byTemplate[template.ID] = discovery
+			}
+			discovery.Hostnames = append(discovery.Hostnames, DiscoveredHostname{
+				Hostname:    parsed.Name,
+				IPAddress:   hosts[name],
+				SequenceNum: parsed.SequenceNum,
+				Params:      parsed.Params,
+			})
+			if parsed.SequenceNum < discovery.LowestSequence {
+				discovery.LowestSequence = parsed.SequenceNum
+			}
+			if parsed.SequenceNum > discovery.HighestSequence {
+				discovery.HighestSequence = parsed.SequenceNum
+			}
+
+			matched = true
+			break
+		}
+
+		if matched {
+			result.MatchedNames++
+		} else {
+			d.unmatched(result, name)
+		}
+	}
+
+	// Report templates in the order they were tried
+	for _, template := range templates {
+		discovery, exists := byTemplate[template.ID]
+		if !exists {
+			continue
+		}
+		sort.Slice(discovery.Hostnames, func(i, j int) bool {
+			return discovery.Hostnames[i].SequenceNum < discovery.Hostnames[j].SequenceNum
+		})
+
+		if options.Import {
+			report, err := d.importDiscovered(ctx, template, discovery.Hostnames, options.ImportedBy)
+			if err != nil {
+				// Refusals are the caller's to see; other failures are logged
+				discovery.ImportError = "failed to import hostnames"
+				var authErr *service.AuthorizationError
+				if errors.As(err, &authErr) {
+					discovery.ImportError = err.Error()
+				}
+				log.Warn().Err(err).Int64("templateID", template.ID).Str("zone", zoneName).Msg("Failed to import discovered hostnames")
+			}
+			discovery.Import = report
+		}
+
+		result.Templates = append(result.Templates, *discovery)
+	}
+
+	result.Duration = time.Since(startTime).String()
+
+	log.Info().
+		Str("zone", zoneName).
+		Str("type", options.Type).
+		Uint32("serial", serial).
+		Int("names", result.TotalNames).
+		Int("matched", result.MatchedNames).
+		Bool("import", options.Import).
+		Msg("Zone discovery completed")
+
+	return result, nil
+}
+
+// importDiscovered imports the names discovered for a template as committed
+// hostnames, if the caller may import into it
+func (d *ZoneDiscoverer) importDiscovered(ctx context.Context, template *models.Template, hostnames []DiscoveredHostname, importedBy string) (*models.HostnameImportReport, error) {
+	if d.authz != nil {
+		if err := d.authz.Authorize(ctx, models.PermissionHostnameImport, template.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]models.HostnameImportRow, len(hostnames))
+	for i, hostname := range hostnames {
+		rows[i] = models.HostnameImportRow{Line: i + 1, Input: hostname.Hostname, IPAddress: hostname.IPAddress}
+	}
+
+	report, err := d.importSvc.ImportHostnames(ctx, template.ID, rows, false, importedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import hostnames for template %s: %w", template.Name, err)
+	}
+	return report, nil
+}
+
+// unmatched counts a name that matched no template, listing the first few
+func (d *ZoneDiscoverer) unmatched(result *DiscoveryResult, name string) {
+	result.UnmatchedNames++
+	if len(result.Unmatched) < maxUnmatchedReported {
+		result.Unmatched = append(result.Unmatched, name)
+	}
+}
+
+// transfer pulls a zone and returns its host names, each with its first address,
+// and the zone's serial. An IXFR returns only the names added since options.Serial,
+// unless the server falls back to sending the whole zone.
+func (d *ZoneDiscoverer) transfer(zone config.DNSTransferConfig, zoneName string, options DiscoveryOptions) (map[string]string, uint32, error) {
+	m := new(dns.Msg)
+	if options.Type == TransferIXFR {
+		m.SetIxfr(zoneName, options.Serial, ".", ".")
+	} else {
+		m.SetAxfr(zoneName)
+	}
+	signMessage(m, zone.DNSKeyConfig)
+
+	t := &dns.Transfer{
+		DialTimeout:  d.timeout,
+		ReadTimeout:  d.timeout,
+		WriteTimeout: d.timeout,
+	}
+	if zone.KeyName != "" {
+		t.TsigSecret = map[string]string{dns.Fqdn(zone.KeyName): zone.KeySecret}
+	}
+
+	server := serverAddress(zone.Server)
+	envelopes, err := t.In(m, server)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to start transfer of %s from %s: %w", zoneName, server, err)
+	}
+
+	var records []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			// Keep draining so the transfer goroutine can finish
+			if err == nil {
+				err = envelope.Error
+			}
+			continue
+		}
+		records = append(records, envelope.RR...)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to transfer %s from %s: %w", zoneName, server, err)
+	}
+
+	if len(records) == 0 {
+		return nil, 0, fmt.Errorf("transfer of %s returned no records", zoneName)
+	}
+	soa, ok := records[0].(*dns.SOA)
+	if !ok {
+		return nil, 0, fmt.Errorf("transfer of %s did not start with an SOA record", zoneName)
+	}
+
+	hosts := make(map[string]string)
+
+	// An incremental response has a second SOA; a full zone sent in reply to IXFR does not
+	if options.Type == TransferIXFR && len(records) > 1 {
+		if _, incremental := records[1].(*dns.SOA); incremental {
+			// Each old SOA opens a deletion section and each new SOA an addition section
+			adding := true
+			for _, rr := range records[1 : len(records)-1] {
+				if _, isSOA := rr.(*dns.SOA); isSOA {
+					adding = !adding
+					continue
+				}
+				name := strings.ToLower(rr.Header().Name)
+				if adding {
+					addHost(hosts, rr)
+				} else {
+					delete(hosts, name)
+				}
+			}
+			return hosts, soa.Serial, nil
+		}
+	}
+
+	for _, rr := range records {
+		addHost(hosts, rr)
+	}
+
+	return hosts, soa.Serial, nil
+}
+
+// addHost records the owner of an address or alias record, keeping the first address seen
+func addHost(hosts map[string]string, rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+
+	var address net.IP
+	switch record := rr.(type) {
+	case *dns.A:
+		address = record.A
+	case *dns.AAAA:
+		address = record.AAAA
+	case *dns.CNAME:
+	default:
+		return
+	}
+
+	if hosts[name] == "" && address != nil {
+		hosts[name] = address.String()
+		return
+	}
+	if _, exists := hosts[name]; !exists {
+		hosts[name] = ""
+	}
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultUpdateTTL is used for records when a zone doesn't configure a TTL
+	defaultUpdateTTL = 3600
+
+	// fudge is the permitted clock skew for TSIG-signed updates, in seconds
+	fudge = 300
+)
+
+// DNSUpdater keeps DNS records in step with committed hostnames by sending
+// TSIG-signed RFC 2136 dynamic updates
+type DNSUpdater struct {
+	zones     map[string]config.DNSUpdateConfig
+	dnsClient *dns.Client
+}
+
+// NewDNSUpdater creates a new DNSUpdater from the zones configured in dnsConfig
+func NewDNSUpdater(dnsConfig config.DNSConfig) *DNSUpdater {
+	u := &DNSUpdater{
+		zones: make(map[string]config.DNSUpdateConfig),
+		dnsClient: &dns.Client{
+			Timeout:    dnsConfig.Timeout,
+			TsigSecret: make(map[string]string),
+		},
+	}
+
+	for _, zone := range dnsConfig.Updates {
+		u.zones[zone.Template] = zone
+		if zone.KeyName != "" {
+			u.dnsClient.TsigSecret[dns.Fqdn(zone.KeyName)] = zone.KeySecret
+		}
+	}
+
+	return u
+}
+
+// Register adds the A or AAAA record for a committed hostname, and its PTR record
+// if the address falls in a configured reverse zone. Hostnames without an address
+// or whose template has no zone configured are left alone.
+func (u *DNSUpdater) Register(ctx context.Context, template *models.Template, hostname *models.Hostname) error {
+	return u.update(ctx, template, hostname, true)
+}
+
+// Deregister removes the records Register added for a hostname
+func (u *DNSUpdater) Deregister(ctx context.Context, template *models.Template, hostname *models.Hostname) error {
+	return u.update(ctx, template, hostname, false)
+}
+
+// update adds or removes the records of a hostname. If the PTR record can't be
+// updated, the forward record is put back as it was, so that a failed update
+// leaves the hostname's records unchanged.
+func (u *DNSUpdater) update(ctx context.Context, template *models.Template, hostname *models.Hostname, add bool) error {
+	zone, ok := u.zoneFor(template)
+	if !ok || hostname.IPAddress == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(hostname.IPAddress)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q for hostname %s", hostname.IPAddress, hostname.Name)
+	}
+
+	ttl := zone.TTL
+	if ttl == 0 {
+		ttl = defaultUpdateTTL
+	}
+
+	// Forward record
+	fqdn := dns.Fqdn(hostname.Name + "." + strings.TrimSuffix(zone.Zone, "."))
+	header := dns.RR_Header{Name: fqdn, Class: dns.ClassINET, Ttl: ttl}
+	var forward dns.RR
+	if ip4 := ip.To4(); ip4 != nil {
+		header.Rrtype = dns.TypeA
+		forward = &dns.A{Hdr: header, A: ip4}
+	} else {
+		header.Rrtype = dns.TypeAAAA
+		forward = &dns.AAAA{Hdr: header, AAAA: ip}
+	}
+	if err := u.send(ctx, zone, zone.Zone, forward, add); err != nil {
+		return fmt.Errorf("failed to update %s record for %s: %w", dns.TypeToString[header.Rrtype], fqdn, err)
+	}
+
+	// Reverse record
+	reverseName, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return fmt.Errorf("failed to build reverse name for %s: %w", ip, err)
+	}
+	for _, reverseZone := range zone.ReverseZones {
+		if !dns.IsSubDomain(dns.Fqdn(reverseZone), reverseName) {
+			continue
+		}
+
+		ptr := &dns.PTR{
+			Hdr: dns.RR_Header{Name: reverseName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: fqdn,
+		}
+		if err := u.send(ctx, zone, reverseZone, ptr, add); err != nil {
+			err = fmt.Errorf("failed to update PTR record for %s: %w", reverseName, err)
+			if undoErr := u.send(ctx, zone, zone.Zone, forward, !add); undoErr != nil {
+				return fmt.Errorf("%w; failed to undo %s record for %s: %w", err, dns.TypeToString[header.Rrtype], fqdn, undoErr)
+			}
+			return err
+		}
+		break
+	}
+
+	log.Info().
+		Str("hostname", fqdn).
+		Str("ip", ip.String()).
+		Bool("added", add).
+		Msg("DNS records updated")
+
+	return nil
+}
+
+// zoneFor returns the update configuration that applies to a template
+func (u *DNSUpdater) zoneFor(template *models.Template) (config.DNSUpdateConfig, bool) {
+	if zone, ok := u.zones[template.Name]; ok {
+		return zone, true
+	}
+	zone, ok := u.zones["*"]
+	return zone, ok
+}
+
+// send submits a single-record update to the zone's primary server
+func (u *DNSUpdater) send(ctx context.Context, zone config.DNSUpdateConfig, zoneName string, rr dns.RR, add bool) error {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zoneName))
+	if add {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	signMessage(m, zone.DNSKeyConfig)
+
+	server := serverAddress(zone.Server)
+	r, _, err := u.dnsClient.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server %s refused update: %s", server, dns.RcodeToString[r.Rcode])
+	}
+
+	return nil
+}
+
+// signMessage adds a TSIG record to m if a key is configured
+func signMessage(m *dns.Msg, key config.DNSKeyConfig) {
+	if key.KeyName == "" {
+		return
+	}
+
+	algorithm := dns.HmacSHA256
+	if key.KeyAlgorithm != "" {
+		algorithm = dns.Fqdn(strings.ToLower(key.KeyAlgorithm))
+	}
+	m.SetTsig(dns.Fqdn(key.KeyName), algorithm, fudge, time.Now().Unix())
+}
+
+// serverAddress adds the default DNS port to a server given without one
+func serverAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(server, "53")
+	}
+	return server
+}
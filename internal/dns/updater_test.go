@@ -0,0 +1,246 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/config"
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/miekg/dns"
+)
+
+const (
+	testKeyName   = "hns-key."
+	testKeySecret = "c2VjcmV0LWtleS1mb3ItdGVzdGluZy11cGRhdGVz"
+)
+
+// updateServer is a local DNS server that accepts TSIG-signed updates and records them
+type updateServer struct {
+	addr string
+
+	mu       sync.Mutex
+	received []*dns.Msg
+	refused  string // zone whose updates are refused
+}
+
+// startUpdateServer starts an update server that verifies signatures with secret
+func startUpdateServer(t *testing.T, secret string) *updateServer {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	us := &updateServer{addr: pc.LocalAddr().String()}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		TsigSecret:        map[string]string{testKeyName: secret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default accept function answers UPDATE with NOTIMP
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			tsig := r.IsTsig()
+			if tsig == nil || w.TsigStatus() != nil {
+				m.SetRcode(r, dns.RcodeNotAuth)
+				w.WriteMsg(m)
+				return
+			}
+
+			us.mu.Lock()
+			refused := len(r.Question) == 1 && r.Question[0].Name == us.refused
+			if !refused {
+				us.received = append(us.received, r)
+			}
+			us.mu.Unlock()
+			if refused {
+				m.SetRcode(r, dns.RcodeRefused)
+				m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, fudge, time.Now().Unix())
+				w.WriteMsg(m)
+				return
+			}
+
+			m.SetReply(r)
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, fudge, time.Now().Unix())
+			w.WriteMsg(m)
+		}),
+	}
+
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return us
+}
+
+// refuse makes the server refuse updates to zone
+func (us *updateServer) refuse(zone string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.refused = zone
+}
+
+// messages returns the updates received so far and forgets them
+func (us *updateServer) messages() []*dns.Msg {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	received := us.received
+	us.received = nil
+	return received
+}
+
+// newTestUpdater returns an updater sending updates for every template to addr
+func newTestUpdater(addr, secret string) *DNSUpdater {
+	return NewDNSUpdater(config.DNSConfig{
+		Timeout: 2 * time.Second,
+		Updates: []config.DNSUpdateConfig{{
+			Template:     "*",
+			Server:       addr,
+			Zone:         "example.com",
+			ReverseZones: []string{"2.0.192.in-addr.arpa"},
+			TTL:          300,
+			DNSKeyConfig: config.DNSKeyConfig{KeyName: testKeyName, KeySecret: secret},
+		}},
+	})
+}
+
+// assertUpdate checks that m updates zone with a single record of the given type,
+// name and class, whose data ends with value
+func assertUpdate(t *testing.T, m *dns.Msg, zone string, rrtype uint16, name string, class uint16, value string) {
+	t.Helper()
+
+	if m.Opcode != dns.OpcodeUpdate {
+		t.Fatalf("expected an UPDATE, got opcode %s", dns.OpcodeToString[m.Opcode])
+	}
+	if len(m.Question) != 1 || m.Question[0].Name != zone || m.Question[0].Qtype != dns.TypeSOA {
+		t.Fatalf("expected zone section %s SOA, got %v", zone, m.Question)
+	}
+	if len(m.Ns) != 1 {
+		t.Fatalf("expected one record in the update section, got %d", len(m.Ns))
+	}
+
+	hdr := m.Ns[0].Header()
+	if hdr.Rrtype != rrtype || hdr.Name != name || hdr.Class != class {
+		t.Fatalf("expected %s %s %s, got %s", name, dns.ClassToString[class], dns.TypeToString[rrtype], m.Ns[0])
+	}
+	if !strings.HasSuffix(m.Ns[0].String(), value) {
+		t.Fatalf("expected record data %s, got %s", value, m.Ns[0])
+	}
+}
+
+func TestDNSUpdaterRegisterAndDeregister(t *testing.T) {
+	server := startUpdateServer(t, testKeySecret)
+	updater := newTestUpdater(server.addr, testKeySecret)
+
+	template := &models.Template{Name: "web"}
+	hostname := &models.Hostname{Name: "web001", IPAddress: "192.0.2.10"}
+
+	if err := updater.Register(context.Background(), template, hostname); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	added := server.messages()
+	if len(added) != 2 {
+		t.Fatalf("expected forward and reverse updates, got %d messages", len(added))
+	}
+	assertUpdate(t, added[0], "example.com.", dns.TypeA, "web001.example.com.", dns.ClassINET, "192.0.2.10")
+	assertUpdate(t, added[1], "2.0.192.in-addr.arpa.", dns.TypePTR, "10.2.0.192.in-addr.arpa.", dns.ClassINET, "web001.example.com.")
+	if ttl := added[0].Ns[0].Header().Ttl; ttl != 300 {
+		t.Errorf("expected the zone's TTL of 300, got %d", ttl)
+	}
+
+	// Deleting a specific record sends it with class NONE (RFC 2136 section 2.5.4)
+	if err := updater.Deregister(context.Background(), template, hostname); err != nil {
+		t.Fatalf("Deregister failed: %v", err)
+	}
+	removed := server.messages()
+	if len(removed) != 2 {
+		t.Fatalf("expected forward and reverse updates, got %d messages", len(removed))
+	}
+	assertUpdate(t, removed[0], "example.com.", dns.TypeA, "web001.example.com.", dns.ClassNONE, "192.0.2.10")
+	assertUpdate(t, removed[1], "2.0.192.in-addr.arpa.", dns.TypePTR, "10.2.0.192.in-addr.arpa.", dns.ClassNONE, "web001.example.com.")
+}
+
+func TestDNSUpdaterIPv6OutsideReverseZones(t *testing.T) {
+	server := startUpdateServer(t, testKeySecret)
+	updater := newTestUpdater(server.addr, testKeySecret)
+
+	hostname := &models.Hostname{Name: "web002", IPAddress: "2001:db8::2"}
+	if err := updater.Register(context.Background(), &models.Template{Name: "web"}, hostname); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	received := server.messages()
+	if len(received) != 1 {
+		t.Fatalf("expected only the forward update, got %d messages", len(received))
+	}
+	assertUpdate(t, received[0], "example.com.", dns.TypeAAAA, "web002.example.com.", dns.ClassINET, "2001:db8::2")
+}
+
+func TestDNSUpdaterSkipsHostnamesWithoutAddress(t *testing.T) {
+	server := startUpdateServer(t, testKeySecret)
+	updater := newTestUpdater(server.addr, testKeySecret)
+
+	hostname := &models.Hostname{Name: "web003"}
+	if err := updater.Register(context.Background(), &models.Template{Name: "web"}, hostname); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if received := server.messages(); len(received) != 0 {
+		t.Fatalf("expected no updates, got %d messages", len(received))
+	}
+}
+
+func TestDNSUpdaterBadSignatureRefused(t *testing.T) {
+	server := startUpdateServer(t, testKeySecret)
+	updater := newTestUpdater(server.addr, "d3Jvbmctc2VjcmV0LWtleQ==")
+
+	hostname := &models.Hostname{Name: "web004", IPAddress: "192.0.2.20"}
+	err := updater.Register(context.Background(), &models.Template{Name: "web"}, hostname)
+	if err == nil {
+		t.Fatal("expected an update with a bad signature to be refused")
+	}
+	if !strings.Contains(err.Error(), "NOTAUTH") {
+		t.Fatalf("expected a NOTAUTH refusal, got %v", err)
+	}
+	if received := server.messages(); len(received) != 0 {
+		t.Fatalf("expected no updates to be accepted, got %d messages", len(received))
+	}
+}
+
+func TestDNSUpdaterUndoesForwardRecordWhenPTRFails(t *testing.T) {
+	server := startUpdateServer(t, testKeySecret)
+	updater := newTestUpdater(server.addr, testKeySecret)
+	server.refuse("2.0.192.in-addr.arpa.")
+
+	template := &models.Template{Name: "web"}
+	hostname := &models.Hostname{Name: "web005", IPAddress: "192.0.2.30"}
+
+	// The A record added before the refused PTR update is removed again
+	err := updater.Register(context.Background(), template, hostname)
+	if err == nil || !strings.Contains(err.Error(), "PTR") {
+		t.Fatalf("expected the PTR update to fail, got %v", err)
+	}
+	received := server.messages()
+	if len(received) != 2 {
+		t.Fatalf("expected the A record to be added and removed, got %d messages", len(received))
+	}
+	assertUpdate(t, received[0], "example.com.", dns.TypeA, "web005.example.com.", dns.ClassINET, "192.0.2.30")
+	assertUpdate(t, received[1], "example.com.", dns.TypeA, "web005.example.com.", dns.ClassNONE, "192.0.2.30")
+
+	// The A record removed before the refused PTR update is added back
+	err = updater.Deregister(context.Background(), template, hostname)
+	if err == nil || !strings.Contains(err.Error(), "PTR") {
+		t.Fatalf("expected the PTR update to fail, got %v", err)
+	}
+	received = server.messages()
+	if len(received) != 2 {
+		t.Fatalf("expected the A record to be removed and added back, got %d messages", len(received))
+	}
+	assertUpdate(t, received[0], "example.com.", dns.TypeA, "web005.example.com.", dns.ClassNONE, "192.0.2.30")
+	assertUpdate(t, received[1], "example.com.", dns.TypeA, "web005.example.com.", dns.ClassINET, "192.0.2.30")
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntityType identifies the kind of entity an audit event refers to
+type AuditEntityType string
+
+const (
+	AuditEntityHostname       AuditEntityType = "hostname"
+	AuditEntityTemplate       AuditEntityType = "template"
+	AuditEntityUser           AuditEntityType = "user"
+	AuditEntityAPIKey         AuditEntityType = "api_key"
+	AuditEntityRole           AuditEntityType = "role"
+	AuditEntityRoleAssignment AuditEntityType = "role_assignment"
+)
+
+// AuditAction identifies the change recorded by an audit event
+type AuditAction string
+
+const (
+	AuditActionCreate   AuditAction = "create"
+	AuditActionUpdate   AuditAction = "update"
+	AuditActionDelete   AuditAction = "delete"
+	AuditActionReserve  AuditAction = "reserve"
+	AuditActionCommit   AuditAction = "commit"
+	AuditActionRelease  AuditAction = "release"
+	AuditActionExtend   AuditAction = "extend"
+	AuditActionExpire   AuditAction = "expire"
+	AuditActionLogin    AuditAction = "login"
+	AuditActionImport   AuditAction = "import"
+	AuditActionConflict AuditAction = "conflict"
+	AuditActionRotate   AuditAction = "rotate"
+)
+
+// Actor identifies who performed an action
+type Actor struct {
+	UserID     int64  `json:"user_id,omitempty"` // zero for system actors
+	Username   string `json:"username"`
+	AuthMethod string `json:"auth_method"` // jwt, oidc, apikey, password, session or system
+	RequestID  string `json:"request_id,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`
+}
+
+// AuditEvent represents an immutable record of a change
+type AuditEvent struct {
+	ID         int64           `json:"id" db:"id"`
+	EntityType AuditEntityType `json:"entity_type" db:"entity_type"`
+	EntityID   int64           `json:"entity_id" db:"entity_id"`
+	Action     AuditAction     `json:"action" db:"action"`
+	Actor      string          `json:"actor" db:"actor"`
+	AuthMethod string          `json:"auth_method" db:"auth_method"`
+	RequestID  string          `json:"request_id,omitempty" db:"request_id"`
+	ClientIP   string          `json:"client_ip,omitempty" db:"client_ip"`
+	Before     json.RawMessage `json:"before,omitempty" db:"before_state"`
+	After      json.RawMessage `json:"after,omitempty" db:"after_state"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter restricts the audit events returned by a query
+type AuditFilter struct {
+	EntityType AuditEntityType
+	EntityID   int64
+	Actor      string
+	Action     AuditAction
+	From       *time.Time
+	To         *time.Time
+}
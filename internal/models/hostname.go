@@ -12,24 +12,44 @@ const (
 	StatusReserved  HostnameStatus = "reserved"
 	StatusCommitted HostnameStatus = "committed"
 	StatusReleased  HostnameStatus = "released"
+	StatusExpired   HostnameStatus = "expired"
+	StatusConflict  HostnameStatus = "conflict" // already in DNS when it was to be reserved; never offered
+)
+
+// DNSDrift describes how a hostname's DNS records disagree with its status
+type DNSDrift string
+
+const (
+	DNSDriftNone            DNSDrift = ""
+	DNSDriftMissing         DNSDrift = "missing"          // committed but not in DNS
+	DNSDriftUnexpected      DNSDrift = "unexpected"       // reserved, released or expired but in DNS
+	DNSDriftAddressMismatch DNSDrift = "address-mismatch" // committed with an address DNS doesn't return
 )
 
 // Hostname represents a generated hostname record
 type Hostname struct {
-	ID          int64          `json:"id" db:"id"`
-	Name        string         `json:"name" db:"name"`
-	TemplateID  int64          `json:"template_id" db:"template_id"`
-	Status      HostnameStatus `json:"status" db:"status"`
-	SequenceNum int            `json:"sequence_num" db:"sequence_num"`
-	ReservedBy  string         `json:"reserved_by" db:"reserved_by"`
-	ReservedAt  time.Time      `json:"reserved_at" db:"reserved_at"`
-	CommittedBy string         `json:"committed_by,omitempty" db:"committed_by"`
-	CommittedAt *time.Time     `json:"committed_at,omitempty" db:"committed_at"`
-	ReleasedBy  string         `json:"released_by,omitempty" db:"released_by"`
-	ReleasedAt  *time.Time     `json:"released_at,omitempty" db:"released_at"`
-	DNSVerified bool           `json:"dns_verified" db:"dns_verified"`
-	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+	ID              int64          `json:"id" db:"id"`
+	Name            string         `json:"name" db:"name"`
+	TemplateID      int64          `json:"template_id" db:"template_id"`
+	TemplateVersion int            `json:"template_version" db:"template_version"`
+	Status          HostnameStatus `json:"status" db:"status"`
+	SequenceNum     int            `json:"sequence_num" db:"sequence_num"`
+	SequenceScope   string         `json:"sequence_scope,omitempty" db:"sequence_scope_key"` // counter the sequence was drawn from, empty for the template-wide counter
+	ReservedBy      string         `json:"reserved_by" db:"reserved_by"`
+	ReservedAt      time.Time      `json:"reserved_at" db:"reserved_at"`
+	ExpiresAt       *time.Time     `json:"expires_at,omitempty" db:"expires_at"`
+	CommittedBy     string         `json:"committed_by,omitempty" db:"committed_by"`
+	CommittedAt     *time.Time     `json:"committed_at,omitempty" db:"committed_at"`
+	IPAddress       string         `json:"ip_address,omitempty" db:"ip_address"`
+	ReleasedBy      string         `json:"released_by,omitempty" db:"released_by"`
+	ReleasedAt      *time.Time     `json:"released_at,omitempty" db:"released_at"`
+	DNSVerified     bool           `json:"dns_verified" db:"dns_verified"`
+	LastDNSCheck    *time.Time     `json:"last_dns_check,omitempty" db:"last_dns_check"`
+	DNSIPAddress    string         `json:"dns_ip_address,omitempty" db:"dns_ip_address"` // first address DNS returned at the last check
+	DNSDrift        DNSDrift       `json:"dns_drift,omitempty" db:"dns_drift"`
+	ConflictReason  string         `json:"conflict_reason,omitempty" db:"conflict_reason"`
+	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
 }
 
 // HostnameReservationRequest represents a request to reserve a hostname
@@ -37,12 +57,46 @@ type HostnameReservationRequest struct {
 	TemplateID  int64             `json:"template_id" binding:"required"`
 	Params      map[string]string `json:"params,omitempty"`
 	RequestedBy string            `json:"requested_by" binding:"required"`
+	CheckDNS    *bool             `json:"check_dns,omitempty"` // overrides the template's dns_check_on_reserve
+}
+
+// HostnameReservationResponse is a reserved hostname together with how its sequence number was chosen
+type HostnameReservationResponse struct {
+	*Hostname
+	AllocationStrategy AllocationStrategy `json:"allocation_strategy"`
+	DNSChecked         bool               `json:"dns_checked"`
+	DNSCheckError      string             `json:"dns_check_error,omitempty"` // the name could not be checked, so it was reserved unchecked
+	Skipped            []SkippedHostname  `json:"skipped,omitempty"`
+}
+
+// SkippedHostname is a candidate name passed over during a reservation
+type SkippedHostname struct {
+	ID          int64          `json:"id"`
+	Name        string         `json:"name"`
+	SequenceNum int            `json:"sequence_num"`
+	Status      HostnameStatus `json:"status"`
+	Reason      string         `json:"reason"`
+}
+
+// SequenceScopeKeyTemplate is the sequence scope key of a globally scoped template's
+// counter, which spans every hostname of the template. Keys of scoped counters are
+// never empty, so they can be compared exactly.
+const SequenceScopeKeyTemplate = ""
+
+// SequenceAllocation describes how sequence numbers are chosen for a reservation
+type SequenceAllocation struct {
+	Strategy   AllocationStrategy
+	Start      int // lowest number the lowest-free strategies hand out
+	Increment  int
+	Cooldown   time.Duration // how long released numbers stay unavailable, for lowest-free-after-cooldown
+	Contiguous bool          // give the hostnames of one reservation consecutive numbers
 }
 
 // HostnameCommitRequest represents a request to commit a reserved hostname
 type HostnameCommitRequest struct {
 	HostnameID  int64  `json:"hostname_id" binding:"required"`
 	CommittedBy string `json:"committed_by" binding:"required"`
+	IPAddress   string `json:"ip_address,omitempty" binding:"omitempty,ip"` // registered in DNS when dynamic updates are configured
 }
 
 // HostnameReleaseRequest represents a request to release a committed hostname
@@ -51,12 +105,147 @@ type HostnameReleaseRequest struct {
 	ReleasedBy string `json:"released_by" binding:"required"`
 }
 
+// HostnameExtendRequest represents a request to extend a hostname reservation
+type HostnameExtendRequest struct {
+	HostnameID int64  `json:"-"`
+	ExtendBy   int    `json:"extend_by" binding:"required,min=1"` // in seconds
+	ExtendedBy string `json:"-"`
+}
+
+// BulkMode controls how a bulk operation handles items that fail
+type BulkMode string
+
+const (
+	// BulkModeAllOrNothing applies every item or none of them
+	BulkModeAllOrNothing BulkMode = "all_or_nothing"
+	// BulkModeBestEffort applies every item that can be applied and reports the rest
+	BulkModeBestEffort BulkMode = "best_effort"
+)
+
+// HostnameBulkReservationRequest represents a request to reserve several hostnames at once.
+// Either Count hostnames are reserved with the same Params, or one hostname per entry in ParamSets.
+type HostnameBulkReservationRequest struct {
+	TemplateID  int64               `json:"template_id" binding:"required"`
+	Params      map[string]string   `json:"params,omitempty"`
+	Count       int                 `json:"count" binding:"omitempty,min=1,max=1000"`
+	ParamSets   []map[string]string `json:"param_sets,omitempty" binding:"omitempty,max=1000"`
+	Contiguous  bool                `json:"contiguous"`
+	RequestedBy string              `json:"-"`
+}
+
+// HostnameBulkRequest represents a request to commit or release several hostnames at once
+type HostnameBulkRequest struct {
+	HostnameIDs []int64          `json:"hostname_ids" binding:"required,min=1,max=1000"`
+	Mode        BulkMode         `json:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+	IPAddresses map[int64]string `json:"ip_addresses,omitempty" binding:"omitempty,dive,ip"` // commits only: address per hostname ID, registered in DNS like a single commit
+	RequestedBy string           `json:"-"`
+}
+
+// BulkItemResult reports the outcome of a single item in a bulk operation
+type BulkItemResult struct {
+	Index      int       `json:"index"`
+	HostnameID int64     `json:"hostname_id,omitempty"`
+	Hostname   *Hostname `json:"hostname,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// BulkOperationResponse represents the result of a bulk operation
+type BulkOperationResponse struct {
+	Mode               BulkMode           `json:"mode"`
+	AllocationStrategy AllocationStrategy `json:"allocation_strategy,omitempty"` // for reservations
+	Total              int                `json:"total"`
+	Succeeded          int                `json:"succeeded"`
+	Failed             int                `json:"failed"`
+	Results            []BulkItemResult   `json:"results"`
+}
+
+// ParsedHostname is a hostname split back into the template groups that produced it
+type ParsedHostname struct {
+	Name         string            `json:"name"`
+	TemplateID   int64             `json:"template_id"`
+	TemplateName string            `json:"template_name"`
+	SequenceNum  int               `json:"sequence_num"`
+	Params       map[string]string `json:"params"`
+}
+
+// HostnameMismatch describes where a hostname stopped matching a template
+type HostnameMismatch struct {
+	Group     string `json:"group,omitempty"`
+	Position  int    `json:"position"` // 1-based character position in the hostname
+	Remainder string `json:"remainder"`
+	Reason    string `json:"reason"`
+}
+
+// HostnameParseRequest represents a request to split a hostname into template groups.
+// Either a template is named or every active template is tried.
+type HostnameParseRequest struct {
+	Hostname     string `json:"hostname" binding:"required"`
+	TemplateID   int64  `json:"template_id"`
+	AllTemplates bool   `json:"all_templates"`
+}
+
+// HostnameParseResult reports whether a hostname matched one template
+type HostnameParseResult struct {
+	TemplateID   int64             `json:"template_id"`
+	TemplateName string            `json:"template_name"`
+	Matched      bool              `json:"matched"`
+	Parsed       *ParsedHostname   `json:"parsed,omitempty"`
+	Mismatch     *HostnameMismatch `json:"mismatch,omitempty"`
+}
+
+// ImportRowStatus describes what happened to a row of a hostname import
+type ImportRowStatus string
+
+const (
+	ImportRowImported  ImportRowStatus = "imported"
+	ImportRowValid     ImportRowStatus = "valid" // would be imported outside a dry run
+	ImportRowMismatch  ImportRowStatus = "mismatch"
+	ImportRowDuplicate ImportRowStatus = "duplicate"
+	ImportRowExists    ImportRowStatus = "exists"
+	ImportRowFailed    ImportRowStatus = "failed" // could not be checked
+)
+
+// HostnameImportRow is a single hostname read from an import file
+type HostnameImportRow struct {
+	Line        int               `json:"line"`
+	Input       string            `json:"input"`
+	IPAddress   string            `json:"ip_address,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	SequenceNum int               `json:"sequence_num,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Status      ImportRowStatus   `json:"status"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// HostnameImportReport summarizes a hostname import
+type HostnameImportReport struct {
+	TemplateID int64               `json:"template_id"`
+	DryRun     bool                `json:"dry_run"`
+	Total      int                 `json:"total"`
+	Imported   int                 `json:"imported"`
+	Rejected   int                 `json:"rejected"`
+	Rows       []HostnameImportRow `json:"rows"`
+}
+
 // DNSVerificationResult represents a DNS verification result
 type DNSVerificationResult struct {
-	Hostname   string    `json:"hostname"`
-	Exists     bool      `json:"exists"`
-	IPAddress  string    `json:"ip_address,omitempty"`
-	VerifiedAt time.Time `json:"verified_at"`
+	Hostname    string      `json:"hostname"`
+	Exists      bool        `json:"exists"`
+	IPAddress   string      `json:"ip_address,omitempty"`   // first address found
+	MatchedName string      `json:"matched_name,omitempty"` // fully qualified name that resolved
+	Domain      string      `json:"domain,omitempty"`       // search domain appended to the hostname, if any
+	Server      string      `json:"server,omitempty"`       // DNS server that answered
+	Records     []DNSRecord `json:"records,omitempty"`
+	VerifiedAt  time.Time   `json:"verified_at"`
+}
+
+// DNSRecord is a single resource record found while verifying a hostname
+type DNSRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
 }
 
 // HostnameScanResponse represents a response to a hostname scan request
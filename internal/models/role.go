@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+)
+
+// Permission names an action a role allows
+type Permission string
+
+const (
+	PermissionAll                  Permission = "*"
+	PermissionTemplateCreate       Permission = "template:create"
+	PermissionTemplateUpdate       Permission = "template:update"
+	PermissionTemplateDelete       Permission = "template:delete"
+	PermissionHostnameReserve      Permission = "hostname:reserve"
+	PermissionHostnameCommit       Permission = "hostname:commit"
+	PermissionHostnameRelease      Permission = "hostname:release"       // hostnames the caller committed
+	PermissionHostnameForceRelease Permission = "hostname:force-release" // hostnames committed by anyone
+	PermissionHostnameImport       Permission = "hostname:import"
+	PermissionDNSManage            Permission = "dns:manage"
+	PermissionUserManage           Permission = "user:manage"
+	PermissionRoleManage           Permission = "role:manage"
+	PermissionAuditRead            Permission = "audit:read"
+)
+
+// Permissions lists every permission a role can be given
+var Permissions = []Permission{
+	PermissionAll,
+	PermissionTemplateCreate,
+	PermissionTemplateUpdate,
+	PermissionTemplateDelete,
+	PermissionHostnameReserve,
+	PermissionHostnameCommit,
+	PermissionHostnameRelease,
+	PermissionHostnameForceRelease,
+	PermissionHostnameImport,
+	PermissionDNSManage,
+	PermissionUserManage,
+	PermissionRoleManage,
+	PermissionAuditRead,
+}
+
+// RoleTemplateOwner is the built-in role that manages a template and its
+// hostnames, when assigned for that template
+const RoleTemplateOwner Role = "template-owner"
+
+// RoleDefinition is a named set of permissions
+type RoleDefinition struct {
+	ID          int64        `json:"id" db:"id"`
+	Name        Role         `json:"name" db:"name"`
+	Description string       `json:"description" db:"description"`
+	Permissions []Permission `json:"permissions"`
+	Builtin     bool         `json:"builtin" db:"builtin"`
+	CreatedAt   time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// RoleAssignment gives a user a role beyond the one on their account, either
+// everywhere or for a single template
+type RoleAssignment struct {
+	ID         int64     `json:"id" db:"id"`
+	UserID     int64     `json:"user_id" db:"user_id"`
+	RoleID     int64     `json:"role_id" db:"role_id"`
+	Role       Role      `json:"role" db:"-"`
+	TemplateID *int64    `json:"template_id,omitempty" db:"template_id"` // nil for a global assignment
+	CreatedBy  string    `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// RoleCreateRequest represents a request to create a custom role
+type RoleCreateRequest struct {
+	Name        string       `json:"name" binding:"required,max=50"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" binding:"required"`
+}
+
+// RoleUpdateRequest represents a request to change a custom role
+type RoleUpdateRequest struct {
+	Description *string      `json:"description"`
+	Permissions []Permission `json:"permissions"` // replaces the role's permissions when given
+}
+
+// RoleAssignmentRequest represents a request to give a user a role
+type RoleAssignmentRequest struct {
+	Role       string `json:"role" binding:"required"`
+	TemplateID *int64 `json:"template_id,omitempty"` // omit to assign the role everywhere
+}
+
+// UserPermissions holds the permissions a user has everywhere and those they
+// have for particular templates
+type UserPermissions struct {
+	Global    map[Permission]bool           `json:"global"`
+	Templates map[int64]map[Permission]bool `json:"templates,omitempty"`
+}
+
+// Has reports whether the permissions include permission, everywhere or for
+// the template. A zero templateID checks only the global permissions.
+func (p *UserPermissions) Has(permission Permission, templateID int64) bool {
+	if p.Global[PermissionAll] || p.Global[permission] {
+		return true
+	}
+	if templateID == 0 {
+		return false
+	}
+	template := p.Templates[templateID]
+	return template[PermissionAll] || template[permission]
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ScanJobStatus represents the state of a DNS scan job
+type ScanJobStatus string
+
+const (
+	ScanJobPending   ScanJobStatus = "pending"
+	ScanJobRunning   ScanJobStatus = "running"
+	ScanJobCompleted ScanJobStatus = "completed"
+	ScanJobFailed    ScanJobStatus = "failed"
+	ScanJobCancelled ScanJobStatus = "cancelled"
+)
+
+// Finished reports whether a job in this state will make no further progress
+func (s ScanJobStatus) Finished() bool {
+	return s == ScanJobCompleted || s == ScanJobFailed || s == ScanJobCancelled
+}
+
+// ScanJob represents a DNS scan running in the background. Options and Result
+// hold the scan's request and (partial, while running) result documents.
+type ScanJob struct {
+	ID          int64           `json:"id" db:"id"`
+	TemplateID  int64           `json:"template_id" db:"template_id"`
+	Status      ScanJobStatus   `json:"status" db:"status"`
+	Options     json.RawMessage `json:"options" db:"options"`
+	Total       int             `json:"total" db:"total"`
+	Checked     int             `json:"checked" db:"checked"`
+	Existing    int             `json:"existing" db:"existing"`
+	Result      json.RawMessage `json:"result,omitempty" db:"result"`
+	Error       string          `json:"error,omitempty" db:"error"`
+	CreatedBy   string          `json:"created_by" db:"created_by"`
+	CreatedByID int64           `json:"created_by_id,omitempty" db:"created_by_id"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+}
@@ -26,6 +26,31 @@ const (
 	ValidationTypeSequence TemplateValidationType = "sequence"
 )
 
+// SequenceScope determines which hostnames of a template share a sequence counter
+type SequenceScope string
+
+const (
+	// SequenceScopeGlobal numbers all hostnames of a template in one sequence
+	SequenceScopeGlobal SequenceScope = "global"
+	// SequenceScopePrefix restarts numbering for each combination of group values
+	SequenceScopePrefix SequenceScope = "prefix"
+	// SequenceScopeGroups restarts numbering for each combination of the chosen groups
+	SequenceScopeGroups SequenceScope = "groups"
+)
+
+// AllocationStrategy determines which sequence number a reservation receives
+type AllocationStrategy string
+
+const (
+	// AllocationNextHighest always uses one more than the highest number ever used
+	AllocationNextHighest AllocationStrategy = "next-highest"
+	// AllocationLowestFree reuses the lowest number not held by a reserved or committed hostname
+	AllocationLowestFree AllocationStrategy = "lowest-free"
+	// AllocationLowestFreeAfterCooldown reuses the lowest free number, but only once
+	// its hostname has been released for longer than the release cooldown
+	AllocationLowestFreeAfterCooldown AllocationStrategy = "lowest-free-after-cooldown"
+)
+
 // Template represents a hostname template
 type Template struct {
 	ID                int64         `json:"id" db:"id"`
@@ -38,12 +63,30 @@ type Template struct {
 	SequencePadding   bool          `json:"sequence_padding" db:"sequence_padding"`
 	SequenceIncrement int           `json:"sequence_increment" db:"sequence_increment"`
 	SequencePosition  int           `json:"sequence_position" db:"sequence_position"`
+	SequenceScope     SequenceScope `json:"sequence_scope" db:"sequence_scope"`
+	SequenceScopeGroups []string    `json:"sequence_scope_groups,omitempty" db:"sequence_scope_groups"` // group names, for the groups scope
+	AllocationStrategy AllocationStrategy `json:"allocation_strategy" db:"allocation_strategy"`
+	ReleaseCooldown   int           `json:"release_cooldown" db:"release_cooldown"` // in seconds, 0 uses the server default
+	DNSCheckOnReserve bool          `json:"dns_check_on_reserve" db:"dns_check_on_reserve"` // skip candidate names that already resolve
+	Version           int           `json:"version" db:"version"`
+	StrictValidation  bool          `json:"strict_validation" db:"strict_validation"`
+	ReservationTTL    int           `json:"reservation_ttl" db:"reservation_ttl"` // in seconds, 0 uses the server default
 	CreatedBy         string        `json:"created_by" db:"created_by"`
 	CreatedAt         time.Time     `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time     `json:"updated_at" db:"updated_at"`
 	IsActive          bool          `json:"is_active" db:"is_active"`
 }
 
+// TemplateVersion represents an immutable snapshot of a template definition
+type TemplateVersion struct {
+	ID         int64     `json:"id" db:"id"`
+	TemplateID int64     `json:"template_id" db:"template_id"`
+	Version    int       `json:"version" db:"version"`
+	Definition Template  `json:"definition" db:"definition"`
+	CreatedBy  string    `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
 // TemplateCreateRequest represents a request to create a new template
 type TemplateCreateRequest struct {
 	Name              string        `json:"name" binding:"required"`
@@ -54,6 +97,13 @@ type TemplateCreateRequest struct {
 	SequenceLength    int           `json:"sequence_length" binding:"required,min=1,max=10"`
 	SequencePadding   bool          `json:"sequence_padding"`
 	SequenceIncrement int           `json:"sequence_increment" binding:"required,min=1"`
+	SequenceScope     string        `json:"sequence_scope" binding:"omitempty,oneof=global prefix groups"`
+	SequenceScopeGroups []string    `json:"sequence_scope_groups"`
+	AllocationStrategy string       `json:"allocation_strategy" binding:"omitempty,oneof=next-highest lowest-free lowest-free-after-cooldown"`
+	ReleaseCooldown   int           `json:"release_cooldown" binding:"min=0"`
+	DNSCheckOnReserve bool          `json:"dns_check_on_reserve"`
+	StrictValidation  *bool         `json:"strict_validation"`
+	ReservationTTL    int           `json:"reservation_ttl" binding:"min=0"`
 	CreatedBy         string        `json:"created_by" binding:"required"`
 }
 
@@ -66,15 +116,25 @@ type TemplateGroupRequest struct {
 	ValidationValue  string `json:"validation_value"`
 }
 
-// TemplateUpdateRequest represents a request to update an existing template
+// TemplateUpdateRequest represents a request to update an existing template.
+// Zero values leave the corresponding field unchanged; when Groups is provided it
+// replaces the template's groups in order.
 type TemplateUpdateRequest struct {
 	Name              string        `json:"name"`
-	Description       string        `json:"description"`
-	MaxLength         int           `json:"max_length" binding:"min=1,max=64"`
-	Groups            []TemplateGroupRequest `json:"groups" binding:"dive"`
-	SequenceStart     int           `json:"sequence_start" binding:"min=0"`
-	SequenceLength    int           `json:"sequence_length" binding:"min=1,max=10"`
-	SequencePadding   bool          `json:"sequence_padding"`
-	SequenceIncrement int           `json:"sequence_increment" binding:"min=1"`
-	UpdatedBy         string        `json:"updated_by" binding:"required"`
-}
\ No newline at end of file
+	Description       *string       `json:"description"`
+	MaxLength         int           `json:"max_length" binding:"omitempty,min=1,max=64"`
+	Groups            []TemplateGroupRequest `json:"groups" binding:"omitempty,dive"`
+	SequenceStart     *int          `json:"sequence_start" binding:"omitempty,min=0"`
+	SequenceLength    int           `json:"sequence_length" binding:"omitempty,min=1,max=10"`
+	SequencePadding   *bool         `json:"sequence_padding"`
+	SequenceIncrement int           `json:"sequence_increment" binding:"omitempty,min=1"`
+	SequenceScope     string        `json:"sequence_scope" binding:"omitempty,oneof=global prefix groups"`
+	SequenceScopeGroups []string    `json:"sequence_scope_groups"` // replaces the scope groups when present
+	AllocationStrategy string       `json:"allocation_strategy" binding:"omitempty,oneof=next-highest lowest-free lowest-free-after-cooldown"`
+	ReleaseCooldown   *int          `json:"release_cooldown" binding:"omitempty,min=0"`
+	DNSCheckOnReserve *bool         `json:"dns_check_on_reserve"`
+	StrictValidation  *bool         `json:"strict_validation"`
+	ReservationTTL    *int          `json:"reservation_ttl" binding:"omitempty,min=0"`
+	IsActive          *bool         `json:"is_active"`
+	UpdatedBy         string        `json:"updated_by"`
+}
@@ -4,7 +4,8 @@ import (
 	"time"
 )
 
-// Role represents a user role
+// Role names a role. A user's account holds one role everywhere; more can be
+// assigned globally or per template.
 type Role string
 
 const (
@@ -23,6 +24,8 @@ type User struct {
 	Role         Role      `json:"role" db:"role"`
 	IsActive     bool      `json:"is_active" db:"is_active"`
 	LastLogin    *time.Time `json:"last_login,omitempty" db:"last_login"`
+	OIDCIssuer   string    `json:"oidc_issuer,omitempty" db:"oidc_issuer"`   // set with OIDCSubject for users signing in through an OIDC provider
+	OIDCSubject  string    `json:"oidc_subject,omitempty" db:"oidc_subject"` // the user's subject at that provider
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -34,7 +37,7 @@ type UserCreateRequest struct {
 	Password  string `json:"password" binding:"required,min=8"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
-	Role      string `json:"role" binding:"required,oneof=admin user"`
+	Role      string `json:"role" binding:"required,max=50"` // name of an existing role
 }
 
 // UserUpdateRequest represents a request to update an existing user
@@ -43,7 +46,7 @@ type UserUpdateRequest struct {
 	Password  string `json:"password" binding:"omitempty,min=8"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
-	Role      string `json:"role" binding:"omitempty,oneof=admin user"`
+	Role      string `json:"role" binding:"omitempty,max=50"`
 	IsActive  *bool  `json:"is_active"`
 }
 
@@ -66,24 +69,58 @@ type APIKey struct {
 	ID          int64     `json:"id" db:"id"`
 	UserID      int64     `json:"user_id" db:"user_id"`
 	Name        string    `json:"name" db:"name"`
-	Key         string    `json:"key,omitempty" db:"key"`
+	Prefix      string    `json:"prefix,omitempty" db:"prefix"` // public part of the key, empty for keys issued before hashing
+	KeyHash     string    `json:"-" db:"key_hash"`                // SHA-256 of the key, which itself is never stored
+	Legacy      bool      `json:"legacy,omitempty" db:"-"`       // issued before hashing, to be rotated
 	Scope       string    `json:"scope" db:"scope"`
+	Grants      []APIKeyGrant `json:"grants,omitempty" db:"grants"` // empty for a key that may act on every hostname
 	LastUsed    *time.Time `json:"last_used,omitempty" db:"last_used"`
 	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	RotatedTo   *int64     `json:"rotated_to,omitempty" db:"rotated_to"` // successor issued when the key was rotated
+	RotatedAt   *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+// APIKeyGrant allows an API key to act on hostnames of the listed templates
+// whose groups hold one of the listed values, e.g. {"params": {"region": ["EUSE"]}}.
+// Leaving out the templates or the groups leaves that part unrestricted. A key
+// with grants may only act where at least one of them allows it.
+type APIKeyGrant struct {
+	TemplateIDs []int64             `json:"template_ids,omitempty"`
+	Params      map[string][]string `json:"params,omitempty"` // allowed values by group name
+}
+
+// ExpiringAPIKey is an API key about to expire, with the user to warn
+type ExpiringAPIKey struct {
+	*APIKey
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
 // APIKeyCreateRequest represents a request to create a new API key
 type APIKeyCreateRequest struct {
-	Name  string `json:"name" binding:"required"`
-	Scope string `json:"scope" binding:"required"`
+	Name      string        `json:"name" binding:"required"`
+	Scope     string        `json:"scope" binding:"required"`
+	Grants    []APIKeyGrant `json:"grants,omitempty"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"` // defaults to the configured lifetime, capped at the configured maximum
 }
 
-// APIKeyResponse represents a response with the API key information
+// APIKeyRotateRequest represents a request to replace an API key with a successor
+type APIKeyRotateRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // of the successor, as for a new key
+}
+
+// APIKeyResponse represents a newly created API key. The key is only ever shown here.
 type APIKeyResponse struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Key       string    `json:"key"`
-	Scope     string    `json:"scope"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID        int64         `json:"id"`
+	Name      string        `json:"name"`
+	Prefix    string        `json:"prefix"`
+	Key       string        `json:"key"`
+	Scope     string        `json:"scope"`
+	Grants    []APIKeyGrant `json:"grants,omitempty"`
+	ExpiresAt time.Time     `json:"expires_at"`
+
+	// Set when the key replaces a rotated one, which stays valid until PreviousExpiresAt
+	RotatedFrom       int64      `json:"rotated_from,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
 }
\ No newline at end of file
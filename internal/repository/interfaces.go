@@ -2,10 +2,15 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/bilbothegreedy/HNS/internal/models"
 )
 
+// ErrHostnameNotFound is returned when looking up a hostname that does not exist
+var ErrHostnameNotFound = errors.New("hostname not found")
+
 // HostnameRepository defines the interface for hostname operations
 type HostnameRepository interface {
 	Create(ctx context.Context, hostname *models.Hostname) error
@@ -14,12 +19,28 @@ type HostnameRepository interface {
 	GetByStatus(ctx context.Context, status models.HostnameStatus, limit, offset int) ([]*models.Hostname, error)
 	GetByTemplateID(ctx context.Context, templateID int64, limit, offset int) ([]*models.Hostname, error)
 	UpdateStatus(ctx context.Context, id int64, status models.HostnameStatus, updatedBy string) error
-	CommitHostname(ctx context.Context, id int64, committedBy string) error
+	CommitHostname(ctx context.Context, id int64, committedBy, ipAddress string) error
+	CommitHostnames(ctx context.Context, ids []int64, committedBy string, ipAddresses map[int64]string) error
 	ReleaseHostname(ctx context.Context, id int64, releasedBy string) error
-	GetNextSequenceNumber(ctx context.Context, templateID int64) (int, error)
+	ReleaseHostnames(ctx context.Context, ids []int64, releasedBy string) error
+	ExtendReservation(ctx context.Context, id int64, expiresAt time.Time) error
+	ExpireReservations(ctx context.Context, now time.Time, releasedBy string) ([]*models.Hostname, error)
+	GetNextSequenceNumber(ctx context.Context, templateID int64, scopeKey string, alloc models.SequenceAllocation) (int, error)
+	GetBySequenceScope(ctx context.Context, templateID int64, scopeKey string) ([]*models.Hostname, error)
+	ReserveNextSequence(ctx context.Context, hostname *models.Hostname, alloc models.SequenceAllocation, generate func(seq int) (string, error)) error
+	ImportHostnames(ctx context.Context, hostnames []*models.Hostname) error
+	ReserveSequences(ctx context.Context, hostnames []*models.Hostname, alloc models.SequenceAllocation, generate func(index, seq int) (string, error)) error
 	Count(ctx context.Context, templateID int64, status models.HostnameStatus) (int, error)
 	List(ctx context.Context, limit, offset int, filters map[string]interface{}) ([]*models.Hostname, int, error)
 	CountByUser(ctx context.Context, username string, status models.HostnameStatus) (int, error)
+	MarkConflict(ctx context.Context, id int64, reason, resolvedIP string, checkedAt time.Time) error
+	ListForDNSCheck(ctx context.Context, afterID int64, limit int) ([]*models.Hostname, error)
+	RecordDNSCheck(ctx context.Context, id int64, verified bool, resolvedIP string, drift models.DNSDrift, checkedAt time.Time) error
+	ListDNSDrift(ctx context.Context, drift models.DNSDrift, limit, offset int) ([]*models.Hostname, int, error)
+	CountDNSDrift(ctx context.Context) (map[models.DNSDrift]int, error)
+	GetCommittedByName(ctx context.Context, name string) (*models.Hostname, error)
+	ListCommitted(ctx context.Context, afterID int64, limit int) ([]*models.Hostname, error)
+	ZoneSerial(ctx context.Context) (int64, error)
 }
 
 // TemplateRepository defines the interface for template operations
@@ -28,20 +49,28 @@ type TemplateRepository interface {
 	GetByID(ctx context.Context, id int64) (*models.Template, error)
 	GetByName(ctx context.Context, name string) (*models.Template, error)
 	List(ctx context.Context, limit, offset int) ([]*models.Template, int, error)
+	ListActive(ctx context.Context) ([]*models.Template, error)
 	Update(ctx context.Context, template *models.Template) error
 	Delete(ctx context.Context, id int64) error
 	GetTemplateGroups(ctx context.Context, templateID int64) ([]models.TemplateGroup, error)
 	CreateTemplateGroup(ctx context.Context, group *models.TemplateGroup) error
 	UpdateTemplateGroup(ctx context.Context, group *models.TemplateGroup) error
 	DeleteTemplateGroup(ctx context.Context, id int64) error
+	SaveVersion(ctx context.Context, template *models.Template, createdBy string, scopeKey func(hostname *models.Hostname) (string, error)) error
+	GetVersion(ctx context.Context, templateID int64, version int) (*models.TemplateVersion, error)
+	ListVersions(ctx context.Context, templateID int64) ([]*models.TemplateVersion, error)
 }
 
+// ErrUserNotFound is returned when looking up a user that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // UserRepository defines the interface for user operations
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id int64) (*models.User, error)
 	GetByUsername(ctx context.Context, username string) (*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error)
 	List(ctx context.Context, limit, offset int) ([]*models.User, int, error)
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id int64) error
@@ -50,8 +79,44 @@ type UserRepository interface {
 	// API Key operations
 	CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error
 	GetAPIKeyByID(ctx context.Context, id int64) (*models.APIKey, error)
-	GetAPIKeyByKey(ctx context.Context, key string) (*models.APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
 	ListAPIKeys(ctx context.Context, userID int64) ([]*models.APIKey, error)
 	DeleteAPIKey(ctx context.Context, id int64) error
 	UpdateAPIKeyLastUsed(ctx context.Context, id int64) error
+	RotateAPIKey(ctx context.Context, id int64, expiresAt time.Time, successor *models.APIKey) error
+	ListExpiringAPIKeys(ctx context.Context, from, to time.Time) ([]*models.ExpiringAPIKey, error)
+}
+
+// ErrRoleInUse is returned when deleting a role that users still hold
+var ErrRoleInUse = errors.New("role is still held by users")
+
+// RoleRepository defines the interface for role and role assignment operations
+type RoleRepository interface {
+	Create(ctx context.Context, role *models.RoleDefinition) error
+	GetByID(ctx context.Context, id int64) (*models.RoleDefinition, error)
+	GetByName(ctx context.Context, name string) (*models.RoleDefinition, error)
+	List(ctx context.Context) ([]*models.RoleDefinition, error)
+	Update(ctx context.Context, role *models.RoleDefinition) error
+	Delete(ctx context.Context, id int64) error
+	CreateAssignment(ctx context.Context, assignment *models.RoleAssignment) error
+	GetAssignment(ctx context.Context, id int64) (*models.RoleAssignment, error)
+	ListAssignments(ctx context.Context, userID int64) ([]*models.RoleAssignment, error)
+	DeleteAssignment(ctx context.Context, id int64) error
+	GetUserPermissions(ctx context.Context, userID int64) (*models.UserPermissions, error)
+}
+
+// AuditRepository defines the interface for audit event operations
+type AuditRepository interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]*models.AuditEvent, int, error)
+}
+
+// ScanJobRepository defines the interface for DNS scan job operations
+type ScanJobRepository interface {
+	Create(ctx context.Context, job *models.ScanJob) error
+	GetByID(ctx context.Context, id int64) (*models.ScanJob, error)
+	List(ctx context.Context, templateID int64, limit, offset int) ([]*models.ScanJob, int, error)
+	Update(ctx context.Context, job *models.ScanJob) error
+	FailUnfinished(ctx context.Context, message string) (int, error)
 }
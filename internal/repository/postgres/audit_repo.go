@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditRepository implements the repository.AuditRepository interface
+type AuditRepository struct {
+	db *DB
+}
+
+// NewAuditRepository creates a new AuditRepository
+func NewAuditRepository(db *DB) repository.AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// Create appends a new audit event
+func (r *AuditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (
+			entity_type, entity_id, action, actor, auth_method,
+			request_id, client_ip, before_state, after_state, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		) RETURNING id
+	`
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	err := r.db.QueryRow(ctx, query,
+		event.EntityType, event.EntityID, event.Action, event.Actor, event.AuthMethod,
+		nullString(event.RequestID), nullString(event.ClientIP),
+		nullJSON(event.Before), nullJSON(event.After), event.CreatedAt,
+	).Scan(&event.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %w", err)
+	}
+
+	return nil
+}
+
+// List retrieves audit events matching the filter, newest first
+func (r *AuditRepository) List(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]*models.AuditEvent, int, error) {
+	// Build the where clause from the filter
+	whereClause := ""
+	args := []interface{}{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		whereClause += fmt.Sprintf(" AND %s $%d", condition, len(args))
+	}
+
+	if filter.EntityType != "" {
+		addCondition("entity_type =", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		addCondition("entity_id =", filter.EntityID)
+	}
+	if filter.Actor != "" {
+		addCondition("actor =", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action =", filter.Action)
+	}
+	if filter.From != nil {
+		addCondition("created_at >=", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("created_at <", *filter.To)
+	}
+
+	// Get total count first
+	var total int
+	countQuery := `SELECT COUNT(*) FROM audit_events WHERE 1=1` + whereClause
+	if err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	// Query events
+	query := `
+		SELECT id, entity_type, entity_id, action, actor, auth_method,
+			request_id, client_ip, before_state, after_state, created_at
+		FROM audit_events
+		WHERE 1=1` + whereClause +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating audit event rows: %w", err)
+	}
+
+	return events, total, nil
+}
+
+// scanAuditEvent reads a single audit event row
+func scanAuditEvent(row pgx.Row) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	var requestID, clientIP sql.NullString
+	var before, after []byte
+
+	err := row.Scan(
+		&event.ID, &event.EntityType, &event.EntityID, &event.Action, &event.Actor, &event.AuthMethod,
+		&requestID, &clientIP, &before, &after, &event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.RequestID = requestID.String
+	event.ClientIP = clientIP.String
+	event.Before = before
+	event.After = after
+
+	return &event, nil
+}
+
+// nullString maps an empty string to SQL NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullID maps a zero ID to SQL NULL
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullJSON maps an empty JSON document to SQL NULL
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
@@ -10,8 +10,24 @@ import (
 	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
 	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
 )
 
+const (
+	// maxReserveAttempts bounds how often a reservation is retried after conflicting
+	// with a concurrent transaction
+	maxReserveAttempts = 5
+
+	// maxSequenceSkips bounds how many taken names a single reservation skips over
+	maxSequenceSkips = 100
+)
+
+// hostnameColumns lists the hostname columns in the order scanHostname expects
+const hostnameColumns = `id, name, template_id, template_version, status, sequence_num,
+			sequence_scope_key, reserved_by, reserved_at, expires_at, committed_by, committed_at, ip_address,
+			released_by, released_at, dns_verified, last_dns_check, dns_ip_address, dns_drift,
+			conflict_reason, created_at, updated_at`
+
 // HostnameRepository implements the repository.HostnameRepository interface
 type HostnameRepository struct {
 	db *DB
@@ -24,99 +40,120 @@ func NewHostnameRepository(db *DB) repository.HostnameRepository {
 
 // Create adds a new hostname to the database
 func (r *HostnameRepository) Create(ctx context.Context, hostname *models.Hostname) error {
+	if err := insertHostname(ctx, r.db, hostname); err != nil {
+		return fmt.Errorf("failed to create hostname: %w", err)
+	}
+
+	return nil
+}
+
+// insertHostname inserts a hostname row using the given querier
+func insertHostname(ctx context.Context, q querier, hostname *models.Hostname) error {
 	query := `
 		INSERT INTO hostnames (
-			name, template_id, status, sequence_num, reserved_by, reserved_at,
+			name, template_id, template_version, status, sequence_num, sequence_scope_key,
+			reserved_by, reserved_at, expires_at, committed_by, committed_at, ip_address,
 			dns_verified, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14
 		) RETURNING id
 	`
 
 	now := time.Now()
 	hostname.CreatedAt = now
 	hostname.UpdatedAt = now
-	hostname.ReservedAt = now
-
-	err := r.db.QueryRow(ctx, query,
-		hostname.Name, hostname.TemplateID, hostname.Status, hostname.SequenceNum,
-		hostname.ReservedBy, hostname.ReservedAt, hostname.DNSVerified, now,
-	).Scan(&hostname.ID)
-
-	if err != nil {
-		return fmt.Errorf("failed to create hostname: %w", err)
+	if hostname.ReservedAt.IsZero() {
+		hostname.ReservedAt = now
 	}
 
-	return nil
+	return q.QueryRow(ctx, query,
+		hostname.Name, hostname.TemplateID, hostname.TemplateVersion, hostname.Status,
+		hostname.SequenceNum, hostname.SequenceScope, hostname.ReservedBy, hostname.ReservedAt, hostname.ExpiresAt,
+		nullString(hostname.CommittedBy), hostname.CommittedAt, nullString(hostname.IPAddress), hostname.DNSVerified, now,
+	).Scan(&hostname.ID)
 }
 
-func (r *HostnameRepository) GetByID(ctx context.Context, id int64) (*models.Hostname, error) {
-	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
-		FROM hostnames
-		WHERE id = $1
-	`
-
+// scanHostname scans a row selected with hostnameColumns, converting NULL columns
+func scanHostname(row pgx.Row) (*models.Hostname, error) {
 	hostname := &models.Hostname{}
 
 	// Temporary variables for handling NULL values
-	var committedBy, releasedBy sql.NullString
-	var committedAt, releasedAt sql.NullTime
-
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-		&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-		&committedBy, &committedAt, &releasedBy,
-		&releasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-	)
+	var committedBy, ipAddress, releasedBy, dnsIPAddress, dnsDrift, conflictReason sql.NullString
+	var expiresAt, committedAt, releasedAt, lastDNSCheck sql.NullTime
 
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("hostname not found: %d", id)
-		}
-		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	if err := row.Scan(
+		&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.TemplateVersion,
+		&hostname.Status, &hostname.SequenceNum, &hostname.SequenceScope, &hostname.ReservedBy, &hostname.ReservedAt,
+		&expiresAt, &committedBy, &committedAt, &ipAddress, &releasedBy, &releasedAt,
+		&hostname.DNSVerified, &lastDNSCheck, &dnsIPAddress, &dnsDrift,
+		&conflictReason, &hostname.CreatedAt, &hostname.UpdatedAt,
+	); err != nil {
+		return nil, err
 	}
 
 	// Handle NULL value conversion
+	if expiresAt.Valid {
+		hostname.ExpiresAt = &expiresAt.Time
+	}
 	if committedBy.Valid {
 		hostname.CommittedBy = committedBy.String
 	}
 	if committedAt.Valid {
 		hostname.CommittedAt = &committedAt.Time
 	}
+	if ipAddress.Valid {
+		hostname.IPAddress = ipAddress.String
+	}
 	if releasedBy.Valid {
 		hostname.ReleasedBy = releasedBy.String
 	}
 	if releasedAt.Valid {
 		hostname.ReleasedAt = &releasedAt.Time
 	}
+	if lastDNSCheck.Valid {
+		hostname.LastDNSCheck = &lastDNSCheck.Time
+	}
+	hostname.DNSIPAddress = dnsIPAddress.String
+	hostname.DNSDrift = models.DNSDrift(dnsDrift.String)
+	hostname.ConflictReason = conflictReason.String
 
 	return hostname, nil
 }
 
-// GetByName retrieves a hostname by its name
-func (r *HostnameRepository) GetByName(ctx context.Context, name string) (*models.Hostname, error) {
+func (r *HostnameRepository) GetByID(ctx context.Context, id int64) (*models.Hostname, error) {
 	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
+		SELECT ` + hostnameColumns + `
 		FROM hostnames
-		WHERE name = $1
+		WHERE id = $1
 	`
 
-	hostname := &models.Hostname{}
-	err := r.db.QueryRow(ctx, query, name).Scan(
-		&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-		&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-		&hostname.CommittedBy, &hostname.CommittedAt, &hostname.ReleasedBy,
-		&hostname.ReleasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-	)
+	hostname, err := scanHostname(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", repository.ErrHostnameNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
 
+	return hostname, nil
+}
+
+// GetByName retrieves a hostname by its name, whatever its case. A name can recur
+// once its sequence number is reused; the hostname currently holding it is
+// preferred, then the most recently updated.
+func (r *HostnameRepository) GetByName(ctx context.Context, name string) (*models.Hostname, error) {
+	query := `
+		SELECT ` + hostnameColumns + `
+		FROM hostnames
+		WHERE LOWER(name) = LOWER($1)
+		ORDER BY status IN ($2, $3), updated_at DESC
+		LIMIT 1
+	`
+
+	hostname, err := scanHostname(r.db.QueryRow(ctx, query, name, models.StatusReleased, models.StatusExpired))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("hostname not found: %s", name)
+			return nil, fmt.Errorf("%w: %s", repository.ErrHostnameNotFound, name)
 		}
 		return nil, fmt.Errorf("failed to get hostname: %w", err)
 	}
@@ -127,9 +164,7 @@ func (r *HostnameRepository) GetByName(ctx context.Context, name string) (*model
 // GetByStatus retrieves hostnames by their status
 func (r *HostnameRepository) GetByStatus(ctx context.Context, status models.HostnameStatus, limit, offset int) ([]*models.Hostname, error) {
 	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
+		SELECT ` + hostnameColumns + `
 		FROM hostnames
 		WHERE status = $1
 		ORDER BY created_at DESC
@@ -144,13 +179,8 @@ func (r *HostnameRepository) GetByStatus(ctx context.Context, status models.Host
 
 	var hostnames []*models.Hostname
 	for rows.Next() {
-		hostname := &models.Hostname{}
-		if err := rows.Scan(
-			&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-			&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-			&hostname.CommittedBy, &hostname.CommittedAt, &hostname.ReleasedBy,
-			&hostname.ReleasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-		); err != nil {
+		hostname, err := scanHostname(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
 		hostnames = append(hostnames, hostname)
@@ -166,9 +196,7 @@ func (r *HostnameRepository) GetByStatus(ctx context.Context, status models.Host
 // GetByTemplateID retrieves hostnames by their template ID
 func (r *HostnameRepository) GetByTemplateID(ctx context.Context, templateID int64, limit, offset int) ([]*models.Hostname, error) {
 	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
+		SELECT ` + hostnameColumns + `
 		FROM hostnames
 		WHERE template_id = $1
 		ORDER BY sequence_num ASC
@@ -183,13 +211,8 @@ func (r *HostnameRepository) GetByTemplateID(ctx context.Context, templateID int
 
 	var hostnames []*models.Hostname
 	for rows.Next() {
-		hostname := &models.Hostname{}
-		if err := rows.Scan(
-			&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-			&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-			&hostname.CommittedBy, &hostname.CommittedAt, &hostname.ReleasedBy,
-			&hostname.ReleasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-		); err != nil {
+		hostname, err := scanHostname(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
 		hostnames = append(hostnames, hostname)
@@ -204,14 +227,21 @@ func (r *HostnameRepository) GetByTemplateID(ctx context.Context, templateID int
 
 // UpdateStatus updates the status of a hostname
 func (r *HostnameRepository) UpdateStatus(ctx context.Context, id int64, status models.HostnameStatus, updatedBy string) error {
+	// Record who made the change in the column matching the new status
 	query := `
 		UPDATE hostnames
-		SET status = $2, updated_at = $3
+		SET status = $2, updated_at = $3,
+			reserved_by = CASE WHEN $2 = 'reserved' THEN $4 ELSE reserved_by END,
+			reserved_at = CASE WHEN $2 = 'reserved' THEN $3 ELSE reserved_at END,
+			committed_by = CASE WHEN $2 = 'committed' THEN $4 ELSE committed_by END,
+			committed_at = CASE WHEN $2 = 'committed' THEN $3 ELSE committed_at END,
+			released_by = CASE WHEN $2 IN ('released', 'expired') THEN $4 ELSE released_by END,
+			released_at = CASE WHEN $2 IN ('released', 'expired') THEN $3 ELSE released_at END
 		WHERE id = $1
 	`
 
 	now := time.Now()
-	_, err := r.db.Exec(ctx, query, id, status, now)
+	_, err := r.db.Exec(ctx, query, id, string(status), now, updatedBy)
 	if err != nil {
 		return fmt.Errorf("failed to update hostname status: %w", err)
 	}
@@ -220,21 +250,42 @@ func (r *HostnameRepository) UpdateStatus(ctx context.Context, id int64, status
 }
 
 // CommitHostname commits a reserved hostname
-func (r *HostnameRepository) CommitHostname(ctx context.Context, id int64, committedBy string) error {
+func (r *HostnameRepository) CommitHostname(ctx context.Context, id int64, committedBy, ipAddress string) error {
+	return commitHostname(ctx, r.db, id, committedBy, ipAddress, time.Now())
+}
+
+// CommitHostnames commits several reserved hostnames in one transaction, recording
+// the address given for each in ipAddresses; if any of them cannot be committed,
+// none are
+func (r *HostnameRepository) CommitHostnames(ctx context.Context, ids []int64, committedBy string, ipAddresses map[int64]string) error {
+	now := time.Now()
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		for _, id := range ids {
+			if err := commitHostname(ctx, tx, id, committedBy, ipAddresses[id], now); err != nil {
+				return fmt.Errorf("hostname %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// commitHostname moves a single reservation to committed status, recording its
+// address if one is given
+func commitHostname(ctx context.Context, q querier, id int64, committedBy, ipAddress string, now time.Time) error {
 	query := `
 		UPDATE hostnames
-		SET status = $2, committed_by = $3, committed_at = $4, updated_at = $4
-		WHERE id = $1 AND status = $5
+		SET status = $2, committed_by = $3, committed_at = $4, updated_at = $4, expires_at = NULL,
+			ip_address = $6
+		WHERE id = $1 AND status = $5 AND (expires_at IS NULL OR expires_at > $4)
 	`
 
-	now := time.Now()
-	res, err := r.db.Exec(ctx, query, id, models.StatusCommitted, committedBy, now, models.StatusReserved)
+	res, err := q.Exec(ctx, query, id, models.StatusCommitted, committedBy, now, models.StatusReserved, nullString(ipAddress))
 	if err != nil {
 		return fmt.Errorf("failed to commit hostname: %w", err)
 	}
 
 	if res.RowsAffected() == 0 {
-		return fmt.Errorf("hostname not found or not in reserved status")
+		return fmt.Errorf("hostname not found, not in reserved status or reservation expired")
 	}
 
 	return nil
@@ -242,14 +293,32 @@ func (r *HostnameRepository) CommitHostname(ctx context.Context, id int64, commi
 
 // ReleaseHostname releases a committed hostname
 func (r *HostnameRepository) ReleaseHostname(ctx context.Context, id int64, releasedBy string) error {
+	return releaseHostname(ctx, r.db, id, releasedBy, time.Now())
+}
+
+// ReleaseHostnames releases several committed hostnames in one transaction; if any
+// of them cannot be released, none are
+func (r *HostnameRepository) ReleaseHostnames(ctx context.Context, ids []int64, releasedBy string) error {
+	now := time.Now()
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		for _, id := range ids {
+			if err := releaseHostname(ctx, tx, id, releasedBy, now); err != nil {
+				return fmt.Errorf("hostname %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// releaseHostname moves a single committed hostname to released status
+func releaseHostname(ctx context.Context, q querier, id int64, releasedBy string, now time.Time) error {
 	query := `
 		UPDATE hostnames
 		SET status = $2, released_by = $3, released_at = $4, updated_at = $4
 		WHERE id = $1 AND status = $5
 	`
 
-	now := time.Now()
-	res, err := r.db.Exec(ctx, query, id, models.StatusReleased, releasedBy, now, models.StatusCommitted)
+	res, err := q.Exec(ctx, query, id, models.StatusReleased, releasedBy, now, models.StatusCommitted)
 	if err != nil {
 		return fmt.Errorf("failed to release hostname: %w", err)
 	}
@@ -261,16 +330,153 @@ func (r *HostnameRepository) ReleaseHostname(ctx context.Context, id int64, rele
 	return nil
 }
 
-// GetNextSequenceNumber gets the next available sequence number for a template
-func (r *HostnameRepository) GetNextSequenceNumber(ctx context.Context, templateID int64) (int, error) {
+// ExtendReservation moves the expiry of a reserved hostname to expiresAt
+func (r *HostnameRepository) ExtendReservation(ctx context.Context, id int64, expiresAt time.Time) error {
+	query := `
+		UPDATE hostnames
+		SET expires_at = $2, updated_at = $3
+		WHERE id = $1 AND status = $4 AND (expires_at IS NULL OR expires_at > $3)
+	`
+
+	now := time.Now()
+	res, err := r.db.Exec(ctx, query, id, expiresAt, now, models.StatusReserved)
+	if err != nil {
+		return fmt.Errorf("failed to extend reservation: %w", err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("hostname not found, not in reserved status or reservation expired")
+	}
+
+	return nil
+}
+
+// ExpireReservations moves every reservation whose expiry is at or before now to
+// expired status, recording releasedBy as the releasing party, and returns the
+// affected hostnames
+func (r *HostnameRepository) ExpireReservations(ctx context.Context, now time.Time, releasedBy string) ([]*models.Hostname, error) {
+	query := `
+		UPDATE hostnames
+		SET status = $1, released_by = $2, released_at = $3, updated_at = $3
+		WHERE status = $4 AND expires_at IS NOT NULL AND expires_at <= $3
+		RETURNING ` + hostnameColumns
+
+	rows, err := r.db.Query(ctx, query, models.StatusExpired, releasedBy, now, models.StatusReserved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire reservations: %w", err)
+	}
+	defer rows.Close()
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
+		}
+		hostnames = append(hostnames, hostname)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	return hostnames, nil
+}
+
+// GetNextSequenceNumber gets the sequence number the next reservation in a template's
+// sequence scope would receive under alloc; models.SequenceScopeKeyTemplate counts
+// across the whole template, any other key only the hostnames numbered under it. Names taken by other templates are not considered.
+func (r *HostnameRepository) GetNextSequenceNumber(ctx context.Context, templateID int64, scopeKey string, alloc models.SequenceAllocation) (int, error) {
+	counter, err := newSequenceCounter(ctx, r.db, templateID, scopeKey, alloc, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.nextFree(counter.next), nil
+}
+
+// sequenceCounter hands out candidate sequence numbers within one sequence scope
+type sequenceCounter struct {
+	// next is the first number to try
+	next      int
+	increment int
+
+	// held records numbers still held by a hostname, for the lowest-free strategies
+	held map[int]bool
+}
+
+// newSequenceCounter prepares the counter for a scope. Next-highest starts after the
+// highest number ever used; the lowest-free strategies start at alloc.Start and skip
+// numbers held by reserved or committed hostnames, or released too recently.
+func newSequenceCounter(ctx context.Context, q querier, templateID int64, scopeKey string, alloc models.SequenceAllocation, now time.Time) (*sequenceCounter, error) {
+	counter := &sequenceCounter{increment: alloc.Increment}
+	if counter.increment <= 0 {
+		counter.increment = 1
+	}
+
+	var releasedBefore time.Time
+	switch alloc.Strategy {
+	case models.AllocationLowestFree:
+		releasedBefore = now
+	case models.AllocationLowestFreeAfterCooldown:
+		releasedBefore = now.Add(-alloc.Cooldown)
+	default:
+		next, err := nextSequenceNumber(ctx, q, templateID, scopeKey)
+		if err != nil {
+			return nil, err
+		}
+		counter.next = next
+		return counter, nil
+	}
+
+	query := `
+		SELECT DISTINCT sequence_num
+		FROM hostnames
+		WHERE template_id = $1 AND ($2::boolean OR sequence_scope_key = $3)
+			AND (status NOT IN ($4, $5) OR released_at IS NULL OR released_at > $6)
+	`
+
+	rows, err := q.Query(ctx, query, templateID, scopeKey == models.SequenceScopeKeyTemplate, scopeKey, models.StatusReleased, models.StatusExpired, releasedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query held sequence numbers: %w", err)
+	}
+	defer rows.Close()
+
+	counter.next = alloc.Start
+	counter.held = make(map[int]bool)
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			return nil, fmt.Errorf("failed to scan sequence number: %w", err)
+		}
+		counter.held[seq] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sequence numbers: %w", err)
+	}
+
+	return counter, nil
+}
+
+// nextFree returns the first number from seq onwards that is not held
+func (c *sequenceCounter) nextFree(seq int) int {
+	for c.held[seq] {
+		seq += c.increment
+	}
+	return seq
+}
+
+// nextSequenceNumber returns one more than the highest sequence number used in a scope
+func nextSequenceNumber(ctx context.Context, q querier, templateID int64, scopeKey string) (int, error) {
 	query := `
 		SELECT COALESCE(MAX(sequence_num), 0) + 1
 		FROM hostnames
-		WHERE template_id = $1
+		WHERE template_id = $1 AND ($2::boolean OR sequence_scope_key = $3)
 	`
 
 	var nextSeq int
-	err := r.db.QueryRow(ctx, query, templateID).Scan(&nextSeq)
+	err := q.QueryRow(ctx, query, templateID, scopeKey == models.SequenceScopeKeyTemplate, scopeKey).Scan(&nextSeq)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get next sequence number: %w", err)
 	}
@@ -278,6 +484,283 @@ func (r *HostnameRepository) GetNextSequenceNumber(ctx context.Context, template
 	return nextSeq, nil
 }
 
+// GetBySequenceScope retrieves every hostname numbered in a template's sequence scope,
+// ordered by sequence number; models.SequenceScopeKeyTemplate returns all hostnames
+// of the template
+func (r *HostnameRepository) GetBySequenceScope(ctx context.Context, templateID int64, scopeKey string) ([]*models.Hostname, error) {
+	query := `
+		SELECT ` + hostnameColumns + `
+		FROM hostnames
+		WHERE template_id = $1 AND ($2::boolean OR sequence_scope_key = $3)
+		ORDER BY sequence_num ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, templateID, scopeKey == models.SequenceScopeKeyTemplate, scopeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hostnames: %w", err)
+	}
+	defer rows.Close()
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
+		}
+		hostnames = append(hostnames, hostname)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	return hostnames, nil
+}
+
+// ReserveNextSequence allocates a free sequence number for the hostname according to
+// alloc and inserts the hostname in a single transaction. The template row is
+// locked for the duration of the transaction, so concurrent reservations against
+// the same template are serialized and can never observe the same free number.
+// Names that are already taken (for example by another template producing the same
+// string) are skipped by advancing the sequence by the increment. Conflicts with
+// concurrent transactions cause the whole allocation to be retried.
+func (r *HostnameRepository) ReserveNextSequence(ctx context.Context, hostname *models.Hostname, alloc models.SequenceAllocation, generate func(seq int) (string, error)) error {
+	alloc.Contiguous = false
+	return r.ReserveSequences(ctx, []*models.Hostname{hostname}, alloc, func(_ int, seq int) (string, error) {
+		return generate(seq)
+	})
+}
+
+// ReserveSequences allocates sequence numbers for several hostnames of the same
+// template and inserts them all in a single transaction, with the same locking and
+// retry behaviour as ReserveNextSequence. generate receives the index of the hostname
+// being named. When alloc.Contiguous is set the hostnames get consecutive sequence
+// numbers (one increment apart); otherwise each takes the next free number after the
+// previous.
+func (r *HostnameRepository) ReserveSequences(ctx context.Context, hostnames []*models.Hostname, alloc models.SequenceAllocation, generate func(index, seq int) (string, error)) error {
+	if len(hostnames) == 0 {
+		return nil
+	}
+	if alloc.Increment <= 0 {
+		alloc.Increment = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxReserveAttempts; attempt++ {
+		err = r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+			return reserveSequencesTx(ctx, tx, hostnames, alloc, generate)
+		})
+		if err == nil {
+			return nil
+		}
+		if !isRetryableTxError(err) {
+			return err
+		}
+
+		log.Debug().
+			Err(err).
+			Int64("templateID", hostnames[0].TemplateID).
+			Int("attempt", attempt).
+			Msg("Hostname reservation conflicted with a concurrent transaction, retrying")
+	}
+
+	return fmt.Errorf("failed to reserve hostname after %d attempts: %w", maxReserveAttempts, err)
+}
+
+// reserveSequencesTx performs a single allocation attempt inside tx
+func reserveSequencesTx(ctx context.Context, tx pgx.Tx, hostnames []*models.Hostname, alloc models.SequenceAllocation, generate func(index, seq int) (string, error)) error {
+	templateID := hostnames[0].TemplateID
+
+	// Lock the template row so allocations for this template run one at a time
+	var version int
+	err := tx.QueryRow(ctx, `SELECT version FROM templates WHERE id = $1 FOR UPDATE`, templateID).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("template not found: %d", templateID)
+		}
+		return fmt.Errorf("failed to lock template: %w", err)
+	}
+
+	// The caller generated names from a specific template version; refuse to
+	// record them against a version that has since been replaced
+	for _, hostname := range hostnames {
+		if hostname.TemplateID != templateID {
+			return fmt.Errorf("all hostnames in a reservation must use the same template")
+		}
+		if hostname.TemplateVersion != 0 && hostname.TemplateVersion != version {
+			return fmt.Errorf("template %d changed during reservation (now version %d)", templateID, version)
+		}
+		hostname.TemplateVersion = version
+	}
+
+	// Each sequence scope has its own counter
+	now := time.Now()
+	counters := make(map[string]*sequenceCounter)
+	for _, hostname := range hostnames {
+		if _, exists := counters[hostname.SequenceScope]; exists {
+			continue
+		}
+		counter, err := newSequenceCounter(ctx, tx, templateID, hostname.SequenceScope, alloc, now)
+		if err != nil {
+			return err
+		}
+		counters[hostname.SequenceScope] = counter
+	}
+
+	if alloc.Contiguous {
+		if len(counters) > 1 {
+			return fmt.Errorf("contiguous reservations must share a sequence scope")
+		}
+		err = allocateContiguous(ctx, tx, hostnames, counters[hostnames[0].SequenceScope], generate)
+	} else {
+		err = allocateNextFree(ctx, tx, hostnames, counters, generate)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, hostname := range hostnames {
+		if err := insertHostname(ctx, tx, hostname); err != nil {
+			return fmt.Errorf("failed to create hostname %s: %w", hostname.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// allocateNextFree names each hostname with the next free sequence number after
+// the previous one in its scope, skipping numbers whose name is already taken.
+// counters holds the counter for each scope key.
+func allocateNextFree(ctx context.Context, q querier, hostnames []*models.Hostname, counters map[string]*sequenceCounter, generate func(index, seq int) (string, error)) error {
+	skipped := 0
+	for i, hostname := range hostnames {
+		counter := counters[hostname.SequenceScope]
+		seq := counter.nextFree(counter.next)
+		for {
+			if skipped >= maxSequenceSkips {
+				return fmt.Errorf("no free hostname found after skipping %d sequence numbers", maxSequenceSkips)
+			}
+
+			name, err := generate(i, seq)
+			if err != nil {
+				return err
+			}
+
+			taken, err := hostnameTaken(ctx, q, name)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				hostname.Name = name
+				hostname.SequenceNum = seq
+				break
+			}
+
+			skipped++
+			seq = counter.nextFree(seq + counter.increment)
+		}
+		counter.next = seq + counter.increment
+	}
+
+	return nil
+}
+
+// allocateContiguous finds the first block of consecutive sequence numbers from the
+// counter's start for which no number is held and every generated name is free
+func allocateContiguous(ctx context.Context, q querier, hostnames []*models.Hostname, counter *sequenceCounter, generate func(index, seq int) (string, error)) error {
+	names := make([]string, len(hostnames))
+	increment := counter.increment
+	skipped := 0
+
+	for start := counter.next; ; {
+		if skipped >= maxSequenceSkips {
+			return fmt.Errorf("no free block of %d hostnames found after skipping %d sequence numbers", len(hostnames), maxSequenceSkips)
+		}
+
+		free := true
+		for i := range hostnames {
+			seq := start + i*increment
+			if counter.held[seq] {
+				// Held numbers are known up front and don't count as skips
+				start = counter.nextFree(seq + increment)
+				free = false
+				break
+			}
+
+			name, err := generate(i, seq)
+			if err != nil {
+				return err
+			}
+
+			taken, err := hostnameTaken(ctx, q, name)
+			if err != nil {
+				return err
+			}
+			if taken {
+				// Restart the block just past the collision
+				skipped += i + 1
+				start = seq + increment
+				free = false
+				break
+			}
+			names[i] = name
+		}
+
+		if free {
+			for i, hostname := range hostnames {
+				hostname.Name = names[i]
+				hostname.SequenceNum = start + i*increment
+			}
+			return nil
+		}
+	}
+}
+
+// hostnameTaken reports whether a hostname with the given name, in any case, is
+// currently held
+func hostnameTaken(ctx context.Context, q querier, name string) (bool, error) {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM hostnames WHERE LOWER(name) = LOWER($1) AND status NOT IN ($2, $3))`
+	err := q.QueryRow(ctx, query, name, models.StatusReleased, models.StatusExpired).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("failed to check hostname availability: %w", err)
+	}
+	return taken, nil
+}
+
+// ImportHostnames inserts pre-existing hostnames in a single transaction; if any
+// of them cannot be inserted, none are. Like reservations, it refuses names built
+// from a template version that has since been replaced, whose sequence scope
+// keys may be stale.
+func (r *HostnameRepository) ImportHostnames(ctx context.Context, hostnames []*models.Hostname) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		versions := make(map[int64]int)
+		for _, hostname := range hostnames {
+			version, locked := versions[hostname.TemplateID]
+			if !locked {
+				err := tx.QueryRow(ctx, `SELECT version FROM templates WHERE id = $1 FOR UPDATE`, hostname.TemplateID).Scan(&version)
+				if err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return fmt.Errorf("template not found: %d", hostname.TemplateID)
+					}
+					return fmt.Errorf("failed to lock template: %w", err)
+				}
+				versions[hostname.TemplateID] = version
+			}
+			if hostname.TemplateVersion != version {
+				return fmt.Errorf("template %d changed during import (now version %d)", hostname.TemplateID, version)
+			}
+		}
+
+		for _, hostname := range hostnames {
+			if err := insertHostname(ctx, tx, hostname); err != nil {
+				return fmt.Errorf("failed to import hostname %s: %w", hostname.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
 // Count counts hostnames by template ID and status
 func (r *HostnameRepository) Count(ctx context.Context, templateID int64, status models.HostnameStatus) (int, error) {
 	query := `
@@ -299,9 +782,7 @@ func (r *HostnameRepository) Count(ctx context.Context, templateID int64, status
 func (r *HostnameRepository) List(ctx context.Context, limit, offset int, filters map[string]interface{}) ([]*models.Hostname, int, error) {
 	// Base query
 	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
+		SELECT ` + hostnameColumns + `
 		FROM hostnames
 		WHERE 1=1
 	`
@@ -341,41 +822,246 @@ func (r *HostnameRepository) List(ctx context.Context, limit, offset int, filter
 
 	var hostnames []*models.Hostname
 	for rows.Next() {
-		hostname := &models.Hostname{}
-
-		// Temporary variables for handling NULL values
-		var committedBy, releasedBy sql.NullString
-		var committedAt, releasedAt sql.NullTime
-
-		if err := rows.Scan(
-			&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-			&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-			&committedBy, &committedAt, &releasedBy,
-			&releasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-		); err != nil {
+		hostname, err := scanHostname(rows)
+		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
 
-		// Handle NULL value conversion
-		if committedBy.Valid {
-			hostname.CommittedBy = committedBy.String
+		hostnames = append(hostnames, hostname)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	return hostnames, total, nil
+}
+
+// MarkConflict turns a reservation into a conflict entry for a name found already
+// in DNS, so its name and sequence number are never handed out again
+func (r *HostnameRepository) MarkConflict(ctx context.Context, id int64, reason, resolvedIP string, checkedAt time.Time) error {
+	query := `
+		UPDATE hostnames
+		SET status = $1, conflict_reason = $2, dns_ip_address = $3, last_dns_check = $4,
+			expires_at = NULL, updated_at = $4
+		WHERE id = $5 AND status = $6
+	`
+
+	result, err := r.db.Exec(ctx, query,
+		models.StatusConflict, reason, nullString(resolvedIP), checkedAt, id, models.StatusReserved,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark hostname as conflict: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("hostname not found or not in reserved status")
+	}
+
+	return nil
+}
+
+// currentHostnameCondition restricts a query on "hostnames h" to the row that
+// currently represents each name: the one holding it, or else the most recent.
+// Older rows of a reused name are history. The placeholders releasedArg and
+// expiredArg must be bound to the released and expired statuses.
+func currentHostnameCondition(releasedArg, expiredArg int) string {
+	return fmt.Sprintf(`(h.status NOT IN ($%[1]d, $%[2]d) OR NOT EXISTS (
+			SELECT 1 FROM hostnames newer
+			WHERE newer.name = h.name AND (newer.id > h.id OR newer.status NOT IN ($%[1]d, $%[2]d))
+		))`, releasedArg, expiredArg)
+}
+
+// ListForDNSCheck retrieves up to limit hostnames with IDs above afterID, in ID
+// order, skipping rows superseded by a later hostname of the same name
+func (r *HostnameRepository) ListForDNSCheck(ctx context.Context, afterID int64, limit int) ([]*models.Hostname, error) {
+	query := `
+		SELECT ` + hostnameColumns + `
+		FROM hostnames h
+		WHERE h.id > $1 AND ` + currentHostnameCondition(3, 4) + `
+		ORDER BY h.id
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, afterID, limit, models.StatusReleased, models.StatusExpired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hostnames for DNS check: %w", err)
+	}
+	defer rows.Close()
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
-		if committedAt.Valid {
-			hostname.CommittedAt = &committedAt.Time
+		hostnames = append(hostnames, hostname)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	return hostnames, nil
+}
+
+// RecordDNSCheck stores the outcome of checking a hostname in DNS. It is not a
+// change to the hostname, so updated_at is left alone.
+func (r *HostnameRepository) RecordDNSCheck(ctx context.Context, id int64, verified bool, resolvedIP string, drift models.DNSDrift, checkedAt time.Time) error {
+	query := `
+		UPDATE hostnames
+		SET dns_verified = $1, dns_ip_address = $2, dns_drift = $3, last_dns_check = $4
+		WHERE id = $5
+	`
+
+	result, err := r.db.Exec(ctx, query, verified, nullString(resolvedIP), nullString(string(drift)), checkedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to record DNS check: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("hostname not found: %d", id)
+	}
+
+	return nil
+}
+
+// ListDNSDrift retrieves the hostnames whose last DNS check found drift, optionally
+// of one kind only, most recently checked first
+func (r *HostnameRepository) ListDNSDrift(ctx context.Context, drift models.DNSDrift, limit, offset int) ([]*models.Hostname, int, error) {
+	whereClause := ` AND ` + currentHostnameCondition(1, 2)
+	args := []interface{}{models.StatusReleased, models.StatusExpired}
+	if drift != models.DNSDriftNone {
+		args = append(args, drift)
+		whereClause += fmt.Sprintf(" AND h.dns_drift = $%d", len(args))
+	}
+
+	// Get total count first
+	var total int
+	countQuery := `SELECT COUNT(*) FROM hostnames h WHERE h.dns_drift IS NOT NULL` + whereClause
+	if err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count drifted hostnames: %w", err)
+	}
+
+	// Query hostnames
+	query := `SELECT ` + hostnameColumns + ` FROM hostnames h WHERE h.dns_drift IS NOT NULL` + whereClause +
+		fmt.Sprintf(" ORDER BY h.last_dns_check DESC, h.id LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query drifted hostnames: %w", err)
+	}
+	defer rows.Close()
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
-		if releasedBy.Valid {
-			hostname.ReleasedBy = releasedBy.String
+		hostnames = append(hostnames, hostname)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	return hostnames, total, nil
+}
+
+// CountDNSDrift counts the hostnames whose last DNS check found drift, by kind
+func (r *HostnameRepository) CountDNSDrift(ctx context.Context) (map[models.DNSDrift]int, error) {
+	query := `
+		SELECT h.dns_drift, COUNT(*)
+		FROM hostnames h
+		WHERE h.dns_drift IS NOT NULL AND ` + currentHostnameCondition(1, 2) + `
+		GROUP BY h.dns_drift
+	`
+
+	rows, err := r.db.Query(ctx, query, models.StatusReleased, models.StatusExpired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count drifted hostnames: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[models.DNSDrift]int)
+	for rows.Next() {
+		var drift models.DNSDrift
+		var count int
+		if err := rows.Scan(&drift, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan drift count: %w", err)
 		}
-		if releasedAt.Valid {
-			hostname.ReleasedAt = &releasedAt.Time
+		counts[drift] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating drift counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetCommittedByName retrieves the committed hostname with a name, compared case
+// insensitively, or nil if no committed hostname has it. Held names are unique
+// regardless of case, so at most one hostname matches.
+func (r *HostnameRepository) GetCommittedByName(ctx context.Context, name string) (*models.Hostname, error) {
+	query := `
+		SELECT ` + hostnameColumns + `
+		FROM hostnames
+		WHERE LOWER(name) = LOWER($1) AND status = $2
+	`
+
+	hostname, err := scanHostname(r.db.QueryRow(ctx, query, name, models.StatusCommitted))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("failed to get committed hostname: %w", err)
+	}
+
+	return hostname, nil
+}
+
+// ListCommitted retrieves up to limit committed hostnames that have an address,
+// with IDs above afterID, in ID order
+func (r *HostnameRepository) ListCommitted(ctx context.Context, afterID int64, limit int) ([]*models.Hostname, error) {
+	query := `
+		SELECT ` + hostnameColumns + `
+		FROM hostnames
+		WHERE id > $1 AND status = $2 AND ip_address IS NOT NULL
+		ORDER BY id
+		LIMIT $3
+	`
 
+	rows, err := r.db.Query(ctx, query, afterID, models.StatusCommitted, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query committed hostnames: %w", err)
+	}
+	defer rows.Close()
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hostname row: %w", err)
+		}
 		hostnames = append(hostnames, hostname)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating hostname rows: %w", err)
+		return nil, fmt.Errorf("error iterating hostname rows: %w", err)
 	}
 
-	return hostnames, total, nil
+	return hostnames, nil
+}
+
+// ZoneSerial returns the serial of the zone of committed hostnames, which a
+// trigger bumps whenever a committed hostname's name or address changes
+func (r *HostnameRepository) ZoneSerial(ctx context.Context) (int64, error) {
+	var serial int64
+	if err := r.db.QueryRow(ctx, `SELECT serial FROM zone_serial`).Scan(&serial); err != nil {
+		return 0, fmt.Errorf("failed to get zone serial: %w", err)
+	}
+
+	return serial, nil
 }
@@ -36,9 +36,7 @@ func (r *HostnameRepository) ListByUser(ctx context.Context, username string, li
 
 	// Get hostnames
 	query := `
-		SELECT id, name, template_id, status, sequence_num, reserved_by, reserved_at,
-			committed_by, committed_at, released_by, released_at, dns_verified,
-			created_at, updated_at
+		SELECT ` + hostnameColumns + `
 		FROM hostnames
 		WHERE reserved_by = $1
 		ORDER BY created_at DESC
@@ -53,13 +51,8 @@ func (r *HostnameRepository) ListByUser(ctx context.Context, username string, li
 
 	var hostnames []*models.Hostname
 	for rows.Next() {
-		hostname := &models.Hostname{}
-		if err := rows.Scan(
-			&hostname.ID, &hostname.Name, &hostname.TemplateID, &hostname.Status,
-			&hostname.SequenceNum, &hostname.ReservedBy, &hostname.ReservedAt,
-			&hostname.CommittedBy, &hostname.CommittedAt, &hostname.ReleasedBy,
-			&hostname.ReleasedAt, &hostname.DNSVerified, &hostname.CreatedAt, &hostname.UpdatedAt,
-		); err != nil {
+		hostname, err := scanHostname(rows)
+		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan hostname row: %w", err)
 		}
 		hostnames = append(hostnames, hostname)
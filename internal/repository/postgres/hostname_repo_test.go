@@ -0,0 +1,270 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDatabaseEnv names the environment variable holding the connection URL of a
+// disposable PostgreSQL database; tests that need a database are skipped without it
+const testDatabaseEnv = "HNS_TEST_DATABASE_URL"
+
+// openTestDB migrates the test database to the latest schema and connects to it
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skipping database test", testDatabaseEnv)
+	}
+
+	m, err := migrate.New("file://../../../migrations", dsn)
+	if err != nil {
+		t.Fatalf("failed to create migration instance: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	m.Close()
+
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", testDatabaseEnv, err)
+	}
+	poolConfig.MaxConns = 20
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	return &DB{pool: pool}
+}
+
+// createTestTemplate creates a template with a global next-highest sequence and
+// removes it, with its hostnames, when the test ends
+func createTestTemplate(t *testing.T, db *DB) *models.Template {
+	t.Helper()
+	ctx := context.Background()
+
+	template := &models.Template{
+		Name:              fmt.Sprintf("concurrency-%d", time.Now().UnixNano()),
+		MaxLength:         15,
+		SequenceStart:     1,
+		SequenceLength:    4,
+		SequencePadding:   true,
+		SequenceIncrement: 1,
+		SequencePosition:  1,
+		CreatedBy:         "test",
+		IsActive:          true,
+	}
+	if err := NewTemplateRepository(db).Create(ctx, template); err != nil {
+		t.Fatalf("failed to create template: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if _, err := db.Exec(ctx, `DELETE FROM hostnames WHERE template_id = $1`, template.ID); err != nil {
+			t.Errorf("failed to delete test hostnames: %v", err)
+		}
+		if _, err := db.Exec(ctx, `DELETE FROM templates WHERE id = $1`, template.ID); err != nil {
+			t.Errorf("failed to delete test template: %v", err)
+		}
+	})
+
+	return template
+}
+
+// assertSequencesComplete checks that the hostnames hold every sequence number
+// from 1 to len(hostnames) exactly once, under distinct names
+func assertSequencesComplete(t *testing.T, hostnames []*models.Hostname) {
+	t.Helper()
+
+	names := make(map[string]bool, len(hostnames))
+	seqs := make([]int, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		if names[hostname.Name] {
+			t.Errorf("hostname %s reserved more than once", hostname.Name)
+		}
+		names[hostname.Name] = true
+		seqs = append(seqs, hostname.SequenceNum)
+	}
+
+	sort.Ints(seqs)
+	for i, seq := range seqs {
+		if seq != i+1 {
+			t.Fatalf("sequence numbers are not 1..%d without gaps or duplicates: position %d holds %d", len(seqs), i, seq)
+		}
+	}
+}
+
+func TestReserveNextSequenceConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	template := createTestTemplate(t, db)
+	repo := NewHostnameRepository(db)
+	alloc := models.SequenceAllocation{Strategy: models.AllocationNextHighest, Start: 1, Increment: 1}
+
+	const reservations = 300
+	hostnames := make([]*models.Hostname, reservations)
+	errs := make(chan error, reservations)
+
+	var wg sync.WaitGroup
+	for i := range hostnames {
+		hostnames[i] = &models.Hostname{
+			TemplateID: template.ID,
+			Status:     models.StatusReserved,
+			ReservedBy: "test",
+			ReservedAt: time.Now(),
+		}
+		wg.Add(1)
+		go func(hostname *models.Hostname) {
+			defer wg.Done()
+			errs <- repo.ReserveNextSequence(context.Background(), hostname, alloc, func(seq int) (string, error) {
+				return fmt.Sprintf("T%d-%04d", template.ID, seq), nil
+			})
+		}(hostnames[i])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("reservation failed: %v", err)
+		}
+	}
+	assertSequencesComplete(t, hostnames)
+
+	count, err := repo.Count(context.Background(), template.ID, models.StatusReserved)
+	if err != nil {
+		t.Fatalf("failed to count hostnames: %v", err)
+	}
+	if count != reservations {
+		t.Fatalf("expected %d reserved hostnames in the database, found %d", reservations, count)
+	}
+}
+
+func TestReserveSequencesConcurrentContiguous(t *testing.T) {
+	db := openTestDB(t)
+	template := createTestTemplate(t, db)
+	repo := NewHostnameRepository(db)
+	alloc := models.SequenceAllocation{Strategy: models.AllocationNextHighest, Start: 1, Increment: 1, Contiguous: true}
+
+	const batches, batchSize = 100, 3
+	results := make([][]*models.Hostname, batches)
+	errs := make(chan error, batches)
+
+	var wg sync.WaitGroup
+	for b := range results {
+		batch := make([]*models.Hostname, batchSize)
+		for i := range batch {
+			batch[i] = &models.Hostname{
+				TemplateID: template.ID,
+				Status:     models.StatusReserved,
+				ReservedBy: "test",
+				ReservedAt: time.Now(),
+			}
+		}
+		results[b] = batch
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.ReserveSequences(context.Background(), batch, alloc, func(_ int, seq int) (string, error) {
+				return fmt.Sprintf("T%d-%04d", template.ID, seq), nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("reservation failed: %v", err)
+		}
+	}
+
+	var all []*models.Hostname
+	for _, batch := range results {
+		for i := 1; i < len(batch); i++ {
+			if batch[i].SequenceNum != batch[0].SequenceNum+i {
+				t.Errorf("batch %s..%s is not contiguous", batch[0].Name, batch[len(batch)-1].Name)
+				break
+			}
+		}
+		all = append(all, batch...)
+	}
+	assertSequencesComplete(t, all)
+}
+
+func TestZoneSerialFollowsCommittedRecords(t *testing.T) {
+	db := openTestDB(t)
+	template := createTestTemplate(t, db)
+	repo := NewHostnameRepository(db)
+	ctx := context.Background()
+
+	hostname := &models.Hostname{TemplateID: template.ID, Status: models.StatusReserved, ReservedBy: "test", ReservedAt: time.Now()}
+	err := repo.ReserveNextSequence(ctx, hostname, models.SequenceAllocation{Strategy: models.AllocationNextHighest, Start: 1, Increment: 1}, func(seq int) (string, error) {
+		return fmt.Sprintf("T%dSERIAL-%04d", template.ID, seq), nil
+	})
+	if err != nil {
+		t.Fatalf("reservation failed: %v", err)
+	}
+
+	serial := func() int64 {
+		t.Helper()
+		s, err := repo.ZoneSerial(ctx)
+		if err != nil {
+			t.Fatalf("ZoneSerial failed: %v", err)
+		}
+		return s
+	}
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+
+	// The reserved hostname is not in the zone yet
+	before := serial()
+
+	// Committing with an address, then changing the address within the same
+	// second, each move the serial
+	exec(`UPDATE hostnames SET status = 'committed', ip_address = '192.0.2.1' WHERE id = $1`, hostname.ID)
+	committed := serial()
+	if committed <= before {
+		t.Fatalf("expected the commit to raise the serial above %d, got %d", before, committed)
+	}
+	exec(`UPDATE hostnames SET ip_address = '192.0.2.2' WHERE id = $1`, hostname.ID)
+	readdressed := serial()
+	if readdressed <= committed {
+		t.Fatalf("expected the address change to raise the serial above %d, got %d", committed, readdressed)
+	}
+
+	// DNS checks and other bookkeeping leave it alone
+	if err := repo.RecordDNSCheck(ctx, hostname.ID, true, "192.0.2.2", models.DNSDriftNone, time.Now()); err != nil {
+		t.Fatalf("RecordDNSCheck failed: %v", err)
+	}
+	if s := serial(); s != readdressed {
+		t.Fatalf("expected a DNS check to leave the serial at %d, got %d", readdressed, s)
+	}
+
+	// Releasing removes the record
+	exec(`UPDATE hostnames SET status = 'released' WHERE id = $1`, hostname.ID)
+	if s := serial(); s <= readdressed {
+		t.Fatalf("expected the release to raise the serial above %d, got %d", readdressed, s)
+	}
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -17,6 +18,14 @@ type DB struct {
 	pool *pgxpool.Pool
 }
 
+// querier is the set of query methods shared by *DB and pgx.Tx, so statements
+// can run either directly on the pool or inside a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
 // Pool returns the underlying connection pool
 func (db *DB) Pool() *pgxpool.Pool {
 	return db.pool
@@ -100,3 +109,19 @@ func (db *DB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn
 	return db.pool.Exec(ctx, sql, args...)
 }
 
+// isRetryableTxError reports whether a transaction failed because of a conflict
+// with a concurrent transaction (unique violation, serialization failure or
+// deadlock) and can safely be retried
+func isRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case "23505", "40001", "40P01":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,319 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RoleRepository implements the repository.RoleRepository interface
+type RoleRepository struct {
+	db *DB
+}
+
+// NewRoleRepository creates a new RoleRepository
+func NewRoleRepository(db *DB) repository.RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+// roleQuery selects roles with their permissions, in the order scanRole reads them
+const roleQuery = `
+	SELECT r.id, r.name, r.description, r.builtin, r.created_at, r.updated_at,
+		COALESCE(array_agg(p.permission ORDER BY p.permission) FILTER (WHERE p.permission IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions p ON p.role_id = r.id
+`
+
+// assignmentColumns lists the role assignment columns in the order scanAssignment reads them
+const assignmentColumns = `a.id, a.user_id, a.role_id, r.name, a.template_id, a.created_by, a.created_at`
+
+// Create adds a new role with its permissions
+func (r *RoleRepository) Create(ctx context.Context, role *models.RoleDefinition) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		now := time.Now()
+		err := tx.QueryRow(ctx, `
+			INSERT INTO roles (name, description, builtin, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+			RETURNING id
+		`, role.Name, role.Description, role.Builtin, now).Scan(&role.ID)
+		if err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+		role.CreatedAt = now
+		role.UpdatedAt = now
+
+		return setRolePermissions(ctx, tx, role)
+	})
+}
+
+// GetByID retrieves a role by its ID
+func (r *RoleRepository) GetByID(ctx context.Context, id int64) (*models.RoleDefinition, error) {
+	role, err := scanRole(r.db.QueryRow(ctx, roleQuery+` WHERE r.id = $1 GROUP BY r.id`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("role not found: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+// GetByName retrieves a role by its name
+func (r *RoleRepository) GetByName(ctx context.Context, name string) (*models.RoleDefinition, error) {
+	role, err := scanRole(r.db.QueryRow(ctx, roleQuery+` WHERE r.name = $1 GROUP BY r.id`, name))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("role not found: %s", name)
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+// List retrieves all roles, built-in roles first
+func (r *RoleRepository) List(ctx context.Context) ([]*models.RoleDefinition, error) {
+	rows, err := r.db.Query(ctx, roleQuery+` GROUP BY r.id ORDER BY r.builtin DESC, r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*models.RoleDefinition
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role row: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role rows: %w", err)
+	}
+
+	return roles, nil
+}
+
+// Update changes a role's name, description and permissions. Users holding the
+// role by name follow a rename.
+func (r *RoleRepository) Update(ctx context.Context, role *models.RoleDefinition) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		now := time.Now()
+		result, err := tx.Exec(ctx, `
+			UPDATE roles SET name = $1, description = $2, updated_at = $3
+			WHERE id = $4
+		`, role.Name, role.Description, now, role.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update role: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("role not found: %d", role.ID)
+		}
+		role.UpdatedAt = now
+
+		if _, err := tx.Exec(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, role.ID); err != nil {
+			return fmt.Errorf("failed to clear role permissions: %w", err)
+		}
+		return setRolePermissions(ctx, tx, role)
+	})
+}
+
+// setRolePermissions inserts the permissions of a role
+func setRolePermissions(ctx context.Context, q querier, role *models.RoleDefinition) error {
+	for _, permission := range role.Permissions {
+		_, err := q.Exec(ctx, `
+			INSERT INTO role_permissions (role_id, permission) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, role.ID, permission)
+		if err != nil {
+			return fmt.Errorf("failed to add permission %s to role: %w", permission, err)
+		}
+	}
+	return nil
+}
+
+// Delete deletes a role. It fails while the role is still held by a user.
+func (r *RoleRepository) Delete(ctx context.Context, id int64) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM roles WHERE id = $1`, id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return repository.ErrRoleInUse
+		}
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	return nil
+}
+
+// scanRole reads a single role row
+func scanRole(row pgx.Row) (*models.RoleDefinition, error) {
+	role := &models.RoleDefinition{}
+	var permissions []string
+
+	err := row.Scan(
+		&role.ID, &role.Name, &role.Description, &role.Builtin, &role.CreatedAt, &role.UpdatedAt,
+		&permissions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = make([]models.Permission, len(permissions))
+	for i, permission := range permissions {
+		role.Permissions[i] = models.Permission(permission)
+	}
+
+	return role, nil
+}
+
+// CreateAssignment gives a user a role, everywhere or for one template
+func (r *RoleRepository) CreateAssignment(ctx context.Context, assignment *models.RoleAssignment) error {
+	query := `
+		INSERT INTO role_assignments (user_id, role_id, template_id, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	now := time.Now()
+	assignment.CreatedAt = now
+
+	err := r.db.QueryRow(ctx, query,
+		assignment.UserID, assignment.RoleID, assignment.TemplateID, assignment.CreatedBy, now,
+	).Scan(&assignment.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to create role assignment: %w", err)
+	}
+
+	return nil
+}
+
+// GetAssignment retrieves a role assignment by its ID
+func (r *RoleRepository) GetAssignment(ctx context.Context, id int64) (*models.RoleAssignment, error) {
+	query := `
+		SELECT ` + assignmentColumns + `
+		FROM role_assignments a
+		JOIN roles r ON r.id = a.role_id
+		WHERE a.id = $1
+	`
+
+	assignment, err := scanAssignment(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("role assignment not found: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get role assignment: %w", err)
+	}
+
+	return assignment, nil
+}
+
+// ListAssignments retrieves the roles assigned to a user, global ones first
+func (r *RoleRepository) ListAssignments(ctx context.Context, userID int64) ([]*models.RoleAssignment, error) {
+	query := `
+		SELECT ` + assignmentColumns + `
+		FROM role_assignments a
+		JOIN roles r ON r.id = a.role_id
+		WHERE a.user_id = $1
+		ORDER BY a.template_id NULLS FIRST, r.name
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role assignments: %w", err)
+	}
+	defer rows.Close()
+
+	var assignments []*models.RoleAssignment
+	for rows.Next() {
+		assignment, err := scanAssignment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role assignment row: %w", err)
+		}
+		assignments = append(assignments, assignment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role assignment rows: %w", err)
+	}
+
+	return assignments, nil
+}
+
+// DeleteAssignment removes a role assignment
+func (r *RoleRepository) DeleteAssignment(ctx context.Context, id int64) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM role_assignments WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete role assignment: %w", err)
+	}
+	return nil
+}
+
+// scanAssignment reads a single role assignment row
+func scanAssignment(row pgx.Row) (*models.RoleAssignment, error) {
+	assignment := &models.RoleAssignment{}
+	err := row.Scan(
+		&assignment.ID, &assignment.UserID, &assignment.RoleID, &assignment.Role,
+		&assignment.TemplateID, &assignment.CreatedBy, &assignment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// GetUserPermissions retrieves what an active user may do: the permissions of
+// their account's role and of their global assignments, and those of their
+// per-template assignments. An inactive or unknown user has none.
+func (r *RoleRepository) GetUserPermissions(ctx context.Context, userID int64) (*models.UserPermissions, error) {
+	query := `
+		SELECT p.permission, NULL::INTEGER
+		FROM users u
+		JOIN roles r ON r.name = u.role
+		JOIN role_permissions p ON p.role_id = r.id
+		WHERE u.id = $1 AND u.is_active
+		UNION
+		SELECT p.permission, a.template_id
+		FROM role_assignments a
+		JOIN users u ON u.id = a.user_id
+		JOIN role_permissions p ON p.role_id = a.role_id
+		WHERE a.user_id = $1 AND u.is_active
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := &models.UserPermissions{
+		Global:    make(map[models.Permission]bool),
+		Templates: make(map[int64]map[models.Permission]bool),
+	}
+	for rows.Next() {
+		var permission models.Permission
+		var templateID *int64
+		if err := rows.Scan(&permission, &templateID); err != nil {
+			return nil, fmt.Errorf("failed to scan permission row: %w", err)
+		}
+
+		if templateID == nil {
+			permissions.Global[permission] = true
+			continue
+		}
+		if permissions.Templates[*templateID] == nil {
+			permissions.Templates[*templateID] = make(map[models.Permission]bool)
+		}
+		permissions.Templates[*templateID][permission] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating permission rows: %w", err)
+	}
+
+	return permissions, nil
+}
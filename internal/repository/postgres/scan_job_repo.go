@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// ScanJobRepository implements the repository.ScanJobRepository interface
+type ScanJobRepository struct {
+	db *DB
+}
+
+// NewScanJobRepository creates a new ScanJobRepository
+func NewScanJobRepository(db *DB) repository.ScanJobRepository {
+	return &ScanJobRepository{db: db}
+}
+
+// scanJobColumns lists the scan job columns in the order scanScanJob reads them;
+// the result document is left out of listings, where it would be dead weight
+const (
+	scanJobColumns = `id, template_id, status, options, total, checked, existing, result,
+		error, created_by, created_by_id, created_at, started_at, completed_at`
+	scanJobSummaryColumns = `id, template_id, status, options, total, checked, existing, NULL,
+		error, created_by, created_by_id, created_at, started_at, completed_at`
+)
+
+// Create inserts a new scan job
+func (r *ScanJobRepository) Create(ctx context.Context, job *models.ScanJob) error {
+	query := `
+		INSERT INTO scan_jobs (
+			template_id, status, options, total, checked, existing, result,
+			error, created_by, created_by_id, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		) RETURNING id
+	`
+
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+
+	err := r.db.QueryRow(ctx, query,
+		job.TemplateID, job.Status, string(job.Options), job.Total, job.Checked, job.Existing,
+		nullJSON(job.Result), nullString(job.Error), job.CreatedBy, nullID(job.CreatedByID), job.CreatedAt,
+	).Scan(&job.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to create scan job: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves a scan job, including its result, by ID
+func (r *ScanJobRepository) GetByID(ctx context.Context, id int64) (*models.ScanJob, error) {
+	query := `SELECT ` + scanJobColumns + ` FROM scan_jobs WHERE id = $1`
+
+	job, err := scanScanJob(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("scan job not found: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get scan job: %w", err)
+	}
+
+	return job, nil
+}
+
+// List retrieves scan jobs without their results, newest first. A templateID of 0
+// lists the jobs of every template.
+func (r *ScanJobRepository) List(ctx context.Context, templateID int64, limit, offset int) ([]*models.ScanJob, int, error) {
+	whereClause := ""
+	args := []interface{}{}
+	if templateID > 0 {
+		args = append(args, templateID)
+		whereClause = " AND template_id = $1"
+	}
+
+	// Get total count first
+	var total int
+	countQuery := `SELECT COUNT(*) FROM scan_jobs WHERE 1=1` + whereClause
+	if err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count scan jobs: %w", err)
+	}
+
+	// Query jobs
+	query := `SELECT ` + scanJobSummaryColumns + ` FROM scan_jobs WHERE 1=1` + whereClause +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query scan jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*models.ScanJob
+	for rows.Next() {
+		job, err := scanScanJob(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan scan job row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating scan job rows: %w", err)
+	}
+
+	return jobs, total, nil
+}
+
+// Update saves the status, progress and result of a scan job
+func (r *ScanJobRepository) Update(ctx context.Context, job *models.ScanJob) error {
+	query := `
+		UPDATE scan_jobs
+		SET status = $1, total = $2, checked = $3, existing = $4, result = $5,
+			error = $6, started_at = $7, completed_at = $8
+		WHERE id = $9
+	`
+
+	result, err := r.db.Exec(ctx, query,
+		job.Status, job.Total, job.Checked, job.Existing, nullJSON(job.Result),
+		nullString(job.Error), job.StartedAt, job.CompletedAt, job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update scan job: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("scan job not found: %d", job.ID)
+	}
+
+	return nil
+}
+
+// FailUnfinished marks every pending or running job as failed with message,
+// returning how many were marked
+func (r *ScanJobRepository) FailUnfinished(ctx context.Context, message string) (int, error) {
+	query := `
+		UPDATE scan_jobs
+		SET status = $1, error = $2, completed_at = $3
+		WHERE status IN ($4, $5)
+	`
+
+	result, err := r.db.Exec(ctx, query,
+		models.ScanJobFailed, message, time.Now(), models.ScanJobPending, models.ScanJobRunning,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail unfinished scan jobs: %w", err)
+	}
+
+	return int(result.RowsAffected()), nil
+}
+
+// scanScanJob reads a single scan job row
+func scanScanJob(row pgx.Row) (*models.ScanJob, error) {
+	var job models.ScanJob
+	var options, result []byte
+	var jobError sql.NullString
+	var createdByID sql.NullInt64
+	var startedAt, completedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID, &job.TemplateID, &job.Status, &options, &job.Total, &job.Checked, &job.Existing, &result,
+		&jobError, &job.CreatedBy, &createdByID, &job.CreatedAt, &startedAt, &completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Options = options
+	job.Result = result
+	job.Error = jobError.String
+	job.CreatedByID = createdByID.Int64
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+
+	return &job, nil
+}
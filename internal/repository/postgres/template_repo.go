@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -11,6 +12,13 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
+// templateColumns lists the template columns in the order scanTemplate expects
+const templateColumns = `id, name, description, max_length, sequence_start, sequence_length,
+			sequence_padding, sequence_increment, sequence_position, sequence_scope,
+			sequence_scope_groups, allocation_strategy, release_cooldown, version,
+			strict_validation, reservation_ttl, created_by, created_at, updated_at, is_active,
+			dns_check_on_reserve`
+
 // TemplateRepository implements the repository.TemplateRepository interface
 type TemplateRepository struct {
 	db *DB
@@ -21,54 +29,84 @@ func NewTemplateRepository(db *DB) repository.TemplateRepository {
 	return &TemplateRepository{db: db}
 }
 
-// Create adds a new template to the database
+// Create adds a new template to the database in a single transaction: the
+// template row, its groups from template.Groups and a snapshot of the result as
+// its first version are written together, so a template never exists without
+// its initial version
 func (r *TemplateRepository) Create(ctx context.Context, template *models.Template) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		if err := insertTemplate(ctx, tx, template); err != nil {
+			return fmt.Errorf("failed to create template: %w", err)
+		}
+
+		for i := range template.Groups {
+			group := &template.Groups[i]
+			group.TemplateID = template.ID
+			if err := insertTemplateGroup(ctx, tx, group); err != nil {
+				return fmt.Errorf("failed to create template group: %w", err)
+			}
+		}
+
+		version := &models.TemplateVersion{
+			TemplateID: template.ID,
+			Version:    template.Version,
+			Definition: *template,
+			CreatedBy:  template.CreatedBy,
+		}
+		if err := insertTemplateVersion(ctx, tx, version); err != nil {
+			return fmt.Errorf("failed to create template version: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// insertTemplate inserts a template row using the given querier
+func insertTemplate(ctx context.Context, q querier, template *models.Template) error {
 	query := `
 		INSERT INTO templates (
 			name, description, max_length, sequence_start, sequence_length,
-			sequence_padding, sequence_increment, sequence_position,
-			created_by, created_at, updated_at, is_active
+			sequence_padding, sequence_increment, sequence_position, sequence_scope,
+			sequence_scope_groups, allocation_strategy, release_cooldown, version,
+			strict_validation, reservation_ttl, created_by, created_at, updated_at, is_active,
+			dns_check_on_reserve
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10, $11
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $17, $18, $19
 		) RETURNING id
 	`
 
 	now := time.Now()
 	template.CreatedAt = now
 	template.UpdatedAt = now
+	if template.Version <= 0 {
+		template.Version = 1
+	}
+	if template.SequenceScope == "" {
+		template.SequenceScope = models.SequenceScopeGlobal
+	}
+	if template.AllocationStrategy == "" {
+		template.AllocationStrategy = models.AllocationNextHighest
+	}
 
-	err := r.db.QueryRow(ctx, query,
+	return q.QueryRow(ctx, query,
 		template.Name, template.Description, template.MaxLength,
 		template.SequenceStart, template.SequenceLength, template.SequencePadding,
-		template.SequenceIncrement, template.SequencePosition, template.CreatedBy,
-		now, template.IsActive,
+		template.SequenceIncrement, template.SequencePosition, template.SequenceScope,
+		scopeGroups(template), template.AllocationStrategy, template.ReleaseCooldown,
+		template.Version, template.StrictValidation, template.ReservationTTL,
+		template.CreatedBy, now, template.IsActive, template.DNSCheckOnReserve,
 	).Scan(&template.ID)
-
-	if err != nil {
-		return fmt.Errorf("failed to create template: %w", err)
-	}
-
-	return nil
 }
 
 // GetByID retrieves a template by its ID
 func (r *TemplateRepository) GetByID(ctx context.Context, id int64) (*models.Template, error) {
 	query := `
-		SELECT id, name, description, max_length, sequence_start, sequence_length,
-			sequence_padding, sequence_increment, sequence_position,
-			created_by, created_at, updated_at, is_active
+		SELECT ` + templateColumns + `
 		FROM templates
 		WHERE id = $1
 	`
 
-	template := &models.Template{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&template.ID, &template.Name, &template.Description, &template.MaxLength,
-		&template.SequenceStart, &template.SequenceLength, &template.SequencePadding,
-		&template.SequenceIncrement, &template.SequencePosition, &template.CreatedBy,
-		&template.CreatedAt, &template.UpdatedAt, &template.IsActive,
-	)
-
+	template, err := scanTemplate(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("template not found: %d", id)
@@ -89,21 +127,12 @@ func (r *TemplateRepository) GetByID(ctx context.Context, id int64) (*models.Tem
 // GetByName retrieves a template by its name
 func (r *TemplateRepository) GetByName(ctx context.Context, name string) (*models.Template, error) {
 	query := `
-		SELECT id, name, description, max_length, sequence_start, sequence_length,
-			sequence_padding, sequence_increment, sequence_position,
-			created_by, created_at, updated_at, is_active
+		SELECT ` + templateColumns + `
 		FROM templates
 		WHERE name = $1
 	`
 
-	template := &models.Template{}
-	err := r.db.QueryRow(ctx, query, name).Scan(
-		&template.ID, &template.Name, &template.Description, &template.MaxLength,
-		&template.SequenceStart, &template.SequenceLength, &template.SequencePadding,
-		&template.SequenceIncrement, &template.SequencePosition, &template.CreatedBy,
-		&template.CreatedAt, &template.UpdatedAt, &template.IsActive,
-	)
-
+	template, err := scanTemplate(r.db.QueryRow(ctx, query, name))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("template not found: %s", name)
@@ -133,9 +162,7 @@ func (r *TemplateRepository) List(ctx context.Context, limit, offset int) ([]*mo
 
 	// Get templates with pagination
 	query := `
-		SELECT id, name, description, max_length, sequence_start, sequence_length,
-			sequence_padding, sequence_increment, sequence_position,
-			created_by, created_at, updated_at, is_active
+		SELECT ` + templateColumns + `
 		FROM templates
 		ORDER BY name ASC
 		LIMIT $1 OFFSET $2
@@ -149,13 +176,8 @@ func (r *TemplateRepository) List(ctx context.Context, limit, offset int) ([]*mo
 
 	var templates []*models.Template
 	for rows.Next() {
-		template := &models.Template{}
-		if err := rows.Scan(
-			&template.ID, &template.Name, &template.Description, &template.MaxLength,
-			&template.SequenceStart, &template.SequenceLength, &template.SequencePadding,
-			&template.SequenceIncrement, &template.SequencePosition, &template.CreatedBy,
-			&template.CreatedAt, &template.UpdatedAt, &template.IsActive,
-		); err != nil {
+		template, err := scanTemplate(rows)
+		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan template row: %w", err)
 		}
 		templates = append(templates, template)
@@ -177,28 +199,204 @@ func (r *TemplateRepository) List(ctx context.Context, limit, offset int) ([]*mo
 	return templates, total, nil
 }
 
+// scanTemplate scans a row selected with templateColumns
+func scanTemplate(row pgx.Row) (*models.Template, error) {
+	template := &models.Template{}
+	if err := row.Scan(
+		&template.ID, &template.Name, &template.Description, &template.MaxLength,
+		&template.SequenceStart, &template.SequenceLength, &template.SequencePadding,
+		&template.SequenceIncrement, &template.SequencePosition, &template.SequenceScope,
+		&template.SequenceScopeGroups, &template.AllocationStrategy, &template.ReleaseCooldown,
+		&template.Version, &template.StrictValidation,
+		&template.ReservationTTL, &template.CreatedBy, &template.CreatedAt,
+		&template.UpdatedAt, &template.IsActive, &template.DNSCheckOnReserve,
+	); err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// scopeGroups returns the template's scope groups, never nil, for the NOT NULL array column
+func scopeGroups(template *models.Template) []string {
+	if template.SequenceScopeGroups == nil {
+		return []string{}
+	}
+	return template.SequenceScopeGroups
+}
+
+// ListActive retrieves all active templates with their groups
+func (r *TemplateRepository) ListActive(ctx context.Context) ([]*models.Template, error) {
+	query := `
+		SELECT ` + templateColumns + `
+		FROM templates
+		WHERE is_active = TRUE
+		ORDER BY name ASC
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []*models.Template
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template row: %w", err)
+		}
+		templates = append(templates, template)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating template rows: %w", err)
+	}
+
+	// Get groups for each template
+	for _, template := range templates {
+		groups, err := r.GetTemplateGroups(ctx, template.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template groups: %w", err)
+		}
+		template.Groups = groups
+	}
+
+	return templates, nil
+}
+
 // Update updates an existing template
 func (r *TemplateRepository) Update(ctx context.Context, template *models.Template) error {
+	if err := updateTemplate(ctx, r.db, template); err != nil {
+		return fmt.Errorf("failed to update template: %w", err)
+	}
+
+	return nil
+}
+
+// updateTemplate writes the template row using the given querier
+func updateTemplate(ctx context.Context, q querier, template *models.Template) error {
 	query := `
 		UPDATE templates
 		SET name = $1, description = $2, max_length = $3, sequence_start = $4,
 			sequence_length = $5, sequence_padding = $6, sequence_increment = $7,
-			sequence_position = $8, updated_at = $9, is_active = $10
-		WHERE id = $11
+			sequence_position = $8, version = $9, strict_validation = $10,
+			reservation_ttl = $11, updated_at = $12, is_active = $13,
+			sequence_scope = $15, sequence_scope_groups = $16,
+			allocation_strategy = $17, release_cooldown = $18, dns_check_on_reserve = $19
+		WHERE id = $14
 	`
 
 	now := time.Now()
 	template.UpdatedAt = now
 
-	_, err := r.db.Exec(ctx, query,
+	res, err := q.Exec(ctx, query,
 		template.Name, template.Description, template.MaxLength,
 		template.SequenceStart, template.SequenceLength, template.SequencePadding,
-		template.SequenceIncrement, template.SequencePosition, now, template.IsActive,
-		template.ID,
+		template.SequenceIncrement, template.SequencePosition, template.Version,
+		template.StrictValidation, template.ReservationTTL, now, template.IsActive, template.ID,
+		template.SequenceScope, scopeGroups(template),
+		template.AllocationStrategy, template.ReleaseCooldown, template.DNSCheckOnReserve,
 	)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("template not found: %d", template.ID)
+	}
+
+	return nil
+}
+
+// SaveVersion stores a new version of a template in a single transaction: the
+// template row is updated, its groups are replaced by template.Groups and a
+// snapshot of the result is appended to template_versions. The caller is
+// responsible for setting template.Version to the new version number. When
+// scopeKey is set, the template's hostnames are given the sequence scope key it
+// returns for each, so that a changed scope keeps counting the existing names.
+func (r *TemplateRepository) SaveVersion(ctx context.Context, template *models.Template, createdBy string, scopeKey func(hostname *models.Hostname) (string, error)) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		// Make sure the version we are replacing is still the current one
+		var current int
+		err := tx.QueryRow(ctx, `SELECT version FROM templates WHERE id = $1 FOR UPDATE`, template.ID).Scan(&current)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("template not found: %d", template.ID)
+			}
+			return fmt.Errorf("failed to lock template: %w", err)
+		}
+		if current != template.Version-1 {
+			return fmt.Errorf("template %d was modified concurrently (current version %d)", template.ID, current)
+		}
+
+		if err := updateTemplate(ctx, tx, template); err != nil {
+			return fmt.Errorf("failed to update template: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM template_groups WHERE template_id = $1`, template.ID); err != nil {
+			return fmt.Errorf("failed to delete template groups: %w", err)
+		}
+
+		for i := range template.Groups {
+			group := &template.Groups[i]
+			group.TemplateID = template.ID
+			if err := insertTemplateGroup(ctx, tx, group); err != nil {
+				return fmt.Errorf("failed to create template group: %w", err)
+			}
+		}
+
+		version := &models.TemplateVersion{
+			TemplateID: template.ID,
+			Version:    template.Version,
+			Definition: *template,
+			CreatedBy:  createdBy,
+		}
+		if err := insertTemplateVersion(ctx, tx, version); err != nil {
+			return fmt.Errorf("failed to create template version: %w", err)
+		}
 
+		if scopeKey != nil {
+			return rekeyHostnames(ctx, tx, template.ID, scopeKey)
+		}
+		return nil
+	})
+}
+
+// rekeyHostnames stores the sequence scope key scopeKey returns for each hostname
+// of a template. The caller holds the template's row lock, so no reservation
+// adds hostnames meanwhile.
+func rekeyHostnames(ctx context.Context, tx pgx.Tx, templateID int64, scopeKey func(hostname *models.Hostname) (string, error)) error {
+	rows, err := tx.Query(ctx, `SELECT `+hostnameColumns+` FROM hostnames WHERE template_id = $1 FOR UPDATE`, templateID)
 	if err != nil {
-		return fmt.Errorf("failed to update template: %w", err)
+		return fmt.Errorf("failed to query hostnames: %w", err)
+	}
+
+	var hostnames []*models.Hostname
+	for rows.Next() {
+		hostname, err := scanHostname(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan hostname row: %w", err)
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating hostname rows: %w", err)
+	}
+
+	for _, hostname := range hostnames {
+		key, err := scopeKey(hostname)
+		if err != nil {
+			return err
+		}
+		if key == hostname.SequenceScope {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE hostnames SET sequence_scope_key = $2 WHERE id = $1`, hostname.ID, key); err != nil {
+			return fmt.Errorf("failed to update sequence scope of hostname %s: %w", hostname.Name, err)
+		}
 	}
 
 	return nil
@@ -250,6 +448,15 @@ func (r *TemplateRepository) GetTemplateGroups(ctx context.Context, templateID i
 
 // CreateTemplateGroup creates a new template group
 func (r *TemplateRepository) CreateTemplateGroup(ctx context.Context, group *models.TemplateGroup) error {
+	if err := insertTemplateGroup(ctx, r.db, group); err != nil {
+		return fmt.Errorf("failed to create template group: %w", err)
+	}
+
+	return nil
+}
+
+// insertTemplateGroup inserts a template group using the given querier
+func insertTemplateGroup(ctx context.Context, q querier, group *models.TemplateGroup) error {
 	query := `
 		INSERT INTO template_groups (
 			template_id, name, length, position, is_required, validation_type, validation_value
@@ -258,16 +465,10 @@ func (r *TemplateRepository) CreateTemplateGroup(ctx context.Context, group *mod
 		) RETURNING id
 	`
 
-	err := r.db.QueryRow(ctx, query,
+	return q.QueryRow(ctx, query,
 		group.TemplateID, group.Name, group.Length, group.Position,
 		group.IsRequired, group.ValidationType, group.ValidationValue,
 	).Scan(&group.ID)
-
-	if err != nil {
-		return fmt.Errorf("failed to create template group: %w", err)
-	}
-
-	return nil
 }
 
 // UpdateTemplateGroup updates an existing template group
@@ -300,3 +501,91 @@ func (r *TemplateRepository) DeleteTemplateGroup(ctx context.Context, id int64)
 	}
 	return nil
 }
+
+// insertTemplateVersion inserts a template version snapshot using the given querier
+func insertTemplateVersion(ctx context.Context, q querier, version *models.TemplateVersion) error {
+	query := `
+		INSERT INTO template_versions (
+			template_id, version, definition, created_by, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5
+		) RETURNING id
+	`
+
+	definition, err := json.Marshal(version.Definition)
+	if err != nil {
+		return fmt.Errorf("failed to encode template definition: %w", err)
+	}
+
+	version.CreatedAt = time.Now()
+
+	return q.QueryRow(ctx, query,
+		version.TemplateID, version.Version, definition, version.CreatedBy, version.CreatedAt,
+	).Scan(&version.ID)
+}
+
+// GetVersion retrieves a specific version of a template
+func (r *TemplateRepository) GetVersion(ctx context.Context, templateID int64, version int) (*models.TemplateVersion, error) {
+	query := `
+		SELECT id, template_id, version, definition, created_by, created_at
+		FROM template_versions
+		WHERE template_id = $1 AND version = $2
+	`
+
+	tv, err := scanTemplateVersion(r.db.QueryRow(ctx, query, templateID, version))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("template version not found: %d/%d", templateID, version)
+		}
+		return nil, fmt.Errorf("failed to get template version: %w", err)
+	}
+
+	return tv, nil
+}
+
+// ListVersions retrieves all versions of a template, newest first
+func (r *TemplateRepository) ListVersions(ctx context.Context, templateID int64) ([]*models.TemplateVersion, error) {
+	query := `
+		SELECT id, template_id, version, definition, created_by, created_at
+		FROM template_versions
+		WHERE template_id = $1
+		ORDER BY version DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, templateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.TemplateVersion
+	for rows.Next() {
+		tv, err := scanTemplateVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template version row: %w", err)
+		}
+		versions = append(versions, tv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating template version rows: %w", err)
+	}
+
+	return versions, nil
+}
+
+// scanTemplateVersion scans a template version row and decodes its definition
+func scanTemplateVersion(row pgx.Row) (*models.TemplateVersion, error) {
+	tv := &models.TemplateVersion{}
+	var definition []byte
+
+	if err := row.Scan(&tv.ID, &tv.TemplateID, &tv.Version, &definition, &tv.CreatedBy, &tv.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(definition, &tv.Definition); err != nil {
+		return nil, fmt.Errorf("failed to decode template definition: %w", err)
+	}
+
+	return tv, nil
+}
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+func TestSaveVersionRekeysHostnamesForNewScope(t *testing.T) {
+	db := openTestDB(t)
+	template := createTestTemplate(t, db)
+	hostRepo := NewHostnameRepository(db)
+	templateRepo := NewTemplateRepository(db)
+	ctx := context.Background()
+	alloc := models.SequenceAllocation{Strategy: models.AllocationNextHighest, Start: 1, Increment: 1}
+
+	// Six hostnames numbered template-wide, alternating between sites A and B
+	prefix := fmt.Sprintf("T%d", template.ID)
+	for i := 0; i < 6; i++ {
+		site := "AB"[i%2 : i%2+1]
+		hostname := &models.Hostname{TemplateID: template.ID, Status: models.StatusReserved, ReservedBy: "test", ReservedAt: time.Now()}
+		err := hostRepo.ReserveNextSequence(ctx, hostname, alloc, func(seq int) (string, error) {
+			return fmt.Sprintf("%s%s-%04d", prefix, site, seq), nil
+		})
+		if err != nil {
+			t.Fatalf("reservation failed: %v", err)
+		}
+	}
+
+	// Number each site separately from now on
+	updated, err := templateRepo.GetByID(ctx, template.ID)
+	if err != nil {
+		t.Fatalf("failed to get template: %v", err)
+	}
+	updated.SequenceScope = models.SequenceScopePrefix
+	updated.Version++
+	err = templateRepo.SaveVersion(ctx, updated, "test", func(hostname *models.Hostname) (string, error) {
+		return "prefix:site=" + strings.TrimPrefix(hostname.Name, prefix)[:1], nil
+	})
+	if err != nil {
+		t.Fatalf("SaveVersion failed: %v", err)
+	}
+
+	siteA, err := hostRepo.GetBySequenceScope(ctx, template.ID, "prefix:site=A")
+	if err != nil {
+		t.Fatalf("failed to get site A hostnames: %v", err)
+	}
+	if len(siteA) != 3 {
+		t.Fatalf("expected site A's 3 existing hostnames in its scope, got %d", len(siteA))
+	}
+
+	// Site A's counter carries on after its highest existing number, 5
+	hostname := &models.Hostname{
+		TemplateID:      template.ID,
+		TemplateVersion: updated.Version,
+		Status:          models.StatusReserved,
+		SequenceScope:   "prefix:site=A",
+		ReservedBy:      "test",
+		ReservedAt:      time.Now(),
+	}
+	err = hostRepo.ReserveNextSequence(ctx, hostname, alloc, func(seq int) (string, error) {
+		return fmt.Sprintf("%sA-%04d", prefix, seq), nil
+	})
+	if err != nil {
+		t.Fatalf("reservation after the scope change failed: %v", err)
+	}
+	if hostname.SequenceNum != 6 {
+		t.Fatalf("expected site A to continue at 6, got %d", hostname.SequenceNum)
+	}
+}
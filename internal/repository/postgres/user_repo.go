@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -21,14 +23,18 @@ func NewUserRepository(db *DB) repository.UserRepository {
 	return &UserRepository{db: db}
 }
 
+// userColumns lists the user columns in the order scanUser reads them
+const userColumns = `id, username, email, password_hash, first_name, last_name,
+	role, is_active, last_login, oidc_issuer, oidc_subject, created_at, updated_at`
+
 // Create adds a new user to the database
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	query := `
 		INSERT INTO users (
 			username, email, password_hash, first_name, last_name,
-			role, is_active, created_at, updated_at
+			role, is_active, oidc_issuer, oidc_subject, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10
 		) RETURNING id
 	`
 
@@ -38,7 +44,8 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 
 	err := r.db.QueryRow(ctx, query,
 		user.Username, user.Email, user.PasswordHash, user.FirstName,
-		user.LastName, user.Role, user.IsActive, now,
+		user.LastName, user.Role, user.IsActive,
+		nullString(user.OIDCIssuer), nullString(user.OIDCSubject), now,
 	).Scan(&user.ID)
 
 	if err != nil {
@@ -48,25 +55,34 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	return nil
 }
 
-// GetByID retrieves a user by their ID
-func (r *UserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, first_name, last_name,
-			role, is_active, last_login, created_at, updated_at
-		FROM users
-		WHERE id = $1
-	`
-
+// scanUser reads a single user row
+func scanUser(row pgx.Row) (*models.User, error) {
 	user := &models.User{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	var oidcIssuer, oidcSubject sql.NullString
+
+	err := row.Scan(
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
 		&user.FirstName, &user.LastName, &user.Role, &user.IsActive,
-		&user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastLogin, &oidcIssuer, &oidcSubject, &user.CreatedAt, &user.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.OIDCIssuer = oidcIssuer.String
+	user.OIDCSubject = oidcSubject.String
+
+	return user, nil
+}
 
+// GetByID retrieves a user by their ID
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	user, err := scanUser(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found: %d", id)
+			return nil, fmt.Errorf("%w: %d", repository.ErrUserNotFound, id)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -76,23 +92,12 @@ func (r *UserRepository) GetByID(ctx context.Context, id int64) (*models.User, e
 
 // GetByUsername retrieves a user by their username
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, first_name, last_name,
-			role, is_active, last_login, created_at, updated_at
-		FROM users
-		WHERE username = $1
-	`
-
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.FirstName, &user.LastName, &user.Role, &user.IsActive,
-		&user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
 
+	user, err := scanUser(r.db.QueryRow(ctx, query, username))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found: %s", username)
+			return nil, fmt.Errorf("%w: %s", repository.ErrUserNotFound, username)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -102,23 +107,27 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*m
 
 // GetByEmail retrieves a user by their email
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, first_name, last_name,
-			role, is_active, last_login, created_at, updated_at
-		FROM users
-		WHERE email = $1
-	`
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
 
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.FirstName, &user.LastName, &user.Role, &user.IsActive,
-		&user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRow(ctx, query, email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", repository.ErrUserNotFound, email)
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetByOIDCSubject retrieves the user linked to a subject of an OIDC provider
+func (r *UserRepository) GetByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2`
 
+	user, err := scanUser(r.db.QueryRow(ctx, query, issuer, subject))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found: %s", email)
+			return nil, fmt.Errorf("%w: %s at %s", repository.ErrUserNotFound, subject, issuer)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -138,8 +147,7 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*models
 
 	// Get users with pagination
 	query := `
-		SELECT id, username, email, password_hash, first_name, last_name,
-			role, is_active, last_login, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		ORDER BY username ASC
 		LIMIT $1 OFFSET $2
@@ -153,12 +161,8 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*models
 
 	var users []*models.User
 	for rows.Next() {
-		user := &models.User{}
-		if err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-			&user.FirstName, &user.LastName, &user.Role, &user.IsActive,
-			&user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-		); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan user row: %w", err)
 		}
 		users = append(users, user)
@@ -171,13 +175,13 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*models
 	return users, total, nil
 }
 
-// Update updates an existing user
+// Update updates an existing user, including the OIDC subject it is linked to
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 	query := `
 		UPDATE users
 		SET email = $1, password_hash = $2, first_name = $3, last_name = $4,
-			role = $5, is_active = $6, updated_at = $7
-		WHERE id = $8
+			role = $5, is_active = $6, oidc_issuer = $7, oidc_subject = $8, updated_at = $9
+		WHERE id = $10
 	`
 
 	now := time.Now()
@@ -185,7 +189,8 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 
 	_, err := r.db.Exec(ctx, query,
 		user.Email, user.PasswordHash, user.FirstName, user.LastName,
-		user.Role, user.IsActive, now, user.ID,
+		user.Role, user.IsActive, nullString(user.OIDCIssuer), nullString(user.OIDCSubject),
+		now, user.ID,
 	)
 
 	if err != nil {
@@ -216,22 +221,38 @@ func (r *UserRepository) UpdateLastLogin(ctx context.Context, id int64) error {
 	return nil
 }
 
+// apiKeyColumns lists the API key columns in the order scanAPIKey reads them
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scope, grants, last_used, expires_at, rotated_to, rotated_at, created_at`
+
 // CreateAPIKey creates a new API key for a user
 func (r *UserRepository) CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error {
+	return createAPIKey(ctx, r.db, apiKey)
+}
+
+// createAPIKey inserts an API key, setting its ID
+func createAPIKey(ctx context.Context, q querier, apiKey *models.APIKey) error {
 	query := `
 		INSERT INTO api_keys (
-			user_id, name, key, scope, expires_at, created_at
+			user_id, name, prefix, key_hash, scope, grants, expires_at, created_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6
+			$1, $2, $3, $4, $5, $6, $7, $8
 		) RETURNING id
 	`
 
+	var grants []byte
+	if len(apiKey.Grants) > 0 {
+		var err error
+		if grants, err = json.Marshal(apiKey.Grants); err != nil {
+			return fmt.Errorf("failed to encode API key grants: %w", err)
+		}
+	}
+
 	now := time.Now()
 	apiKey.CreatedAt = now
 
-	err := r.db.QueryRow(ctx, query,
-		apiKey.UserID, apiKey.Name, apiKey.Key, apiKey.Scope,
-		apiKey.ExpiresAt, now,
+	err := q.QueryRow(ctx, query,
+		apiKey.UserID, apiKey.Name, nullString(apiKey.Prefix), apiKey.KeyHash, apiKey.Scope,
+		nullJSON(grants), apiKey.ExpiresAt, now,
 	).Scan(&apiKey.ID)
 
 	if err != nil {
@@ -241,20 +262,37 @@ func (r *UserRepository) CreateAPIKey(ctx context.Context, apiKey *models.APIKey
 	return nil
 }
 
+// scanAPIKey reads a single API key row, followed by any extra columns into extra
+func scanAPIKey(row pgx.Row, extra ...interface{}) (*models.APIKey, error) {
+	apiKey := &models.APIKey{}
+	var prefix sql.NullString
+	var grants []byte
+
+	dest := []interface{}{
+		&apiKey.ID, &apiKey.UserID, &apiKey.Name, &prefix, &apiKey.KeyHash,
+		&apiKey.Scope, &grants, &apiKey.LastUsed, &apiKey.ExpiresAt, &apiKey.RotatedTo, &apiKey.RotatedAt,
+		&apiKey.CreatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	apiKey.Prefix = prefix.String
+	apiKey.Legacy = !prefix.Valid
+	if grants != nil {
+		if err := json.Unmarshal(grants, &apiKey.Grants); err != nil {
+			return nil, fmt.Errorf("failed to decode API key grants: %w", err)
+		}
+	}
+
+	return apiKey, nil
+}
+
 // GetAPIKeyByID retrieves an API key by its ID
 func (r *UserRepository) GetAPIKeyByID(ctx context.Context, id int64) (*models.APIKey, error) {
-	query := `
-		SELECT id, user_id, name, key, scope, last_used, expires_at, created_at
-		FROM api_keys
-		WHERE id = $1
-	`
-
-	apiKey := &models.APIKey{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Key,
-		&apiKey.Scope, &apiKey.LastUsed, &apiKey.ExpiresAt, &apiKey.CreatedAt,
-	)
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
 
+	apiKey, err := scanAPIKey(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("API key not found: %d", id)
@@ -265,20 +303,27 @@ func (r *UserRepository) GetAPIKeyByID(ctx context.Context, id int64) (*models.A
 	return apiKey, nil
 }
 
-// GetAPIKeyByKey retrieves an API key by its key value
-func (r *UserRepository) GetAPIKeyByKey(ctx context.Context, key string) (*models.APIKey, error) {
-	query := `
-		SELECT id, user_id, name, key, scope, last_used, expires_at, created_at
-		FROM api_keys
-		WHERE key = $1
-	`
+// GetAPIKeyByPrefix retrieves an API key by the public prefix of its key
+func (r *UserRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
 
-	apiKey := &models.APIKey{}
-	err := r.db.QueryRow(ctx, query, key).Scan(
-		&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Key,
-		&apiKey.Scope, &apiKey.LastUsed, &apiKey.ExpiresAt, &apiKey.CreatedAt,
-	)
+	apiKey, err := scanAPIKey(r.db.QueryRow(ctx, query, prefix))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its key. Only keys issued
+// before prefixes, which have none, need finding this way.
+func (r *UserRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
 
+	apiKey, err := scanAPIKey(r.db.QueryRow(ctx, query, keyHash))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("API key not found")
@@ -292,7 +337,7 @@ func (r *UserRepository) GetAPIKeyByKey(ctx context.Context, key string) (*model
 // ListAPIKeys retrieves all API keys for a user
 func (r *UserRepository) ListAPIKeys(ctx context.Context, userID int64) ([]*models.APIKey, error) {
 	query := `
-		SELECT id, user_id, name, key, scope, last_used, expires_at, created_at
+		SELECT ` + apiKeyColumns + `
 		FROM api_keys
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -306,11 +351,8 @@ func (r *UserRepository) ListAPIKeys(ctx context.Context, userID int64) ([]*mode
 
 	var apiKeys []*models.APIKey
 	for rows.Next() {
-		apiKey := &models.APIKey{}
-		if err := rows.Scan(
-			&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Key,
-			&apiKey.Scope, &apiKey.LastUsed, &apiKey.ExpiresAt, &apiKey.CreatedAt,
-		); err != nil {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan API key row: %w", err)
 		}
 		apiKeys = append(apiKeys, apiKey)
@@ -343,3 +385,69 @@ func (r *UserRepository) UpdateAPIKeyLastUsed(ctx context.Context, id int64) err
 	}
 	return nil
 }
+
+// RotateAPIKey creates successor in place of an API key that hasn't been rotated
+// yet, and cuts the old key's expiry to expiresAt unless it expires sooner
+func (r *UserRepository) RotateAPIKey(ctx context.Context, id int64, expiresAt time.Time, successor *models.APIKey) error {
+	return r.db.ExecTx(ctx, func(tx pgx.Tx) error {
+		// Retire the old key first, freeing its name for the successor
+		now := time.Now()
+		result, err := tx.Exec(ctx, `
+			UPDATE api_keys
+			SET rotated_at = $1, expires_at = LEAST(expires_at, $2)
+			WHERE id = $3 AND rotated_at IS NULL
+		`, now, expiresAt, id)
+		if err != nil {
+			return fmt.Errorf("failed to rotate API key: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("API key not found or already rotated")
+		}
+
+		if err := createAPIKey(ctx, tx, successor); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE api_keys SET rotated_to = $1 WHERE id = $2`, successor.ID, id); err != nil {
+			return fmt.Errorf("failed to link API key to its successor: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ListExpiringAPIKeys retrieves the API keys expiring between from and to, with
+// their owners, soonest first. Rotated keys are left out, as they have successors.
+func (r *UserRepository) ListExpiringAPIKeys(ctx context.Context, from, to time.Time) ([]*models.ExpiringAPIKey, error) {
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scope, k.grants, k.last_used, k.expires_at,
+			k.rotated_to, k.rotated_at, k.created_at, u.username, u.email
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.expires_at > $1 AND k.expires_at <= $2 AND k.rotated_at IS NULL
+		ORDER BY k.expires_at, k.id
+	`
+
+	rows, err := r.db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiring API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var apiKeys []*models.ExpiringAPIKey
+	for rows.Next() {
+		expiring := &models.ExpiringAPIKey{}
+		apiKey, err := scanAPIKey(rows, &expiring.Username, &expiring.Email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key row: %w", err)
+		}
+		expiring.APIKey = apiKey
+		apiKeys = append(apiKeys, expiring)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API key rows: %w", err)
+	}
+
+	return apiKeys, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/rs/zerolog/log"
+)
+
+// actorContextKey is the context key under which the acting principal is stored
+type actorContextKey struct{}
+
+// WithActor returns a copy of ctx carrying the principal performing the request
+func WithActor(ctx context.Context, actor models.Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the principal stored in ctx, if any
+func ActorFromContext(ctx context.Context) (models.Actor, bool) {
+	actor, ok := ctx.Value(actorContextKey{}).(models.Actor)
+	return actor, ok
+}
+
+// AuditService records an append-only history of changes
+type AuditService struct {
+	auditRepo repository.AuditRepository
+}
+
+// NewAuditService creates a new AuditService
+func NewAuditService(auditRepo repository.AuditRepository) *AuditService {
+	return &AuditService{
+		auditRepo: auditRepo,
+	}
+}
+
+// Record appends an audit event for the actor stored in ctx.
+// Failures are logged rather than returned so auditing never blocks the change itself.
+func (s *AuditService) Record(ctx context.Context, entityType models.AuditEntityType, entityID int64, action models.AuditAction, before, after interface{}) {
+	if s == nil {
+		return
+	}
+
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		actor = models.Actor{Username: "unknown", AuthMethod: "unknown"}
+	}
+
+	event := &models.AuditEvent{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Actor:      actor.Username,
+		AuthMethod: actor.AuthMethod,
+		RequestID:  actor.RequestID,
+		ClientIP:   actor.ClientIP,
+		Before:     marshalAuditState(before),
+		After:      marshalAuditState(after),
+		CreatedAt:  time.Now(),
+	}
+
+	if err := s.auditRepo.Create(ctx, event); err != nil {
+		log.Error().
+			Err(err).
+			Str("entityType", string(entityType)).
+			Int64("entityID", entityID).
+			Str("action", string(action)).
+			Str("actor", actor.Username).
+			Msg("Failed to record audit event")
+	}
+}
+
+// ListEvents retrieves audit events matching the filter
+func (s *AuditService) ListEvents(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]*models.AuditEvent, int, error) {
+	events, total, err := s.auditRepo.List(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, total, nil
+}
+
+// marshalAuditState encodes an entity snapshot, returning nil for absent state
+func marshalAuditState(state interface{}) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to encode audit state")
+		return nil
+	}
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
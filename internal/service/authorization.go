@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/rs/zerolog/log"
+)
+
+// Errors returned when managing roles
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleBuiltin        = errors.New("built-in roles cannot be changed")
+	ErrRoleExists         = errors.New("a role with that name already exists")
+	ErrAssignmentNotFound = errors.New("role assignment not found")
+	ErrAssignmentExists   = errors.New("the user already holds that role")
+)
+
+// AuthorizationError is returned when the acting principal lacks a permission
+type AuthorizationError struct {
+	Actor      string
+	Permission models.Permission
+	TemplateID int64 // zero for a global permission
+}
+
+// Error implements the error interface
+func (e *AuthorizationError) Error() string {
+	if e.TemplateID == 0 {
+		return fmt.Sprintf("%s lacks the %s permission", e.Actor, e.Permission)
+	}
+	return fmt.Sprintf("%s lacks the %s permission on template %d", e.Actor, e.Permission, e.TemplateID)
+}
+
+// AuthorizationService decides what the acting principal may do, from the
+// permissions of their roles, and manages roles and their assignment
+type AuthorizationService struct {
+	roleRepo repository.RoleRepository
+	userRepo repository.UserRepository
+	auditSvc *AuditService
+}
+
+// NewAuthorizationService creates a new AuthorizationService
+func NewAuthorizationService(roleRepo repository.RoleRepository, userRepo repository.UserRepository, auditSvc *AuditService) *AuthorizationService {
+	return &AuthorizationService{
+		roleRepo: roleRepo,
+		userRepo: userRepo,
+		auditSvc: auditSvc,
+	}
+}
+
+// Authorize checks the principal stored in ctx holds permission, everywhere or
+// for the template. A zero templateID requires the permission everywhere.
+// System actors may do anything; a context without an actor may do nothing.
+// Callers authenticated by API key get no more than their owner's roles allow,
+// intersected with what the key's scope and grants allow.
+func (s *AuthorizationService) Authorize(ctx context.Context, permission models.Permission, templateID int64) error {
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		return &AuthorizationError{Actor: "anonymous", Permission: permission, TemplateID: templateID}
+	}
+	if actor.AuthMethod == "system" {
+		return nil
+	}
+
+	denied := &AuthorizationError{Actor: actor.Username, Permission: permission, TemplateID: templateID}
+	if actor.UserID == 0 {
+		return denied
+	}
+	if actor.AuthMethod == "apikey" {
+		if !scopeAllows(scopeFromContext(ctx), permission) || !grantsAllowTemplate(grantsFromContext(ctx), templateID) {
+			return denied
+		}
+	}
+
+	permissions, err := s.roleRepo.GetUserPermissions(ctx, actor.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get permissions: %w", err)
+	}
+	if !permissions.Has(permission, templateID) {
+		return denied
+	}
+
+	return nil
+}
+
+// Permissions returns the permissions of the principal stored in ctx. For an API
+// key, only those its scope allows are returned; its grants may narrow them further.
+func (s *AuthorizationService) Permissions(ctx context.Context) (*models.UserPermissions, error) {
+	actor, ok := ActorFromContext(ctx)
+	if !ok || actor.UserID == 0 {
+		return nil, fmt.Errorf("no authenticated user")
+	}
+
+	permissions, err := s.roleRepo.GetUserPermissions(ctx, actor.UserID)
+	if err != nil || actor.AuthMethod != "apikey" {
+		return permissions, err
+	}
+
+	scope := scopeFromContext(ctx)
+	permissions.Global = scopedPermissions(permissions.Global, scope)
+	for templateID, granted := range permissions.Templates {
+		permissions.Templates[templateID] = scopedPermissions(granted, scope)
+	}
+	return permissions, nil
+}
+
+// scopedPermissions returns the permissions in granted that an API key scope
+// allows, expanding the all-permissions wildcard
+func scopedPermissions(granted map[models.Permission]bool, scope string) map[models.Permission]bool {
+	scoped := make(map[models.Permission]bool)
+	for _, permission := range models.Permissions {
+		if permission == models.PermissionAll {
+			continue
+		}
+		if (granted[permission] || granted[models.PermissionAll]) && scopeAllows(scope, permission) {
+			scoped[permission] = true
+		}
+	}
+	return scoped
+}
+
+// ListRoles lists every role
+func (s *AuthorizationService) ListRoles(ctx context.Context) ([]*models.RoleDefinition, error) {
+	return s.roleRepo.List(ctx)
+}
+
+// GetRole returns a role by its ID
+func (s *AuthorizationService) GetRole(ctx context.Context, id int64) (*models.RoleDefinition, error) {
+	role, err := s.roleRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, ErrRoleNotFound
+	}
+	return role, nil
+}
+
+// ValidateRole checks a role exists, so users can be given it
+func (s *AuthorizationService) ValidateRole(ctx context.Context, name string) error {
+	if _, err := s.roleRepo.GetByName(ctx, name); err != nil {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, name)
+	}
+	return nil
+}
+
+// CreateRole creates a custom role
+func (s *AuthorizationService) CreateRole(ctx context.Context, req *models.RoleCreateRequest) (*models.RoleDefinition, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("role name is required")
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		return nil, err
+	}
+	if _, err := s.roleRepo.GetByName(ctx, name); err == nil {
+		return nil, ErrRoleExists
+	}
+
+	role := &models.RoleDefinition{
+		Name:        models.Role(name),
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if err := s.roleRepo.Create(ctx, role); err != nil {
+		return nil, err
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityRole, role.ID, models.AuditActionCreate, nil, role)
+
+	return role, nil
+}
+
+// UpdateRole changes the description or permissions of a custom role
+func (s *AuthorizationService) UpdateRole(ctx context.Context, id int64, req *models.RoleUpdateRequest) (*models.RoleDefinition, error) {
+	role, err := s.GetRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Builtin {
+		return nil, ErrRoleBuiltin
+	}
+
+	before := *role
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		if err := validatePermissions(req.Permissions); err != nil {
+			return nil, err
+		}
+		role.Permissions = req.Permissions
+	}
+
+	if err := s.roleRepo.Update(ctx, role); err != nil {
+		return nil, err
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityRole, role.ID, models.AuditActionUpdate, &before, role)
+
+	return role, nil
+}
+
+// DeleteRole deletes a custom role that no user holds
+func (s *AuthorizationService) DeleteRole(ctx context.Context, id int64) error {
+	role, err := s.GetRole(ctx, id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrRoleBuiltin
+	}
+
+	if err := s.roleRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityRole, id, models.AuditActionDelete, role, nil)
+
+	return nil
+}
+
+// ListAssignments lists the roles assigned to a user
+func (s *AuthorizationService) ListAssignments(ctx context.Context, userID int64) ([]*models.RoleAssignment, error) {
+	return s.roleRepo.ListAssignments(ctx, userID)
+}
+
+// AssignRole gives a user a role, everywhere or for one template
+func (s *AuthorizationService) AssignRole(ctx context.Context, userID int64, req *models.RoleAssignmentRequest) (*models.RoleAssignment, error) {
+	if _, err := s.userRepo.GetByID(ctx, userID); err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	role, err := s.roleRepo.GetByName(ctx, req.Role)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRoleNotFound, req.Role)
+	}
+
+	return s.assign(ctx, userID, role, req.TemplateID)
+}
+
+// assign records a role assignment unless the user already holds it
+func (s *AuthorizationService) assign(ctx context.Context, userID int64, role *models.RoleDefinition, templateID *int64) (*models.RoleAssignment, error) {
+	existing, err := s.roleRepo.ListAssignments(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, assignment := range existing {
+		if assignment.RoleID == role.ID && equalTemplate(assignment.TemplateID, templateID) {
+			return nil, ErrAssignmentExists
+		}
+	}
+
+	actor, _ := ActorFromContext(ctx)
+	assignment := &models.RoleAssignment{
+		UserID:     userID,
+		RoleID:     role.ID,
+		Role:       role.Name,
+		TemplateID: templateID,
+		CreatedBy:  actor.Username,
+	}
+	if err := s.roleRepo.CreateAssignment(ctx, assignment); err != nil {
+		return nil, err
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityRoleAssignment, assignment.ID, models.AuditActionCreate, nil, assignment)
+
+	return assignment, nil
+}
+
+// UnassignRole removes one of a user's role assignments
+func (s *AuthorizationService) UnassignRole(ctx context.Context, userID, assignmentID int64) error {
+	assignment, err := s.roleRepo.GetAssignment(ctx, assignmentID)
+	if err != nil || assignment.UserID != userID {
+		return ErrAssignmentNotFound
+	}
+
+	if err := s.roleRepo.DeleteAssignment(ctx, assignmentID); err != nil {
+		return err
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityRoleAssignment, assignmentID, models.AuditActionDelete, assignment, nil)
+
+	return nil
+}
+
+// AssignTemplateOwner makes the principal stored in ctx the owner of a template
+// they created, unless they can already manage every template
+func (s *AuthorizationService) AssignTemplateOwner(ctx context.Context, templateID int64) {
+	actor, ok := ActorFromContext(ctx)
+	if !ok || actor.UserID == 0 {
+		return
+	}
+	if err := s.Authorize(ctx, models.PermissionTemplateUpdate, 0); err == nil {
+		return
+	}
+
+	role, err := s.roleRepo.GetByName(ctx, string(models.RoleTemplateOwner))
+	if err == nil {
+		_, err = s.assign(ctx, actor.UserID, role, &templateID)
+	}
+	if err != nil {
+		log.Warn().Err(err).Int64("templateID", templateID).Str("user", actor.Username).Msg("Failed to make template creator its owner")
+	}
+}
+
+// validatePermissions checks every permission is known
+func validatePermissions(permissions []models.Permission) error {
+	for _, permission := range permissions {
+		if !slices.Contains(models.Permissions, permission) {
+			return fmt.Errorf("unknown permission: %s", permission)
+		}
+	}
+	return nil
+}
+
+// equalTemplate reports whether two optional template IDs are the same
+func equalTemplate(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
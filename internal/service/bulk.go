@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// batchRolledBackMessage is reported for items that were valid but not applied
+// because another item in an all-or-nothing batch failed
+const batchRolledBackMessage = "not applied: another item in the batch failed"
+
+// ReserveHostnames reserves several hostnames from one template in a single transaction.
+// Either all hostnames are reserved or none are; parameter validation failures are
+// reported per item without allocating anything.
+func (s *ReservationService) ReserveHostnames(ctx context.Context, req *models.HostnameBulkReservationRequest) (*models.BulkOperationResponse, error) {
+	// Expand the request into one parameter set per hostname
+	paramSets := req.ParamSets
+	switch {
+	case req.Count > 0 && len(paramSets) > 0:
+		return nil, fmt.Errorf("count and param_sets are mutually exclusive")
+	case req.Count > 0:
+		paramSets = make([]map[string]string, req.Count)
+		for i := range paramSets {
+			paramSets[i] = req.Params
+		}
+	case len(paramSets) == 0:
+		return nil, fmt.Errorf("either count or param_sets is required")
+	}
+
+	// Get template
+	template, err := s.templateRepo.GetByID(ctx, req.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	// Refuse the whole batch if the caller may not reserve any part of it
+	if s.authz != nil {
+		if err := s.authz.Authorize(ctx, models.PermissionHostnameReserve, req.TemplateID); err != nil {
+			return nil, err
+		}
+	}
+	for i, params := range paramSets {
+		if err := checkGrants(grantsFromContext(ctx), req.TemplateID, params); err != nil {
+			if req.Count > 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("param set %d: %w", i, err)
+		}
+	}
+
+	response := &models.BulkOperationResponse{
+		Mode:    models.BulkModeAllOrNothing,
+		Total:   len(paramSets),
+		Results: make([]models.BulkItemResult, len(paramSets)),
+	}
+
+	// Reject invalid parameters before allocating any sequence numbers
+	for i, params := range paramSets {
+		response.Results[i].Index = i
+		if err := s.generatorSvc.ValidateParams(template, params); err != nil {
+			response.Results[i].Error = err.Error()
+		}
+	}
+	if failBatch(response) {
+		return response, nil
+	}
+
+	// Allocate every sequence number and insert all hostnames in one transaction
+	hostnames := make([]*models.Hostname, len(paramSets))
+	for i := range hostnames {
+		hostnames[i] = &models.Hostname{
+			TemplateID:      req.TemplateID,
+			TemplateVersion: template.Version,
+			Status:          models.StatusReserved,
+			ReservedBy:      req.RequestedBy,
+			ExpiresAt:       s.reservationExpiry(template),
+			SequenceScope:   s.generatorSvc.SequenceScopeKey(template, paramSets[i]),
+			DNSVerified:     false,
+		}
+	}
+
+	alloc := sequenceAllocation(template, s.config)
+	alloc.Contiguous = req.Contiguous
+	response.AllocationStrategy = alloc.Strategy
+
+	err = s.hostnameRepo.ReserveSequences(ctx, hostnames, alloc, func(index, seq int) (string, error) {
+		name, err := s.generatorSvc.BuildHostname(template, seq, paramSets[index])
+		if err != nil {
+			return "", fmt.Errorf("failed to generate hostname: %w", err)
+		}
+		return name, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve hostnames: %w", err)
+	}
+
+	for i, hostname := range hostnames {
+		response.Results[i] = models.BulkItemResult{
+			Index:      i,
+			HostnameID: hostname.ID,
+			Hostname:   hostname,
+			Success:    true,
+		}
+		s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionReserve, nil, hostname)
+	}
+	response.Succeeded = len(hostnames)
+
+	log.Info().
+		Int64("templateID", req.TemplateID).
+		Int("count", len(hostnames)).
+		Int("firstSequence", hostnames[0].SequenceNum).
+		Int("lastSequence", hostnames[len(hostnames)-1].SequenceNum).
+		Msg("Hostnames reserved in bulk")
+
+	return response, nil
+}
+
+// CommitHostnames commits several reserved hostnames. Addresses given in
+// req.IPAddresses are recorded and registered in DNS as for a single commit.
+func (s *ReservationService) CommitHostnames(ctx context.Context, req *models.HostnameBulkRequest) (*models.BulkOperationResponse, error) {
+	if err := checkBulkIPAddresses(req); err != nil {
+		return nil, err
+	}
+
+	return s.bulkTransition(ctx, req, models.AuditActionCommit,
+		func(hostname *models.Hostname) error {
+			if err := s.authorizeHostname(ctx, models.PermissionHostnameCommit, hostname); err != nil {
+				return err
+			}
+			if hostname.Status != models.StatusReserved {
+				return fmt.Errorf("hostname is not in reserved status, current status: %s", hostname.Status)
+			}
+			if hostname.ExpiresAt != nil && !hostname.ExpiresAt.After(time.Now()) {
+				return fmt.Errorf("reservation expired at %s", hostname.ExpiresAt.Format(time.RFC3339))
+			}
+			return nil
+		},
+		func(id int64) error {
+			return s.CommitHostname(ctx, &models.HostnameCommitRequest{
+				HostnameID:  id,
+				CommittedBy: req.RequestedBy,
+				IPAddress:   req.IPAddresses[id],
+			})
+		},
+		func(hostnames []*models.Hostname) error {
+			// Publish the DNS records before committing, and withdraw them again if
+			// the commit fails, as for a single commit
+			registered := make([]*models.Hostname, len(hostnames))
+			for i, hostname := range hostnames {
+				withIP := *hostname
+				withIP.IPAddress = req.IPAddresses[hostname.ID]
+				registered[i] = &withIP
+			}
+			if err := s.registerAll(ctx, registered); err != nil {
+				return err
+			}
+
+			if err := s.hostnameRepo.CommitHostnames(ctx, req.HostnameIDs, req.RequestedBy, req.IPAddresses); err != nil {
+				s.restoreDNS(ctx, registered, s.deregisterDNS, "Failed to remove DNS records after failed bulk commit")
+				return err
+			}
+			return nil
+		},
+	)
+}
+
+// ReleaseHostnames releases several committed hostnames
+func (s *ReservationService) ReleaseHostnames(ctx context.Context, req *models.HostnameBulkRequest) (*models.BulkOperationResponse, error) {
+	if len(req.IPAddresses) > 0 {
+		return nil, fmt.Errorf("ip_addresses only apply to commits")
+	}
+
+	return s.bulkTransition(ctx, req, models.AuditActionRelease,
+		func(hostname *models.Hostname) error {
+			if err := s.authorizeRelease(ctx, hostname); err != nil {
+				return err
+			}
+			if hostname.Status != models.StatusCommitted {
+				return fmt.Errorf("hostname is not in committed status, current status: %s", hostname.Status)
+			}
+			return nil
+		},
+		func(id int64) error {
+			return s.ReleaseHostname(ctx, &models.HostnameReleaseRequest{HostnameID: id, ReleasedBy: req.RequestedBy})
+		},
+		func(hostnames []*models.Hostname) error {
+			// Remove the DNS records first, and put them back if the release fails,
+			// so every hostname stays committed with its records in place
+			if err := s.deregisterAll(ctx, hostnames); err != nil {
+				return err
+			}
+
+			if err := s.hostnameRepo.ReleaseHostnames(ctx, req.HostnameIDs, req.RequestedBy); err != nil {
+				s.restoreDNS(ctx, hostnames, s.registerDNS, "Failed to restore DNS records after failed bulk release")
+				return err
+			}
+			return nil
+		},
+	)
+}
+
+// checkBulkIPAddresses checks that every address in a bulk commit is valid and
+// belongs to a hostname in the request
+func checkBulkIPAddresses(req *models.HostnameBulkRequest) error {
+	requested := make(map[int64]bool, len(req.HostnameIDs))
+	for _, id := range req.HostnameIDs {
+		requested[id] = true
+	}
+
+	for id, ipAddress := range req.IPAddresses {
+		if !requested[id] {
+			return fmt.Errorf("ip_addresses names hostname %d, which is not in hostname_ids", id)
+		}
+		if err := validateIPAddress(ipAddress); err != nil {
+			return fmt.Errorf("hostname %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// registerAll publishes the DNS records of each hostname in turn. If one fails,
+// the records already published are withdrawn again.
+func (s *ReservationService) registerAll(ctx context.Context, hostnames []*models.Hostname) error {
+	for i, hostname := range hostnames {
+		if err := s.registerDNS(ctx, hostname); err != nil {
+			s.restoreDNS(ctx, hostnames[:i], s.deregisterDNS, "Failed to remove DNS records after failed bulk registration")
+			return fmt.Errorf("%s: %w", hostname.Name, err)
+		}
+	}
+	return nil
+}
+
+// deregisterAll removes the DNS records of each hostname in turn. If one fails,
+// the records already removed are published again.
+func (s *ReservationService) deregisterAll(ctx context.Context, hostnames []*models.Hostname) error {
+	for i, hostname := range hostnames {
+		if err := s.deregisterDNS(ctx, hostname); err != nil {
+			s.restoreDNS(ctx, hostnames[:i], s.registerDNS, "Failed to restore DNS records after failed bulk removal")
+			return fmt.Errorf("%s: %w", hostname.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreDNS undoes the DNS changes already made for hostnames by applying undo
+// to each. Failures are logged; the original error is what the caller reports.
+func (s *ReservationService) restoreDNS(ctx context.Context, hostnames []*models.Hostname, undo func(context.Context, *models.Hostname) error, message string) {
+	for _, hostname := range hostnames {
+		if err := undo(ctx, hostname); err != nil {
+			log.Warn().Err(err).Int64("hostnameID", hostname.ID).Msg(message)
+		}
+	}
+}
+
+// bulkTransition applies a status change to every hostname in req. In best-effort mode
+// each item is applied on its own with applyOne. In all-or-nothing mode every item is
+// first checked, and only if all pass are they applied together with applyAll, which
+// receives the checked hostnames in request order.
+func (s *ReservationService) bulkTransition(
+	ctx context.Context,
+	req *models.HostnameBulkRequest,
+	action models.AuditAction,
+	check func(hostname *models.Hostname) error,
+	applyOne func(id int64) error,
+	applyAll func(hostnames []*models.Hostname) error,
+) (*models.BulkOperationResponse, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = models.BulkModeAllOrNothing
+	}
+
+	response := &models.BulkOperationResponse{
+		Mode:    mode,
+		Total:   len(req.HostnameIDs),
+		Results: make([]models.BulkItemResult, len(req.HostnameIDs)),
+	}
+
+	if mode == models.BulkModeBestEffort {
+		for i, id := range req.HostnameIDs {
+			response.Results[i] = models.BulkItemResult{Index: i, HostnameID: id}
+			if err := applyOne(id); err != nil {
+				response.Results[i].Error = err.Error()
+				response.Failed++
+				continue
+			}
+
+			hostname, err := s.hostnameRepo.GetByID(ctx, id)
+			if err != nil {
+				log.Warn().Err(err).Int64("hostnameID", id).Msg("Failed to get hostname after bulk operation")
+			}
+			response.Results[i].Hostname = hostname
+			response.Results[i].Success = true
+			response.Succeeded++
+		}
+		return response, nil
+	}
+
+	// Check every item before changing anything
+	before := make([]*models.Hostname, len(req.HostnameIDs))
+	seen := make(map[int64]bool, len(req.HostnameIDs))
+	for i, id := range req.HostnameIDs {
+		response.Results[i] = models.BulkItemResult{Index: i, HostnameID: id}
+
+		if seen[id] {
+			response.Results[i].Error = "duplicate hostname ID"
+			continue
+		}
+		seen[id] = true
+
+		hostname, err := s.hostnameRepo.GetByID(ctx, id)
+		if err != nil {
+			response.Results[i].Error = fmt.Sprintf("failed to get hostname: %v", err)
+			continue
+		}
+		if err := check(hostname); err != nil {
+			response.Results[i].Error = err.Error()
+			continue
+		}
+		before[i] = hostname
+	}
+	if failBatch(response) {
+		return response, nil
+	}
+
+	// Apply all items in one transaction
+	if err := applyAll(before); err != nil {
+		for i := range response.Results {
+			response.Results[i].Error = err.Error()
+		}
+		response.Failed = response.Total
+		return response, nil
+	}
+
+	for i, id := range req.HostnameIDs {
+		hostname, err := s.hostnameRepo.GetByID(ctx, id)
+		if err != nil {
+			log.Warn().Err(err).Int64("hostnameID", id).Msg("Failed to get hostname after bulk operation")
+		}
+		response.Results[i].Hostname = hostname
+		response.Results[i].Success = true
+		s.auditSvc.Record(ctx, models.AuditEntityHostname, id, action, before[i], hostname)
+	}
+	response.Succeeded = response.Total
+
+	return response, nil
+}
+
+// failBatch marks every item without an error as rolled back if any item failed,
+// and reports whether the batch failed
+func failBatch(response *models.BulkOperationResponse) bool {
+	failed := false
+	for _, result := range response.Results {
+		if result.Error != "" {
+			failed = true
+			break
+		}
+	}
+	if !failed {
+		return false
+	}
+
+	for i := range response.Results {
+		if response.Results[i].Error == "" {
+			response.Results[i].Error = batchRolledBackMessage
+		}
+	}
+	response.Failed = response.Total
+	return true
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+// HostnamesForDNSCheck returns up to limit hostnames with IDs above afterID whose
+// DNS records should be reconciled, in ID order
+func (s *ReservationService) HostnamesForDNSCheck(ctx context.Context, afterID int64, limit int) ([]*models.Hostname, error) {
+	return s.hostnameRepo.ListForDNSCheck(ctx, afterID, limit)
+}
+
+// RecordDNSCheck compares what DNS returned for a hostname with its status and
+// stores the outcome, returning the drift found. A committed hostname is verified
+// when it resolves, to its committed address if it has one.
+func (s *ReservationService) RecordDNSCheck(ctx context.Context, hostname *models.Hostname, result *models.DNSVerificationResult) (models.DNSDrift, error) {
+	drift := dnsDrift(hostname, result)
+	verified := hostname.Status == models.StatusCommitted && drift == models.DNSDriftNone
+
+	checkedAt := result.VerifiedAt
+	if checkedAt.IsZero() {
+		checkedAt = time.Now()
+	}
+
+	if err := s.hostnameRepo.RecordDNSCheck(ctx, hostname.ID, verified, result.IPAddress, drift, checkedAt); err != nil {
+		return drift, err
+	}
+
+	return drift, nil
+}
+
+// dnsDrift classifies how a DNS lookup disagrees with a hostname's status
+func dnsDrift(hostname *models.Hostname, result *models.DNSVerificationResult) models.DNSDrift {
+	// Conflict entries record names known to be in DNS already
+	if hostname.Status == models.StatusConflict {
+		return models.DNSDriftNone
+	}
+
+	if hostname.Status != models.StatusCommitted {
+		if result.Exists {
+			return models.DNSDriftUnexpected
+		}
+		return models.DNSDriftNone
+	}
+
+	if !result.Exists {
+		return models.DNSDriftMissing
+	}
+	if hostname.IPAddress == "" {
+		return models.DNSDriftNone
+	}
+
+	// Any of the addresses returned will do
+	committed := net.ParseIP(hostname.IPAddress)
+	for _, record := range result.Records {
+		if record.Type != "A" && record.Type != "AAAA" {
+			continue
+		}
+		if committed.Equal(net.ParseIP(record.Value)) {
+			return models.DNSDriftNone
+		}
+	}
+	return models.DNSDriftAddressMismatch
+}
+
+// GetDNSDrift returns the hostnames whose last DNS check found drift, optionally
+// of one kind only
+func (s *ReservationService) GetDNSDrift(ctx context.Context, drift models.DNSDrift, limit, offset int) ([]*models.Hostname, int, error) {
+	return s.hostnameRepo.ListDNSDrift(ctx, drift, limit, offset)
+}
+
+// CountDNSDrift counts the hostnames whose last DNS check found drift, by kind
+func (s *ReservationService) CountDNSDrift(ctx context.Context) (map[models.DNSDrift]int, error) {
+	return s.hostnameRepo.CountDNSDrift(ctx)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+// GroupValidationError describes a template group that rejected the value it was given
+type GroupValidationError struct {
+	Group     string   `json:"group"`
+	Value     string   `json:"value"`
+	Reason    string   `json:"reason"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Allowed   []string `json:"allowed,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+}
+
+// ValidationError is returned when hostname parameters fail a strict template's validation
+type ValidationError struct {
+	TemplateID int64                  `json:"template_id"`
+	Errors     []GroupValidationError `json:"errors"`
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, groupErr := range e.Errors {
+		parts = append(parts, fmt.Sprintf("%s=%q: %s", groupErr.Group, groupErr.Value, groupErr.Reason))
+	}
+	return fmt.Sprintf("invalid parameters for template %d: %s", e.TemplateID, strings.Join(parts, "; "))
+}
+
+// ParseError is returned when a hostname cannot be split into a template's groups
+type ParseError struct {
+	TemplateID int64                   `json:"template_id"`
+	Hostname   string                  `json:"hostname"`
+	Mismatch   models.HostnameMismatch `json:"mismatch"`
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	if e.Mismatch.Group == "" {
+		return fmt.Sprintf("hostname %q does not match template %d: %s", e.Hostname, e.TemplateID, e.Mismatch.Reason)
+	}
+	return fmt.Sprintf("hostname %q does not match template %d: group %s %s at position %d (%q)",
+		e.Hostname, e.TemplateID, e.Mismatch.Group, e.Mismatch.Reason, e.Mismatch.Position, e.Mismatch.Remainder)
+}
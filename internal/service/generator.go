@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -15,12 +17,14 @@ import (
 // GeneratorService is responsible for generating hostnames
 type GeneratorService struct {
 	templateRepo repository.TemplateRepository
+	auditSvc     *AuditService
 }
 
 // NewGeneratorService creates a new GeneratorService
-func NewGeneratorService(templateRepo repository.TemplateRepository) *GeneratorService {
+func NewGeneratorService(templateRepo repository.TemplateRepository, auditSvc *AuditService) *GeneratorService {
 	return &GeneratorService{
 		templateRepo: templateRepo,
+		auditSvc:     auditSvc,
 	}
 }
 
@@ -32,6 +36,11 @@ func (s *GeneratorService) GenerateHostname(ctx context.Context, templateID int6
 		return "", fmt.Errorf("failed to get template: %w", err)
 	}
 
+	return s.BuildHostname(template, sequenceNum, params)
+}
+
+// BuildHostname generates a hostname from an already loaded template
+func (s *GeneratorService) BuildHostname(template *models.Template, sequenceNum int, params map[string]string) (string, error) {
 	// If the sequence number is not provided, use the template's start sequence
 	if sequenceNum <= 0 {
 		sequenceNum = template.SequenceStart
@@ -41,7 +50,10 @@ func (s *GeneratorService) GenerateHostname(ctx context.Context, templateID int6
 	sequenceStr := formatSequence(sequenceNum, template.SequenceLength, template.SequencePadding)
 
 	// Generate the hostname
-	hostname := s.buildHostname(template, sequenceStr, params)
+	hostname, err := s.buildHostname(template, sequenceStr, params)
+	if err != nil {
+		return "", err
+	}
 
 	// Validate hostname length
 	if len(hostname) > template.MaxLength {
@@ -59,10 +71,21 @@ func formatSequence(num, length int, padding bool) string {
 	return strconv.Itoa(num)
 }
 
-// buildHostname builds a hostname from a template and parameters
-func (s *GeneratorService) buildHostname(template *models.Template, sequenceStr string, params map[string]string) string {
+// ValidateParams checks parameters against the template's groups without
+// allocating a sequence number. Only strict templates reject parameters; the
+// returned error is a *ValidationError listing every failing group.
+func (s *GeneratorService) ValidateParams(template *models.Template, params map[string]string) error {
+	_, err := s.buildHostname(template, "", params)
+	return err
+}
+
+// buildHostname builds a hostname from a template and parameters. Strict
+// templates return a *ValidationError naming every group that rejected its
+// value; other templates log a warning and substitute a default value.
+func (s *GeneratorService) buildHostname(template *models.Template, sequenceStr string, params map[string]string) (string, error) {
 	// Groups to build the hostname
 	var hostnameBuilder strings.Builder
+	var failures []GroupValidationError
 
 	// Sort groups by position
 	groupsByPosition := make(map[int]models.TemplateGroup)
@@ -88,80 +111,34 @@ func (s *GeneratorService) buildHostname(template *models.Template, sequenceStr
 			groupValue = sequenceStr
 		default:
 			// Try to get value from parameters
-			paramValue, exists := params[group.Name]
-			if exists {
-				// Validate against the validation rule if provided
-				if group.ValidationType == string(models.ValidationTypeRegex) && group.ValidationValue != "" {
-					if match, _ := regexp.MatchString(group.ValidationValue, paramValue); !match {
-						log.Warn().
-							Str("group", group.Name).
-							Str("value", paramValue).
-							Str("pattern", group.ValidationValue).
-							Msg("Group value does not match validation pattern")
-
-						// If required, use a default or empty value
-						if group.IsRequired {
-							// Use first character of validation value as default if possible
-							if len(group.ValidationValue) > 0 {
-								groupValue = string(group.ValidationValue[0])
-							} else {
-								groupValue = "X" // Fallback default
-							}
-						} else {
-							groupValue = "" // Optional group, skip it
-						}
-					} else {
-						groupValue = paramValue
-					}
-				} else if group.ValidationType == string(models.ValidationTypeList) && group.ValidationValue != "" {
-					// Check if value is in the allowed list
-					allowedValues := strings.Split(group.ValidationValue, ",")
-					allowed := false
-					for _, allowedValue := range allowedValues {
-						if strings.TrimSpace(allowedValue) == paramValue {
-							allowed = true
-							break
-						}
-					}
-
-					if !allowed {
-						log.Warn().
-							Str("group", group.Name).
-							Str("value", paramValue).
-							Str("allowed", group.ValidationValue).
-							Msg("Group value not in allowed list")
-
-						// If required, use the first value from the list, otherwise skip
-						if group.IsRequired && len(allowedValues) > 0 {
-							groupValue = strings.TrimSpace(allowedValues[0])
-						} else {
-							groupValue = ""
-						}
-					} else {
-						groupValue = paramValue
-					}
-				} else {
-					// No validation or validation passed
-					groupValue = paramValue
-				}
-			} else if group.IsRequired {
-				// Required parameter not provided, use default or error
+			paramValue, provided := params[group.Name]
+			failure := validateGroupValue(group, paramValue, provided)
+
+			switch {
+			case failure == nil:
+				groupValue = paramValue
+			case template.StrictValidation:
+				failures = append(failures, *failure)
+				continue
+			default:
 				log.Warn().
 					Str("group", group.Name).
-					Msg("Required group parameter not provided")
-
-				// Use a default value
-				if group.ValidationType == string(models.ValidationTypeList) && group.ValidationValue != "" {
-					// Use first value from list
-					allowedValues := strings.Split(group.ValidationValue, ",")
-					if len(allowedValues) > 0 {
-						groupValue = strings.TrimSpace(allowedValues[0])
-					} else {
-						groupValue = "X" // Fallback default
-					}
-				} else {
-					groupValue = "X" // Fallback default
-				}
+					Str("value", paramValue).
+					Str("validation", group.ValidationValue).
+					Str("reason", failure.Reason).
+					Msg("Invalid group value, substituting default")
+				groupValue = fallbackGroupValue(group, provided)
+			}
+
+			// Strict templates never truncate user input
+			if template.StrictValidation && group.Length > 0 && len(groupValue) > group.Length {
+				failures = append(failures, GroupValidationError{
+					Group:     group.Name,
+					Value:     paramValue,
+					Reason:    "value exceeds group length",
+					MaxLength: group.Length,
+				})
+				continue
 			}
 		}
 
@@ -174,7 +151,185 @@ func (s *GeneratorService) buildHostname(template *models.Template, sequenceStr
 		hostnameBuilder.WriteString(groupValue)
 	}
 
-	return hostnameBuilder.String()
+	if len(failures) > 0 {
+		return "", &ValidationError{TemplateID: template.ID, Errors: failures}
+	}
+
+	return hostnameBuilder.String(), nil
+}
+
+// validateGroupValue checks a parameter value against a group's validation rule
+// and returns nil if it is acceptable
+func validateGroupValue(group models.TemplateGroup, value string, provided bool) *GroupValidationError {
+	if !provided {
+		if group.IsRequired {
+			return &GroupValidationError{
+				Group:  group.Name,
+				Reason: "required group value not provided",
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case group.ValidationType == string(models.ValidationTypeRegex) && group.ValidationValue != "":
+		if pattern, err := compileGroupPattern(group.ValidationValue); err != nil || !pattern.MatchString(value) {
+			return &GroupValidationError{
+				Group:   group.Name,
+				Value:   value,
+				Reason:  "value does not match validation pattern",
+				Pattern: group.ValidationValue,
+			}
+		}
+	case group.ValidationType == string(models.ValidationTypeList) && group.ValidationValue != "":
+		allowedValues := splitList(group.ValidationValue)
+		for _, allowedValue := range allowedValues {
+			if allowedValue == value {
+				return nil
+			}
+		}
+		return &GroupValidationError{
+			Group:   group.Name,
+			Value:   value,
+			Reason:  "value not in allowed list",
+			Allowed: allowedValues,
+		}
+	}
+
+	return nil
+}
+
+// compileGroupPattern compiles a regex group's validation value, anchored so that
+// it must match the whole value
+func compileGroupPattern(value string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + value + ")$")
+}
+
+// fallbackGroupValue returns the value non-strict templates substitute for an invalid group value
+func fallbackGroupValue(group models.TemplateGroup, provided bool) string {
+	if provided && !group.IsRequired {
+		// Optional group, skip it
+		return ""
+	}
+
+	switch group.ValidationType {
+	case string(models.ValidationTypeList):
+		// Use the first value from the list
+		if allowedValues := splitList(group.ValidationValue); len(allowedValues) > 0 && allowedValues[0] != "" {
+			return allowedValues[0]
+		}
+	case string(models.ValidationTypeRegex):
+		// Use first character of validation value as default if possible
+		if provided && len(group.ValidationValue) > 0 {
+			return string(group.ValidationValue[0])
+		}
+	}
+
+	return "X" // Fallback default
+}
+
+// splitList splits a comma-separated list validation value into trimmed entries
+func splitList(value string) []string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// ParseHostname splits a hostname into the group values and sequence number the
+// template would have produced it from. A name that doesn't fit the template's
+// layout returns a *ParseError describing where matching stopped.
+func (s *GeneratorService) ParseHostname(template *models.Template, name string) (*models.ParsedHostname, error) {
+	return parseHostname(template, name)
+}
+
+// ParseHostnameAllTemplates tries to parse a hostname against every active
+// template and reports the outcome for each
+func (s *GeneratorService) ParseHostnameAllTemplates(ctx context.Context, name string) ([]models.HostnameParseResult, error) {
+	templates, err := s.templateRepo.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active templates: %w", err)
+	}
+
+	results := make([]models.HostnameParseResult, 0, len(templates))
+	for _, template := range templates {
+		results = append(results, s.parseResult(template, name))
+	}
+
+	return results, nil
+}
+
+// ParseHostnameWithTemplate parses a hostname against a single template
+func (s *GeneratorService) ParseHostnameWithTemplate(ctx context.Context, templateID int64, name string) (*models.HostnameParseResult, error) {
+	template, err := s.templateRepo.GetByID(ctx, templateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	result := s.parseResult(template, name)
+	return &result, nil
+}
+
+// parseResult parses name against template and wraps the outcome
+func (s *GeneratorService) parseResult(template *models.Template, name string) models.HostnameParseResult {
+	result := models.HostnameParseResult{
+		TemplateID:   template.ID,
+		TemplateName: template.Name,
+	}
+
+	parsed, err := s.ParseHostname(template, name)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			result.Mismatch = &parseErr.Mismatch
+		} else {
+			result.Mismatch = &models.HostnameMismatch{Reason: err.Error()}
+		}
+		return result
+	}
+
+	result.Matched = true
+	result.Parsed = parsed
+	return result
+}
+
+// SequenceScopeKey returns the key of the sequence counter a hostname built from
+// params is numbered in. Globally scoped templates use models.SequenceScopeKeyTemplate;
+// scoped templates combine the scope mode with the values of the groups in scope.
+func (s *GeneratorService) SequenceScopeKey(template *models.Template, params map[string]string) string {
+	return sequenceScopeKey(template, params)
+}
+
+// sequenceScopeKey builds the sequence counter key for a hostname
+func sequenceScopeKey(template *models.Template, params map[string]string) string {
+	var inScope func(group models.TemplateGroup) bool
+	switch template.SequenceScope {
+	case models.SequenceScopePrefix:
+		inScope = func(models.TemplateGroup) bool { return true }
+	case models.SequenceScopeGroups:
+		scoped := make(map[string]bool, len(template.SequenceScopeGroups))
+		for _, name := range template.SequenceScopeGroups {
+			scoped[name] = true
+		}
+		inScope = func(group models.TemplateGroup) bool { return scoped[group.Name] }
+	default:
+		return models.SequenceScopeKeyTemplate
+	}
+
+	var parts []string
+	for _, group := range orderedGroups(template) {
+		// Fixed values never vary and the sequence is what is being counted
+		if group.ValidationType == string(models.ValidationTypeFixed) ||
+			group.ValidationType == string(models.ValidationTypeSequence) || !inScope(group) {
+			continue
+		}
+		parts = append(parts, group.Name+"="+truncate(params[group.Name], group.Length))
+	}
+
+	// The mode keeps the key distinct from the template-wide key even when every
+	// group in scope is fixed
+	return string(template.SequenceScope) + ":" + strings.Join(parts, ";")
 }
 
 // ValidateTemplate validates a template definition
@@ -202,6 +357,49 @@ func (s *GeneratorService) ValidateTemplate(ctx context.Context, template *model
 		return fmt.Errorf("sum of group lengths (%d) exceeds template max length (%d)", totalLength, template.MaxLength)
 	}
 
+	// Check that regex groups compile
+	for _, group := range template.Groups {
+		if group.ValidationType != string(models.ValidationTypeRegex) || group.ValidationValue == "" {
+			continue
+		}
+		if _, err := compileGroupPattern(group.ValidationValue); err != nil {
+			return fmt.Errorf("group %s has an invalid pattern: %w", group.Name, err)
+		}
+	}
+
+	// Check the sequence scope
+	switch template.SequenceScope {
+	case "", models.SequenceScopeGlobal, models.SequenceScopePrefix:
+	case models.SequenceScopeGroups:
+		if len(template.SequenceScopeGroups) == 0 {
+			return fmt.Errorf("sequence scope %q requires at least one scope group", template.SequenceScope)
+		}
+		for _, name := range template.SequenceScopeGroups {
+			found := false
+			for _, group := range template.Groups {
+				if group.Name == name && group.ValidationType != string(models.ValidationTypeSequence) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("sequence scope group %q is not a non-sequence group of the template", name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown sequence scope %q", template.SequenceScope)
+	}
+
+	// Check the allocation strategy
+	switch template.AllocationStrategy {
+	case "", models.AllocationNextHighest, models.AllocationLowestFree, models.AllocationLowestFreeAfterCooldown:
+	default:
+		return fmt.Errorf("unknown allocation strategy %q", template.AllocationStrategy)
+	}
+	if template.ReleaseCooldown < 0 {
+		return fmt.Errorf("release cooldown must not be negative")
+	}
+
 	return nil
 }
 
@@ -210,6 +408,11 @@ func (s *GeneratorService) GetAvailableTemplates(ctx context.Context, limit, off
 	return s.templateRepo.List(ctx, limit, offset)
 }
 
+// GetActiveTemplates returns every active template with its groups
+func (s *GeneratorService) GetActiveTemplates(ctx context.Context) ([]*models.Template, error) {
+	return s.templateRepo.ListActive(ctx)
+}
+
 // GetTemplateByID returns a template by ID
 func (s *GeneratorService) GetTemplateByID(ctx context.Context, id int64) (*models.Template, error) {
 	return s.templateRepo.GetByID(ctx, id)
@@ -219,15 +422,41 @@ func (s *GeneratorService) GetTemplateByID(ctx context.Context, id int64) (*mode
 func (s *GeneratorService) CreateTemplate(ctx context.Context, req *models.TemplateCreateRequest) (*models.Template, error) {
 	// Create template object
 	template := &models.Template{
-		Name:              req.Name,
-		Description:       req.Description,
-		MaxLength:         req.MaxLength,
-		SequenceStart:     req.SequenceStart,
-		SequenceLength:    req.SequenceLength,
-		SequencePadding:   req.SequencePadding,
-		SequenceIncrement: req.SequenceIncrement,
-		CreatedBy:         req.CreatedBy,
-		IsActive:          true,
+		Name:                req.Name,
+		Description:         req.Description,
+		MaxLength:           req.MaxLength,
+		SequenceStart:       req.SequenceStart,
+		SequenceLength:      req.SequenceLength,
+		SequencePadding:     req.SequencePadding,
+		SequenceIncrement:   req.SequenceIncrement,
+		SequenceScope:       models.SequenceScopeGlobal,
+		SequenceScopeGroups: req.SequenceScopeGroups,
+		AllocationStrategy:  models.AllocationNextHighest,
+		ReleaseCooldown:     req.ReleaseCooldown,
+		DNSCheckOnReserve:   req.DNSCheckOnReserve,
+		StrictValidation:    true,
+		ReservationTTL:      req.ReservationTTL,
+		CreatedBy:           req.CreatedBy,
+		IsActive:            true,
+	}
+	if req.StrictValidation != nil {
+		template.StrictValidation = *req.StrictValidation
+	}
+	if req.SequenceScope != "" {
+		template.SequenceScope = models.SequenceScope(req.SequenceScope)
+	}
+	if req.AllocationStrategy != "" {
+		template.AllocationStrategy = models.AllocationStrategy(req.AllocationStrategy)
+	}
+	for i, groupReq := range req.Groups {
+		template.Groups = append(template.Groups, models.TemplateGroup{
+			Name:            groupReq.Name,
+			Length:          groupReq.Length,
+			Position:        i + 1,
+			IsRequired:      groupReq.IsRequired,
+			ValidationType:  groupReq.ValidationType,
+			ValidationValue: groupReq.ValidationValue,
+		})
 	}
 
 	// Validate template
@@ -235,30 +464,177 @@ func (s *GeneratorService) CreateTemplate(ctx context.Context, req *models.Templ
 		return nil, err
 	}
 
-	// Save template
+	// Save template, its groups and its initial version
 	if err := s.templateRepo.Create(ctx, template); err != nil {
 		return nil, fmt.Errorf("failed to create template: %w", err)
 	}
 
-	// Process and save groups
-	for i, groupReq := range req.Groups {
-		group := &models.TemplateGroup{
-			TemplateID:      template.ID,
-			Name:            groupReq.Name,
-			Length:          groupReq.Length,
-			Position:        i + 1,
-			IsRequired:      groupReq.IsRequired,
-			ValidationType:  groupReq.ValidationType,
-			ValidationValue: groupReq.ValidationValue,
+	// Fetch the complete template with groups
+	created, err := s.templateRepo.GetByID(ctx, template.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get created template: %w", err)
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityTemplate, created.ID, models.AuditActionCreate, nil, created)
+
+	return created, nil
+}
+
+// UpdateTemplate applies an update to a template and stores the result as a new version.
+// Hostnames keep pointing at the version that produced them; if the sequence scope
+// changes, they are counted in the new scope.
+func (s *GeneratorService) UpdateTemplate(ctx context.Context, id int64, req *models.TemplateUpdateRequest) (*models.Template, error) {
+	template, err := s.templateRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	before := *template
+
+	// Apply changed fields
+	if req.Name != "" {
+		template.Name = req.Name
+	}
+	if req.Description != nil {
+		template.Description = *req.Description
+	}
+	if req.MaxLength > 0 {
+		template.MaxLength = req.MaxLength
+	}
+	if req.SequenceStart != nil {
+		template.SequenceStart = *req.SequenceStart
+	}
+	if req.SequenceLength > 0 {
+		template.SequenceLength = req.SequenceLength
+	}
+	if req.SequencePadding != nil {
+		template.SequencePadding = *req.SequencePadding
+	}
+	if req.SequenceIncrement > 0 {
+		template.SequenceIncrement = req.SequenceIncrement
+	}
+	if req.StrictValidation != nil {
+		template.StrictValidation = *req.StrictValidation
+	}
+	if req.SequenceScope != "" {
+		template.SequenceScope = models.SequenceScope(req.SequenceScope)
+	}
+	if req.SequenceScopeGroups != nil {
+		template.SequenceScopeGroups = req.SequenceScopeGroups
+	}
+	if req.AllocationStrategy != "" {
+		template.AllocationStrategy = models.AllocationStrategy(req.AllocationStrategy)
+	}
+	if req.ReleaseCooldown != nil {
+		template.ReleaseCooldown = *req.ReleaseCooldown
+	}
+	if req.DNSCheckOnReserve != nil {
+		template.DNSCheckOnReserve = *req.DNSCheckOnReserve
+	}
+	if req.ReservationTTL != nil {
+		template.ReservationTTL = *req.ReservationTTL
+	}
+	if req.IsActive != nil {
+		template.IsActive = *req.IsActive
+	}
+
+	// Replace groups if provided
+	if req.Groups != nil {
+		groups := make([]models.TemplateGroup, 0, len(req.Groups))
+		for i, groupReq := range req.Groups {
+			groups = append(groups, models.TemplateGroup{
+				TemplateID:      template.ID,
+				Name:            groupReq.Name,
+				Length:          groupReq.Length,
+				Position:        i + 1,
+				IsRequired:      groupReq.IsRequired,
+				ValidationType:  groupReq.ValidationType,
+				ValidationValue: groupReq.ValidationValue,
+			})
 		}
+		template.Groups = groups
+	}
 
-		if err := s.templateRepo.CreateTemplateGroup(ctx, group); err != nil {
-			return nil, fmt.Errorf("failed to create template group: %w", err)
+	// Validate the resulting template
+	if err := s.ValidateTemplate(ctx, template); err != nil {
+		return nil, err
+	}
+
+	// Hostnames numbered under the old scope keep counting in the new one
+	var scopeKey func(*models.Hostname) (string, error)
+	if sequenceScopeChanged(&before, template) {
+		versions, err := s.templateRepo.ListVersions(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template versions: %w", err)
 		}
+		scopeKey = rescopeFunc(template, &before, versions)
 	}
 
-	// Fetch the complete template with groups
-	return s.templateRepo.GetByID(ctx, template.ID)
+	// Save as the next version
+	template.Version++
+	if err := s.templateRepo.SaveVersion(ctx, template, req.UpdatedBy, scopeKey); err != nil {
+		return nil, fmt.Errorf("failed to update template: %w", err)
+	}
+
+	log.Info().
+		Int64("id", template.ID).
+		Str("name", template.Name).
+		Int("version", template.Version).
+		Msg("Template updated")
+
+	updated, err := s.templateRepo.GetByID(ctx, template.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get updated template: %w", err)
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityTemplate, updated.ID, models.AuditActionUpdate, &before, updated)
+
+	return updated, nil
+}
+
+// sequenceScopeChanged reports whether hostnames of before may be numbered in a
+// different sequence scope under after
+func sequenceScopeChanged(before, after *models.Template) bool {
+	scoped := func(t *models.Template) bool {
+		return t.SequenceScope != "" && t.SequenceScope != models.SequenceScopeGlobal
+	}
+	if !scoped(before) && !scoped(after) {
+		return false
+	}
+	return before.SequenceScope != after.SequenceScope ||
+		!slices.Equal(before.SequenceScopeGroups, after.SequenceScopeGroups) ||
+		!slices.Equal(before.Groups, after.Groups)
+}
+
+// rescopeFunc returns a function giving an existing hostname its sequence scope
+// key under template. Each name is split with the version that built it, or with
+// current if that version is unknown.
+func rescopeFunc(template, current *models.Template, versions []*models.TemplateVersion) func(*models.Hostname) (string, error) {
+	definitions := make(map[int]*models.Template, len(versions))
+	for _, version := range versions {
+		definitions[version.Version] = &version.Definition
+	}
+
+	return func(hostname *models.Hostname) (string, error) {
+		definition, ok := definitions[hostname.TemplateVersion]
+		if !ok {
+			definition = current
+		}
+		parsed, err := parseHostname(definition, hostname.Name)
+		if err != nil {
+			return "", fmt.Errorf("cannot change the sequence scope: hostname %s does not match its template version: %w", hostname.Name, err)
+		}
+		return sequenceScopeKey(template, parsed.Params), nil
+	}
+}
+
+// GetTemplateVersions returns all stored versions of a template
+func (s *GeneratorService) GetTemplateVersions(ctx context.Context, id int64) ([]*models.TemplateVersion, error) {
+	return s.templateRepo.ListVersions(ctx, id)
+}
+
+// GetTemplateVersion returns a specific version of a template
+func (s *GeneratorService) GetTemplateVersion(ctx context.Context, id int64, version int) (*models.TemplateVersion, error) {
+	return s.templateRepo.GetVersion(ctx, id, version)
 }
 
 // DeleteTemplate deletes a template by ID with better error handling
@@ -284,5 +660,7 @@ func (s *GeneratorService) DeleteTemplate(ctx context.Context, id int64) error {
 		Str("name", template.Name).
 		Msg("Template deleted successfully")
 
+	s.auditSvc.Record(ctx, models.AuditEntityTemplate, id, models.AuditActionDelete, template, nil)
+
 	return nil
 }
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+// siteTemplate returns a template naming hostnames by site and sequence, such as NYC001
+func siteTemplate(scope models.SequenceScope) *models.Template {
+	return &models.Template{
+		ID:                1,
+		Name:              "site",
+		MaxLength:         15,
+		SequenceStart:     1,
+		SequenceLength:    3,
+		SequencePadding:   true,
+		SequenceIncrement: 1,
+		SequenceScope:     scope,
+		Groups: []models.TemplateGroup{
+			{Name: "site", Length: 3, Position: 1, IsRequired: true, ValidationType: string(models.ValidationTypeList), ValidationValue: "NYC,LON"},
+			{Name: "seq", Length: 3, Position: 2, IsRequired: true, ValidationType: string(models.ValidationTypeSequence)},
+		},
+	}
+}
+
+func TestSequenceScopeChanged(t *testing.T) {
+	global := siteTemplate(models.SequenceScopeGlobal)
+	prefix := siteTemplate(models.SequenceScopePrefix)
+
+	tests := []struct {
+		name          string
+		before, after *models.Template
+		want          bool
+	}{
+		{"global to global", global, siteTemplate(""), false},
+		{"global to prefix", global, prefix, true},
+		{"prefix to global", prefix, global, true},
+		{"prefix unchanged", prefix, siteTemplate(models.SequenceScopePrefix), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sequenceScopeChanged(tt.before, tt.after); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	renamed := siteTemplate(models.SequenceScopePrefix)
+	renamed.Groups[0].Name = "location"
+	if !sequenceScopeChanged(prefix, renamed) {
+		t.Fatal("expected renaming a group in scope to change the scope")
+	}
+}
+
+func TestRescopeFuncSplitsNamesWithTheirVersion(t *testing.T) {
+	// Version 1 knew only NYC; the current version added LON
+	v1 := siteTemplate(models.SequenceScopeGlobal)
+	v1.Groups[0].ValidationValue = "NYC"
+	current := siteTemplate(models.SequenceScopeGlobal)
+	versions := []*models.TemplateVersion{{Version: 1, Definition: *v1}, {Version: 2, Definition: *current}}
+
+	scopeKey := rescopeFunc(siteTemplate(models.SequenceScopePrefix), current, versions)
+
+	tests := []struct {
+		hostname *models.Hostname
+		want     string
+	}{
+		{&models.Hostname{Name: "NYC007", TemplateVersion: 1}, "prefix:site=NYC"},
+		{&models.Hostname{Name: "lon002", TemplateVersion: 2}, "prefix:site=LON"},
+		{&models.Hostname{Name: "LON003"}, "prefix:site=LON"}, // unknown version: the current one
+	}
+	for _, tt := range tests {
+		key, err := scopeKey(tt.hostname)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.hostname.Name, err)
+		}
+		if key != tt.want {
+			t.Errorf("%s: expected key %q, got %q", tt.hostname.Name, tt.want, key)
+		}
+	}
+
+	// LON did not exist in version 1
+	_, err := scopeKey(&models.Hostname{Name: "LON004", TemplateVersion: 1})
+	if err == nil || !strings.Contains(err.Error(), "LON004") {
+		t.Fatalf("expected a name its version can't split to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+// grantsContextKey is the context key under which the grants of the caller's API key are stored
+type grantsContextKey struct{}
+
+// WithAPIKeyGrants returns a copy of ctx restricted to the given API key grants.
+// A context without grants is unrestricted.
+func WithAPIKeyGrants(ctx context.Context, grants []models.APIKeyGrant) context.Context {
+	if len(grants) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, grantsContextKey{}, grants)
+}
+
+// grantsFromContext returns the API key grants stored in ctx, if any
+func grantsFromContext(ctx context.Context) []models.APIKeyGrant {
+	grants, _ := ctx.Value(grantsContextKey{}).([]models.APIKeyGrant)
+	return grants
+}
+
+// scopeContextKey is the context key under which the scope of the caller's API key is stored
+type scopeContextKey struct{}
+
+// WithAPIKeyScope returns a copy of ctx carrying the scope of the caller's API key,
+// which bounds the permissions the key can exercise
+func WithAPIKeyScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+// scopeFromContext returns the API key scope stored in ctx, or "" if there is none
+func scopeFromContext(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeContextKey{}).(string)
+	return scope
+}
+
+// apiKeyScopePermissions lists the permissions each API key scope allows; the
+// read scope allows none. User, role and audit management are not open to API
+// keys, whatever their scope or their owner's roles.
+var apiKeyScopePermissions = map[string][]models.Permission{
+	"reserve": {models.PermissionHostnameReserve},
+	"commit":  {models.PermissionHostnameCommit},
+	"release": {models.PermissionHostnameRelease, models.PermissionHostnameForceRelease},
+	"admin": {
+		models.PermissionTemplateCreate,
+		models.PermissionTemplateUpdate,
+		models.PermissionTemplateDelete,
+		models.PermissionHostnameReserve,
+		models.PermissionHostnameCommit,
+		models.PermissionHostnameRelease,
+		models.PermissionHostnameForceRelease,
+		models.PermissionHostnameImport,
+		models.PermissionDNSManage,
+	},
+}
+
+// scopeAllows reports whether a comma-separated API key scope allows permission
+func scopeAllows(scope string, permission models.Permission) bool {
+	for _, name := range strings.Split(scope, ",") {
+		if slices.Contains(apiKeyScopePermissions[strings.TrimSpace(name)], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsAllowTemplate reports whether grants let an API key act on the template.
+// A zero templateID stands for every template, which only an unrestricted key covers.
+func grantsAllowTemplate(grants []models.APIKeyGrant, templateID int64) bool {
+	if len(grants) == 0 {
+		return true
+	}
+	if templateID == 0 {
+		return false
+	}
+	for _, grant := range grants {
+		if grantsTemplate(grant, templateID) {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionError is returned when the caller's API key is not granted a
+// template, or a value of one of its groups
+type PermissionError struct {
+	TemplateID int64
+	Group      string // empty when the template itself is not granted
+	Value      string
+	Allowed    []string
+	Hostname   string // set when the group values of a hostname could not be recovered
+}
+
+// Error implements the error interface
+func (e *PermissionError) Error() string {
+	if e.Hostname != "" {
+		return fmt.Sprintf("API key grants restrict the groups of template %d, but hostname %q no longer parses against it", e.TemplateID, e.Hostname)
+	}
+	if e.Group == "" {
+		return fmt.Sprintf("API key is not granted template %d", e.TemplateID)
+	}
+	return fmt.Sprintf("API key is not granted %s=%q on template %d, allowed values: %s",
+		e.Group, e.Value, e.TemplateID, strings.Join(e.Allowed, ", "))
+}
+
+// checkGrants returns a PermissionError unless one of grants allows a hostname
+// of the template with the given group values. No grants allow everything.
+func checkGrants(grants []models.APIKeyGrant, templateID int64, params map[string]string) error {
+	if len(grants) == 0 {
+		return nil
+	}
+
+	// Report the first denied group of the first grant for the template
+	var denied *PermissionError
+	for _, grant := range grants {
+		if !grantsTemplate(grant, templateID) {
+			continue
+		}
+		group, ok := deniedGroup(grant, params)
+		if ok {
+			return nil
+		}
+		if denied == nil {
+			denied = &PermissionError{
+				TemplateID: templateID,
+				Group:      group,
+				Value:      params[group],
+				Allowed:    grant.Params[group],
+			}
+		}
+	}
+
+	if denied == nil {
+		denied = &PermissionError{TemplateID: templateID}
+	}
+	return denied
+}
+
+// grantsTemplate reports whether grant covers the template
+func grantsTemplate(grant models.APIKeyGrant, templateID int64) bool {
+	if len(grant.TemplateIDs) == 0 {
+		return true
+	}
+	for _, id := range grant.TemplateIDs {
+		if id == templateID {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedGroup returns the first group, by name, whose value grant does not
+// allow, or false if it allows them all. Values are compared ignoring case,
+// as templates match list values.
+func deniedGroup(grant models.APIKeyGrant, params map[string]string) (string, bool) {
+	groups := make([]string, 0, len(grant.Params))
+	for group := range grant.Params {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		value, allowed := params[group], false
+		for _, v := range grant.Params[group] {
+			if strings.EqualFold(v, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return group, false
+		}
+	}
+	return "", true
+}
+
+// grantsParams reports whether any grant covering the template restricts its groups
+func grantsParams(grants []models.APIKeyGrant, templateID int64) bool {
+	for _, grant := range grants {
+		if grantsTemplate(grant, templateID) && len(grant.Params) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHostnameGrants checks the caller's API key grants allow an existing
+// hostname. Its group values are recovered by parsing the name against the
+// template version it was generated from.
+func (s *ReservationService) checkHostnameGrants(ctx context.Context, hostname *models.Hostname) error {
+	grants := grantsFromContext(ctx)
+	if !grantsParams(grants, hostname.TemplateID) {
+		return checkGrants(grants, hostname.TemplateID, nil)
+	}
+
+	template, err := s.templateRepo.GetByID(ctx, hostname.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	if hostname.TemplateVersion != 0 && hostname.TemplateVersion != template.Version {
+		version, err := s.templateRepo.GetVersion(ctx, hostname.TemplateID, hostname.TemplateVersion)
+		if err != nil {
+			return fmt.Errorf("failed to get template version: %w", err)
+		}
+		template = &version.Definition
+	}
+
+	parsed, err := parseHostname(template, hostname.Name)
+	if err != nil {
+		return &PermissionError{TemplateID: hostname.TemplateID, Hostname: hostname.Name}
+	}
+
+	return checkGrants(grants, hostname.TemplateID, parsed.Params)
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/rs/zerolog/log"
+)
+
+// Supported import file formats
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+)
+
+// importNameColumns are the CSV header names recognized as the hostname column
+var importNameColumns = []string{"hostname", "name", "computername"}
+
+// ImportService loads pre-existing hostnames into the inventory
+type ImportService struct {
+	hostnameRepo repository.HostnameRepository
+	templateRepo repository.TemplateRepository
+	auditSvc     *AuditService
+}
+
+// NewImportService creates a new ImportService
+func NewImportService(hostnameRepo repository.HostnameRepository, templateRepo repository.TemplateRepository, auditSvc *AuditService) *ImportService {
+	return &ImportService{
+		hostnameRepo: hostnameRepo,
+		templateRepo: templateRepo,
+		auditSvc:     auditSvc,
+	}
+}
+
+// ReadImportRows reads hostnames from a CSV or JSON document.
+// CSV input uses the hostname, name or computername column if the first row is a
+// header naming one, otherwise the first column. JSON input is either an array of
+// strings or an array of objects with a hostname or name field.
+func ReadImportRows(r io.Reader, format string) ([]models.HostnameImportRow, error) {
+	switch strings.ToLower(format) {
+	case ImportFormatCSV:
+		return readImportCSV(r)
+	case ImportFormatJSON:
+		return readImportJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+}
+
+// readImportCSV reads hostnames from CSV input
+func readImportCSV(r io.Reader) ([]models.HostnameImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []models.HostnameImportRow
+	column := 0
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV line %d: %w", line, err)
+		}
+
+		// Use the header to locate the hostname column
+		if line == 1 {
+			if index := headerColumn(record); index >= 0 {
+				column = index
+				continue
+			}
+		}
+
+		if column >= len(record) || strings.TrimSpace(record[column]) == "" {
+			continue
+		}
+		rows = append(rows, models.HostnameImportRow{Line: line, Input: strings.TrimSpace(record[column])})
+	}
+
+	return rows, nil
+}
+
+// headerColumn returns the index of the hostname column in a CSV header, or -1
+func headerColumn(record []string) int {
+	for i, field := range record {
+		field = strings.ToLower(strings.TrimSpace(field))
+		for _, name := range importNameColumns {
+			if field == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// readImportJSON reads hostnames from JSON input
+func readImportJSON(r io.Reader) ([]models.HostnameImportRow, error) {
+	var entries []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	rows := make([]models.HostnameImportRow, 0, len(entries))
+	for i, entry := range entries {
+		var name string
+		if err := json.Unmarshal(entry, &name); err != nil {
+			var object struct {
+				Hostname string `json:"hostname"`
+				Name     string `json:"name"`
+			}
+			if err := json.Unmarshal(entry, &object); err != nil {
+				return nil, fmt.Errorf("entry %d is neither a string nor an object with a hostname", i+1)
+			}
+			name = object.Hostname
+			if name == "" {
+				name = object.Name
+			}
+		}
+
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		rows = append(rows, models.HostnameImportRow{Line: i + 1, Input: strings.TrimSpace(name)})
+	}
+
+	return rows, nil
+}
+
+// ImportHostnames parses each row against the template and inserts the matching
+// names as committed hostnames. Rows that don't match, repeat an earlier row or
+// already exist are reported and skipped. Nothing is written on a dry run.
+func (s *ImportService) ImportHostnames(ctx context.Context, templateID int64, rows []models.HostnameImportRow, dryRun bool, importedBy string) (*models.HostnameImportReport, error) {
+	// Get template
+	template, err := s.templateRepo.GetByID(ctx, templateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	report := &models.HostnameImportReport{
+		TemplateID: templateID,
+		DryRun:     dryRun,
+		Total:      len(rows),
+		Rows:       rows,
+	}
+
+	now := time.Now()
+	seen := make(map[string]int, len(rows))
+	var hostnames []*models.Hostname
+	var imported []int
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+
+		// Split the name into its template groups
+		parsed, err := parseHostname(template, row.Input)
+		if err != nil {
+			row.Status = models.ImportRowMismatch
+			row.Error = err.Error()
+			continue
+		}
+		row.Name = parsed.Name
+		row.SequenceNum = parsed.SequenceNum
+		row.Params = parsed.Params
+
+		// Names are unique whatever their case, like DNS names
+		key := strings.ToLower(parsed.Name)
+		if line, exists := seen[key]; exists {
+			row.Status = models.ImportRowDuplicate
+			row.Error = fmt.Sprintf("same hostname as line %d", line)
+			continue
+		}
+		seen[key] = row.Line
+
+		// Released names may be taken again, as when their sequence number is reused
+		existing, err := s.hostnameRepo.GetByName(ctx, parsed.Name)
+		if err != nil && !errors.Is(err, repository.ErrHostnameNotFound) {
+			row.Status = models.ImportRowFailed
+			row.Error = fmt.Sprintf("failed to check for an existing hostname: %v", err)
+			continue
+		}
+		if err == nil && existing.Status != models.StatusReleased && existing.Status != models.StatusExpired {
+			row.Status = models.ImportRowExists
+			row.Error = fmt.Sprintf("hostname already exists with status %s", existing.Status)
+			continue
+		}
+
+		row.Status = models.ImportRowValid
+		committedAt := now
+		hostnames = append(hostnames, &models.Hostname{
+			Name:            parsed.Name,
+			TemplateID:      template.ID,
+			TemplateVersion: template.Version,
+			Status:          models.StatusCommitted,
+			SequenceNum:     parsed.SequenceNum,
+			SequenceScope:   sequenceScopeKey(template, parsed.Params),
+			ReservedBy:      importedBy,
+			ReservedAt:      now,
+			CommittedBy:     importedBy,
+			CommittedAt:     &committedAt,
+			IPAddress:       row.IPAddress,
+		})
+		imported = append(imported, i)
+	}
+	report.Rejected = report.Total - len(hostnames)
+
+	if dryRun || len(hostnames) == 0 {
+		return report, nil
+	}
+
+	// Insert all matching names in one transaction
+	if err := s.hostnameRepo.ImportHostnames(ctx, hostnames); err != nil {
+		return nil, fmt.Errorf("failed to import hostnames: %w", err)
+	}
+
+	for _, i := range imported {
+		report.Rows[i].Status = models.ImportRowImported
+	}
+	report.Imported = len(hostnames)
+
+	for _, hostname := range hostnames {
+		s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionImport, nil, hostname)
+	}
+
+	log.Info().
+		Int64("templateID", templateID).
+		Int("imported", report.Imported).
+		Int("rejected", report.Rejected).
+		Str("importedBy", importedBy).
+		Msg("Hostnames imported")
+
+	return report, nil
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+)
+
+// hostnameParser splits a hostname back into the groups of a template
+type hostnameParser struct {
+	template *models.Template
+	groups   []models.TemplateGroup
+	patterns map[int]*regexp.Regexp
+	name     string
+
+	// values holds the canonical value chosen for each group while matching
+	values []string
+
+	// dead records (group index, position) pairs already known not to match
+	dead map[[2]int]bool
+
+	// failPos and failGroup record the furthest point matching reached, for error reporting
+	failPos   int
+	failGroup int
+}
+
+// parseHostname reverses buildHostname: it splits name into the template's group
+// values and sequence number. List entries, regex matches and unpadded sequences
+// can be shorter than their group, so candidate values are tried longest first
+// and matching backtracks until the whole name is consumed. Matching ignores case;
+// the returned name uses the template's own spelling of list and fixed values.
+func parseHostname(template *models.Template, name string) (*models.ParsedHostname, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ParseError{TemplateID: template.ID, Mismatch: models.HostnameMismatch{Position: 1, Reason: "hostname is empty"}}
+	}
+	if len(name) > template.MaxLength {
+		return nil, &ParseError{
+			TemplateID: template.ID,
+			Hostname:   name,
+			Mismatch: models.HostnameMismatch{
+				Position:  template.MaxLength + 1,
+				Remainder: name[template.MaxLength:],
+				Reason:    fmt.Sprintf("hostname exceeds template maximum length of %d characters", template.MaxLength),
+			},
+		}
+	}
+
+	p := &hostnameParser{
+		template:  template,
+		groups:    orderedGroups(template),
+		patterns:  make(map[int]*regexp.Regexp),
+		name:      name,
+		dead:      make(map[[2]int]bool),
+		failGroup: -1,
+	}
+	p.values = make([]string, len(p.groups))
+
+	// Compile regex groups once
+	for i, group := range p.groups {
+		if group.ValidationType == string(models.ValidationTypeRegex) && group.ValidationValue != "" {
+			pattern, err := compileGroupPattern(group.ValidationValue)
+			if err != nil {
+				return nil, fmt.Errorf("group %s has an invalid pattern: %w", group.Name, err)
+			}
+			p.patterns[i] = pattern
+		}
+	}
+
+	if !p.match(0, 0) {
+		return nil, p.err()
+	}
+
+	// Collect the matched values
+	parsed := &models.ParsedHostname{
+		TemplateID:   template.ID,
+		TemplateName: template.Name,
+		Params:       make(map[string]string),
+	}
+
+	var canonical strings.Builder
+	for i, group := range p.groups {
+		value := p.values[i]
+		canonical.WriteString(value)
+
+		switch group.ValidationType {
+		case string(models.ValidationTypeFixed):
+			// Fixed values are not parameters
+		case string(models.ValidationTypeSequence):
+			seq, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sequence %q: %w", value, err)
+			}
+			parsed.SequenceNum = seq
+		default:
+			if value != "" {
+				parsed.Params[group.Name] = value
+			}
+		}
+	}
+	parsed.Name = canonical.String()
+
+	return parsed, nil
+}
+
+// orderedGroups returns the groups buildHostname uses, in the order it uses them
+func orderedGroups(template *models.Template) []models.TemplateGroup {
+	groupsByPosition := make(map[int]models.TemplateGroup)
+	for _, group := range template.Groups {
+		groupsByPosition[group.Position] = group
+	}
+
+	groups := make([]models.TemplateGroup, 0, len(template.Groups))
+	for i := 1; i <= len(template.Groups); i++ {
+		if group, exists := groupsByPosition[i]; exists {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// match reports whether the groups from index onwards can consume the name from pos
+func (p *hostnameParser) match(index, pos int) bool {
+	if index == len(p.groups) {
+		if pos == len(p.name) {
+			return true
+		}
+		p.fail(index, pos)
+		return false
+	}
+	if p.dead[[2]int{index, pos}] {
+		return false
+	}
+
+	for _, candidate := range p.candidates(index, p.name[pos:]) {
+		p.values[index] = candidate.value
+		if p.match(index+1, pos+candidate.length) {
+			return true
+		}
+	}
+
+	p.dead[[2]int{index, pos}] = true
+	p.fail(index, pos)
+	return false
+}
+
+// fail records a failed match if it got further than any previous attempt
+func (p *hostnameParser) fail(index, pos int) {
+	if pos > p.failPos || (pos == p.failPos && index > p.failGroup) {
+		p.failPos = pos
+		p.failGroup = index
+	}
+}
+
+// err describes where matching stopped
+func (p *hostnameParser) err() error {
+	mismatch := models.HostnameMismatch{
+		Position:  p.failPos + 1,
+		Remainder: p.name[p.failPos:],
+	}
+
+	if p.failGroup >= len(p.groups) {
+		mismatch.Reason = "unexpected trailing characters after the last group"
+	} else {
+		group := p.groups[p.failGroup]
+		mismatch.Group = group.Name
+		mismatch.Reason = describeGroupRule(group, p.template)
+	}
+
+	return &ParseError{TemplateID: p.template.ID, Hostname: p.name, Mismatch: mismatch}
+}
+
+// describeGroupRule explains what a group expects, for mismatch errors
+func describeGroupRule(group models.TemplateGroup, template *models.Template) string {
+	switch group.ValidationType {
+	case string(models.ValidationTypeFixed):
+		return fmt.Sprintf("expected fixed value %q", truncate(group.ValidationValue, group.Length))
+	case string(models.ValidationTypeSequence):
+		if template.SequencePadding {
+			return fmt.Sprintf("expected a %d-digit sequence number", template.SequenceLength)
+		}
+		return "expected a sequence number"
+	case string(models.ValidationTypeList):
+		if group.ValidationValue != "" {
+			return fmt.Sprintf("expected one of %s", group.ValidationValue)
+		}
+	case string(models.ValidationTypeRegex):
+		if group.ValidationValue != "" {
+			return fmt.Sprintf("expected a value matching %s", group.ValidationValue)
+		}
+	}
+	return fmt.Sprintf("expected up to %d characters", group.Length)
+}
+
+// parseCandidate is a possible value for a group: the canonical value and how many
+// characters of the name it consumes
+type parseCandidate struct {
+	value  string
+	length int
+}
+
+// candidates lists the values group index could take at the start of rest, longest first
+func (p *hostnameParser) candidates(index int, rest string) []parseCandidate {
+	group := p.groups[index]
+	maxLen := len(rest)
+	if group.Length > 0 && group.Length < maxLen {
+		maxLen = group.Length
+	}
+
+	var candidates []parseCandidate
+	switch group.ValidationType {
+	case string(models.ValidationTypeFixed):
+		value := truncate(group.ValidationValue, group.Length)
+		if hasPrefixFold(rest, value) {
+			candidates = append(candidates, parseCandidate{value: value, length: len(value)})
+		}
+		return candidates
+
+	case string(models.ValidationTypeSequence):
+		digits := 0
+		for digits < maxLen && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+
+		minLen := 1
+		if p.template.SequencePadding {
+			minLen = p.template.SequenceLength
+			if group.Length > 0 && group.Length < minLen {
+				minLen = group.Length
+			}
+		}
+
+		for n := digits; n >= minLen; n-- {
+			// Unpadded sequences never start with zero
+			if !p.template.SequencePadding && n > 1 && rest[0] == '0' {
+				continue
+			}
+			candidates = append(candidates, parseCandidate{value: rest[:n], length: n})
+		}
+		return candidates
+
+	case string(models.ValidationTypeList):
+		if group.ValidationValue != "" {
+			for _, allowed := range splitList(group.ValidationValue) {
+				value := truncate(allowed, group.Length)
+				if value != "" && hasPrefixFold(rest, value) {
+					candidates = append(candidates, parseCandidate{value: value, length: len(value)})
+				}
+			}
+			sort.SliceStable(candidates, func(i, j int) bool {
+				return candidates[i].length > candidates[j].length
+			})
+			break
+		}
+		fallthrough
+
+	default:
+		pattern := p.patterns[index]
+		for n := maxLen; n >= 1; n-- {
+			if pattern != nil && !pattern.MatchString(rest[:n]) {
+				continue
+			}
+			candidates = append(candidates, parseCandidate{value: rest[:n], length: n})
+		}
+	}
+
+	// Optional groups may have been left out entirely
+	if !group.IsRequired {
+		candidates = append(candidates, parseCandidate{})
+	}
+
+	return candidates
+}
+
+// truncate shortens value to length characters, as buildHostname does
+func truncate(value string, length int) string {
+	if length > 0 && len(value) > length {
+		return value[:length]
+	}
+	return value
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/bilbothegreedy/HNS/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// ReaperActor is recorded as the releasing party for reservations the reaper expires
+const ReaperActor = "system:reservation-reaper"
+
+// ReservationReaper periodically expires reservations that were never committed
+type ReservationReaper struct {
+	reservationSvc *ReservationService
+	interval       time.Duration
+}
+
+// NewReservationReaper creates a new ReservationReaper
+func NewReservationReaper(reservationSvc *ReservationService, interval time.Duration) *ReservationReaper {
+	return &ReservationReaper{
+		reservationSvc: reservationSvc,
+		interval:       interval,
+	}
+}
+
+// Run sweeps expired reservations every interval until ctx is cancelled
+func (r *ReservationReaper) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		log.Info().Msg("Reservation reaper disabled")
+		return
+	}
+
+	log.Info().Dur("interval", r.interval).Msg("Reservation reaper started")
+
+	// Attribute every expiry to the reaper in the audit log
+	ctx = WithActor(ctx, models.Actor{Username: ReaperActor, AuthMethod: "system"})
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Reservation reaper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep expires all lapsed reservations once
+func (r *ReservationReaper) sweep(ctx context.Context) {
+	expired, err := r.reservationSvc.ExpireReservations(ctx, ReaperActor)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to expire reservations")
+		}
+		return
+	}
+
+	for _, hostname := range expired {
+		log.Info().
+			Int64("hostnameID", hostname.ID).
+			Str("hostname", hostname.Name).
+			Str("reservedBy", hostname.ReservedBy).
+			Msg("Reservation expired")
+	}
+}
@@ -2,90 +2,212 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"time"
 
+	"github.com/bilbothegreedy/HNS/internal/config"
 	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
 	"github.com/rs/zerolog/log"
 )
 
+// DNSRegistrar publishes the DNS records of committed hostnames. A call that
+// fails leaves the hostname's records as they were, so callers only undo the
+// hostnames that succeeded.
+type DNSRegistrar interface {
+	Register(ctx context.Context, template *models.Template, hostname *models.Hostname) error
+	Deregister(ctx context.Context, template *models.Template, hostname *models.Hostname) error
+}
+
+// HostnameResolver looks hostnames up in DNS
+type HostnameResolver interface {
+	CheckHostname(ctx context.Context, hostname string) (*models.DNSVerificationResult, error)
+}
+
+// maxDNSConflicts bounds how many candidate names found in DNS a single reservation skips
+const maxDNSConflicts = 20
+
 // ReservationService is responsible for hostname reservation operations
 type ReservationService struct {
 	hostnameRepo repository.HostnameRepository
 	templateRepo repository.TemplateRepository
 	generatorSvc *GeneratorService
+	auditSvc     *AuditService
+	registrar    DNSRegistrar
+	resolver     HostnameResolver
+	authz        *AuthorizationService
+	config       config.ReservationConfig
 }
 
 // NewReservationService creates a new ReservationService
-func NewReservationService(hostnameRepo repository.HostnameRepository, templateRepo repository.TemplateRepository) *ReservationService {
+func NewReservationService(hostnameRepo repository.HostnameRepository, templateRepo repository.TemplateRepository, auditSvc *AuditService, cfg config.ReservationConfig) *ReservationService {
 	return &ReservationService{
 		hostnameRepo: hostnameRepo,
 		templateRepo: templateRepo,
-		generatorSvc: NewGeneratorService(templateRepo),
+		generatorSvc: NewGeneratorService(templateRepo, auditSvc),
+		auditSvc:     auditSvc,
+		config:       cfg,
 	}
 }
 
-// ReserveHostname reserves a hostname based on template and parameters
-func (s *ReservationService) ReserveHostname(ctx context.Context, req *models.HostnameReservationRequest) (*models.Hostname, error) {
+// SetDNSRegistrar enables DNS registration of hostnames as they are committed and released
+func (s *ReservationService) SetDNSRegistrar(registrar DNSRegistrar) {
+	s.registrar = registrar
+}
+
+// SetHostnameResolver enables checking candidate names in DNS before they are reserved
+func (s *ReservationService) SetHostnameResolver(resolver HostnameResolver) {
+	s.resolver = resolver
+}
+
+// SetAuthorizationService enables checking the caller's roles before hostnames are changed
+func (s *ReservationService) SetAuthorizationService(authz *AuthorizationService) {
+	s.authz = authz
+}
+
+// ReserveHostname reserves a hostname based on template and parameters. When DNS
+// checking is enabled for the template or requested, a candidate name that already
+// resolves is recorded as a conflict and the next sequence number is tried.
+func (s *ReservationService) ReserveHostname(ctx context.Context, req *models.HostnameReservationRequest) (*models.HostnameReservationResponse, error) {
 	// Get template
 	template, err := s.templateRepo.GetByID(ctx, req.TemplateID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get template: %w", err)
 	}
 
-	// Get next sequence number
-	nextSeq, err := s.hostnameRepo.GetNextSequenceNumber(ctx, req.TemplateID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get next sequence number: %w", err)
+	// Reject invalid parameters before allocating a sequence number
+	if err := s.generatorSvc.ValidateParams(template, req.Params); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, models.PermissionHostnameReserve, req.TemplateID, req.Params); err != nil {
+		return nil, err
 	}
 
-	// Generate hostname
-	hostnameStr, err := s.generatorSvc.GenerateHostname(ctx, req.TemplateID, nextSeq, req.Params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate hostname: %w", err)
+	checkDNS := template.DNSCheckOnReserve
+	if req.CheckDNS != nil {
+		checkDNS = *req.CheckDNS
+	}
+	if checkDNS && s.resolver == nil {
+		return nil, fmt.Errorf("DNS checking is not available")
 	}
 
-	// Check if hostname already exists
-	existing, err := s.hostnameRepo.GetByName(ctx, hostnameStr)
-	if err == nil && existing != nil {
-		// Hostname already exists, try with incremented sequence number
-		log.Info().
-			Str("hostname", hostnameStr).
-			Int("sequence", nextSeq).
-			Msg("Hostname already exists, trying with incremented sequence")
+	alloc := sequenceAllocation(template, s.config)
+	response := &models.HostnameReservationResponse{AllocationStrategy: alloc.Strategy, DNSChecked: checkDNS}
+
+	for {
+		hostname, err := s.reserveNext(ctx, template, req, alloc)
+		if err != nil {
+			return nil, err
+		}
+
+		if !checkDNS {
+			response.Hostname = hostname
+			break
+		}
 
-		// Increment by the template's increment value
-		nextSeq += template.SequenceIncrement
-		hostnameStr, err = s.generatorSvc.GenerateHostname(ctx, req.TemplateID, nextSeq, req.Params)
+		result, err := s.resolver.CheckHostname(ctx, hostname.Name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate hostname with incremented sequence: %w", err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// Not knowing is no conflict; keep the name but say it went unchecked
+			log.Warn().Err(err).Str("hostname", hostname.Name).Msg("Failed to check reserved hostname in DNS")
+			response.Hostname = hostname
+			response.DNSCheckError = err.Error()
+			break
+		}
+		if !result.Exists {
+			response.Hostname = hostname
+			break
 		}
 
-		// Check again to make sure this one is available
-		existing, err = s.hostnameRepo.GetByName(ctx, hostnameStr)
-		if err == nil && existing != nil {
-			return nil, fmt.Errorf("generated hostname still exists after incrementing sequence")
+		skipped, err := s.recordConflict(ctx, hostname, result)
+		if err != nil {
+			return nil, err
+		}
+		response.Skipped = append(response.Skipped, *skipped)
+
+		if len(response.Skipped) >= maxDNSConflicts {
+			return nil, fmt.Errorf("no free hostname found: the next %d candidate names are already in DNS", maxDNSConflicts)
 		}
 	}
 
-	// Create hostname record
+	hostname := response.Hostname
+	log.Info().
+		Str("hostname", hostname.Name).
+		Int("sequence", hostname.SequenceNum).
+		Str("strategy", string(alloc.Strategy)).
+		Int("skipped", len(response.Skipped)).
+		Int64("templateID", hostname.TemplateID).
+		Msg("Hostname reserved")
+
+	s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionReserve, nil, hostname)
+
+	return response, nil
+}
+
+// reserveNext allocates the next sequence number and inserts the hostname in one transaction
+func (s *ReservationService) reserveNext(ctx context.Context, template *models.Template, req *models.HostnameReservationRequest, alloc models.SequenceAllocation) (*models.Hostname, error) {
 	hostname := &models.Hostname{
-		Name:        hostnameStr,
-		TemplateID:  req.TemplateID,
-		Status:      models.StatusReserved,
-		SequenceNum: nextSeq,
-		ReservedBy:  req.RequestedBy,
-		DNSVerified: false,
+		TemplateID:      req.TemplateID,
+		TemplateVersion: template.Version,
+		Status:          models.StatusReserved,
+		ReservedBy:      req.RequestedBy,
+		ExpiresAt:       s.reservationExpiry(template),
+		SequenceScope:   s.generatorSvc.SequenceScopeKey(template, req.Params),
+		DNSVerified:     false,
 	}
 
-	// Save to database
-	if err := s.hostnameRepo.Create(ctx, hostname); err != nil {
-		return nil, fmt.Errorf("failed to create hostname record: %w", err)
+	err := s.hostnameRepo.ReserveNextSequence(ctx, hostname, alloc, func(seq int) (string, error) {
+		name, err := s.generatorSvc.BuildHostname(template, seq, req.Params)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate hostname: %w", err)
+		}
+		return name, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve hostname: %w", err)
 	}
 
 	return hostname, nil
 }
 
+// recordConflict turns a reservation whose name already resolves into a conflict
+// entry, so the name is never offered again
+func (s *ReservationService) recordConflict(ctx context.Context, hostname *models.Hostname, result *models.DNSVerificationResult) (*models.SkippedHostname, error) {
+	reason := "already resolves in DNS as " + result.MatchedName
+	if result.IPAddress != "" {
+		reason += " (" + result.IPAddress + ")"
+	}
+
+	if err := s.hostnameRepo.MarkConflict(ctx, hostname.ID, reason, result.IPAddress, result.VerifiedAt); err != nil {
+		return nil, fmt.Errorf("failed to record DNS conflict for %s: %w", hostname.Name, err)
+	}
+
+	log.Warn().
+		Str("hostname", hostname.Name).
+		Int("sequence", hostname.SequenceNum).
+		Str("ip", result.IPAddress).
+		Int64("templateID", hostname.TemplateID).
+		Msg("Hostname already in DNS, recorded as conflict")
+
+	conflict := *hostname
+	conflict.Status = models.StatusConflict
+	conflict.ConflictReason = reason
+	conflict.ExpiresAt = nil
+	s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionConflict, nil, &conflict)
+
+	return &models.SkippedHostname{
+		ID:          hostname.ID,
+		Name:        hostname.Name,
+		SequenceNum: hostname.SequenceNum,
+		Status:      models.StatusConflict,
+		Reason:      reason,
+	}, nil
+}
+
 // CommitHostname commits a reserved hostname
 func (s *ReservationService) CommitHostname(ctx context.Context, req *models.HostnameCommitRequest) error {
 	// Check if hostname exists and is reserved
@@ -94,15 +216,39 @@ func (s *ReservationService) CommitHostname(ctx context.Context, req *models.Hos
 		return fmt.Errorf("failed to get hostname: %w", err)
 	}
 
+	if err := s.authorizeHostname(ctx, models.PermissionHostnameCommit, hostname); err != nil {
+		return err
+	}
+
 	if hostname.Status != models.StatusReserved {
 		return fmt.Errorf("hostname is not in reserved status, current status: %s", hostname.Status)
 	}
 
+	if hostname.ExpiresAt != nil && !hostname.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("reservation expired at %s", hostname.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if err := validateIPAddress(req.IPAddress); err != nil {
+		return err
+	}
+
+	// Publish the DNS records before committing, so a failed update leaves the reservation intact
+	registered := *hostname
+	registered.IPAddress = req.IPAddress
+	if err := s.registerDNS(ctx, &registered); err != nil {
+		return err
+	}
+
 	// Commit the hostname
-	if err := s.hostnameRepo.CommitHostname(ctx, req.HostnameID, req.CommittedBy); err != nil {
+	if err := s.hostnameRepo.CommitHostname(ctx, req.HostnameID, req.CommittedBy, req.IPAddress); err != nil {
+		if err := s.deregisterDNS(ctx, &registered); err != nil {
+			log.Warn().Err(err).Int64("hostnameID", hostname.ID).Msg("Failed to remove DNS records after failed commit")
+		}
 		return fmt.Errorf("failed to commit hostname: %w", err)
 	}
 
+	s.recordHostnameChange(ctx, hostname, models.AuditActionCommit)
+
 	return nil
 }
 
@@ -114,18 +260,217 @@ func (s *ReservationService) ReleaseHostname(ctx context.Context, req *models.Ho
 		return fmt.Errorf("failed to get hostname: %w", err)
 	}
 
+	if err := s.authorizeRelease(ctx, hostname); err != nil {
+		return err
+	}
+
 	if hostname.Status != models.StatusCommitted {
 		return fmt.Errorf("hostname is not in committed status, current status: %s", hostname.Status)
 	}
 
+	// Remove the DNS records first, so a failed update leaves the hostname committed
+	if err := s.deregisterDNS(ctx, hostname); err != nil {
+		return err
+	}
+
 	// Release the hostname
 	if err := s.hostnameRepo.ReleaseHostname(ctx, req.HostnameID, req.ReleasedBy); err != nil {
+		if err := s.registerDNS(ctx, hostname); err != nil {
+			log.Warn().Err(err).Int64("hostnameID", hostname.ID).Msg("Failed to restore DNS records after failed release")
+		}
 		return fmt.Errorf("failed to release hostname: %w", err)
 	}
 
+	s.recordHostnameChange(ctx, hostname, models.AuditActionRelease)
+
 	return nil
 }
 
+// ExtendReservation pushes back the expiry of a reserved hostname, bounded by the configured maximum TTL
+func (s *ReservationService) ExtendReservation(ctx context.Context, req *models.HostnameExtendRequest) (*models.Hostname, error) {
+	hostname, err := s.hostnameRepo.GetByID(ctx, req.HostnameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	if err := s.authorizeHostname(ctx, models.PermissionHostnameReserve, hostname); err != nil {
+		return nil, err
+	}
+
+	if hostname.Status != models.StatusReserved {
+		return nil, fmt.Errorf("hostname is not in reserved status, current status: %s", hostname.Status)
+	}
+
+	now := time.Now()
+	base := now
+	if hostname.ExpiresAt != nil {
+		if !hostname.ExpiresAt.After(now) {
+			return nil, fmt.Errorf("reservation expired at %s", hostname.ExpiresAt.Format(time.RFC3339))
+		}
+		base = *hostname.ExpiresAt
+	}
+
+	expiresAt := base.Add(time.Duration(req.ExtendBy) * time.Second)
+	if s.config.MaxTTL > 0 && expiresAt.Sub(now) > s.config.MaxTTL {
+		return nil, fmt.Errorf("reservation cannot be extended beyond the maximum TTL of %s", s.config.MaxTTL)
+	}
+
+	if err := s.hostnameRepo.ExtendReservation(ctx, req.HostnameID, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to extend reservation: %w", err)
+	}
+
+	log.Info().
+		Int64("hostnameID", req.HostnameID).
+		Str("extendedBy", req.ExtendedBy).
+		Time("expiresAt", expiresAt).
+		Msg("Reservation extended")
+
+	updated, err := s.hostnameRepo.GetByID(ctx, req.HostnameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionExtend, hostname, updated)
+
+	return updated, nil
+}
+
+// ExpireReservations moves all lapsed reservations to expired status and returns them
+func (s *ReservationService) ExpireReservations(ctx context.Context, releasedBy string) ([]*models.Hostname, error) {
+	expired, err := s.hostnameRepo.ExpireReservations(ctx, time.Now(), releasedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hostname := range expired {
+		before := *hostname
+		before.Status = models.StatusReserved
+		before.ReleasedBy = ""
+		before.ReleasedAt = nil
+		s.auditSvc.Record(ctx, models.AuditEntityHostname, hostname.ID, models.AuditActionExpire, &before, hostname)
+	}
+
+	return expired, nil
+}
+
+// authorize checks the caller may act with permission on a hostname of the
+// template with the given group values: their roles must allow it, and so must
+// their API key's grants
+func (s *ReservationService) authorize(ctx context.Context, permission models.Permission, templateID int64, params map[string]string) error {
+	if s.authz != nil {
+		if err := s.authz.Authorize(ctx, permission, templateID); err != nil {
+			return err
+		}
+	}
+	return checkGrants(grantsFromContext(ctx), templateID, params)
+}
+
+// authorizeHostname checks the caller may act with permission on an existing hostname
+func (s *ReservationService) authorizeHostname(ctx context.Context, permission models.Permission, hostname *models.Hostname) error {
+	if s.authz != nil {
+		if err := s.authz.Authorize(ctx, permission, hostname.TemplateID); err != nil {
+			return err
+		}
+	}
+	return s.checkHostnameGrants(ctx, hostname)
+}
+
+// authorizeRelease checks the caller may release a hostname. Releasing one
+// committed by someone else takes the force-release permission.
+func (s *ReservationService) authorizeRelease(ctx context.Context, hostname *models.Hostname) error {
+	if actor, ok := ActorFromContext(ctx); ok && hostname.CommittedBy == actor.Username {
+		err := s.authorizeHostname(ctx, models.PermissionHostnameRelease, hostname)
+		var authErr *AuthorizationError
+		if !errors.As(err, &authErr) {
+			return err
+		}
+	}
+	return s.authorizeHostname(ctx, models.PermissionHostnameForceRelease, hostname)
+}
+
+// recordHostnameChange audits a status transition, re-reading the hostname for its new state
+func (s *ReservationService) recordHostnameChange(ctx context.Context, before *models.Hostname, action models.AuditAction) {
+	after, err := s.hostnameRepo.GetByID(ctx, before.ID)
+	if err != nil {
+		log.Warn().Err(err).Int64("hostnameID", before.ID).Msg("Failed to load hostname for audit")
+	}
+	s.auditSvc.Record(ctx, models.AuditEntityHostname, before.ID, action, before, after)
+}
+
+// registerDNS publishes the records of a hostname if DNS registration is enabled
+func (s *ReservationService) registerDNS(ctx context.Context, hostname *models.Hostname) error {
+	if s.registrar == nil || hostname.IPAddress == "" {
+		return nil
+	}
+
+	template, err := s.templateRepo.GetByID(ctx, hostname.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+
+	if err := s.registrar.Register(ctx, template, hostname); err != nil {
+		return fmt.Errorf("failed to register hostname in DNS: %w", err)
+	}
+	return nil
+}
+
+// deregisterDNS removes the records of a hostname if DNS registration is enabled
+func (s *ReservationService) deregisterDNS(ctx context.Context, hostname *models.Hostname) error {
+	if s.registrar == nil || hostname.IPAddress == "" {
+		return nil
+	}
+
+	template, err := s.templateRepo.GetByID(ctx, hostname.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+
+	if err := s.registrar.Deregister(ctx, template, hostname); err != nil {
+		return fmt.Errorf("failed to remove hostname from DNS: %w", err)
+	}
+	return nil
+}
+
+// validateIPAddress checks that an address to commit a hostname with is empty or
+// a valid IPv4 or IPv6 address, whether or not it will be registered in DNS
+func validateIPAddress(ipAddress string) error {
+	if ipAddress != "" && net.ParseIP(ipAddress) == nil {
+		return fmt.Errorf("invalid IP address %q", ipAddress)
+	}
+	return nil
+}
+
+// reservationExpiry returns when a new reservation against template lapses, or nil if it never does
+func (s *ReservationService) reservationExpiry(template *models.Template) *time.Time {
+	ttl := s.config.DefaultTTL
+	if template.ReservationTTL > 0 {
+		ttl = time.Duration(template.ReservationTTL) * time.Second
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	return &expiresAt
+}
+
+// sequenceAllocation returns how sequence numbers are allocated for a template
+func sequenceAllocation(template *models.Template, cfg config.ReservationConfig) models.SequenceAllocation {
+	alloc := models.SequenceAllocation{
+		Strategy:  template.AllocationStrategy,
+		Start:     template.SequenceStart,
+		Increment: template.SequenceIncrement,
+		Cooldown:  cfg.ReleaseCooldown,
+	}
+	if alloc.Strategy == "" {
+		alloc.Strategy = models.AllocationNextHighest
+	}
+	if template.ReleaseCooldown > 0 {
+		alloc.Cooldown = time.Duration(template.ReleaseCooldown) * time.Second
+	}
+	return alloc
+}
+
 // GetReservedHostnames gets all reserved hostnames
 func (s *ReservationService) GetReservedHostnames(ctx context.Context, limit, offset int) ([]*models.Hostname, error) {
 	return s.hostnameRepo.GetByStatus(ctx, models.StatusReserved, limit, offset)
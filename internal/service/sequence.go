@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/bilbothegreedy/HNS/internal/config"
 	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
 )
@@ -11,18 +12,44 @@ import (
 // SequenceService is responsible for managing sequence numbers
 type SequenceService struct {
 	hostnameRepo repository.HostnameRepository
+	templateRepo repository.TemplateRepository
+	config       config.ReservationConfig
 }
 
 // NewSequenceService creates a new SequenceService
-func NewSequenceService(hostnameRepo repository.HostnameRepository) *SequenceService {
+func NewSequenceService(hostnameRepo repository.HostnameRepository, templateRepo repository.TemplateRepository, cfg config.ReservationConfig) *SequenceService {
 	return &SequenceService{
 		hostnameRepo: hostnameRepo,
+		templateRepo: templateRepo,
+		config:       cfg,
 	}
 }
 
-// GetNextSequenceNumber returns the next available sequence number for a template
-func (s *SequenceService) GetNextSequenceNumber(ctx context.Context, templateID int64) (int, error) {
-	return s.hostnameRepo.GetNextSequenceNumber(ctx, templateID)
+// sequenceScope resolves the template's allocation settings and the sequence scope
+// that params select in it. Without params the whole template is used, whatever its scope.
+func (s *SequenceService) sequenceScope(ctx context.Context, templateID int64, params map[string]string) (string, models.SequenceAllocation, error) {
+	template, err := s.templateRepo.GetByID(ctx, templateID)
+	if err != nil {
+		return "", models.SequenceAllocation{}, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	scopeKey := models.SequenceScopeKeyTemplate
+	if len(params) > 0 {
+		scopeKey = sequenceScopeKey(template, params)
+	}
+
+	return scopeKey, sequenceAllocation(template, s.config), nil
+}
+
+// GetNextSequenceNumber returns the sequence number the next reservation in the scope
+// that params select in a template would receive, following the template's allocation strategy
+func (s *SequenceService) GetNextSequenceNumber(ctx context.Context, templateID int64, params map[string]string) (int, error) {
+	scopeKey, alloc, err := s.sequenceScope(ctx, templateID, params)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.hostnameRepo.GetNextSequenceNumber(ctx, templateID, scopeKey, alloc)
 }
 
 // ReserveSequenceNumber reserves a specific sequence number for a template
@@ -79,18 +106,25 @@ func (s *SequenceService) ReleaseSequenceNumber(ctx context.Context, templateID
 
 // GetSequenceUsage returns information about sequence number usage for a template
 type SequenceUsageInfo struct {
-	TemplateID      int64 `json:"template_id"`
-	TotalSequences  int   `json:"total_sequences"`
-	UsedSequences   int   `json:"used_sequences"`
-	NextSequence    int   `json:"next_sequence"`
-	HighestSequence int   `json:"highest_sequence"`
-	LowestSequence  int   `json:"lowest_sequence"`
+	TemplateID      int64  `json:"template_id"`
+	Scope           string `json:"scope,omitempty"`
+	TotalSequences  int    `json:"total_sequences"`
+	UsedSequences   int    `json:"used_sequences"`
+	NextSequence    int    `json:"next_sequence"`
+	HighestSequence int    `json:"highest_sequence"`
+	LowestSequence  int    `json:"lowest_sequence"`
 }
 
-// GetSequenceUsage returns information about sequence number usage for a template
-func (s *SequenceService) GetSequenceUsage(ctx context.Context, templateID int64) (*SequenceUsageInfo, error) {
-	// Get all hostnames for the template
-	hostnames, err := s.hostnameRepo.GetByTemplateID(ctx, templateID, 1000, 0)
+// GetSequenceUsage returns information about sequence number usage in the scope
+// that params select in a template
+func (s *SequenceService) GetSequenceUsage(ctx context.Context, templateID int64, params map[string]string) (*SequenceUsageInfo, error) {
+	scopeKey, alloc, err := s.sequenceScope(ctx, templateID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all hostnames in the scope
+	hostnames, err := s.hostnameRepo.GetBySequenceScope(ctx, templateID, scopeKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get hostnames: %w", err)
 	}
@@ -98,6 +132,7 @@ func (s *SequenceService) GetSequenceUsage(ctx context.Context, templateID int64
 	// Count and find min/max
 	usage := &SequenceUsageInfo{
 		TemplateID:      templateID,
+		Scope:           scopeKey,
 		TotalSequences:  len(hostnames),
 		UsedSequences:   0,
 		NextSequence:    0,
@@ -126,7 +161,7 @@ func (s *SequenceService) GetSequenceUsage(ctx context.Context, templateID int64
 		}
 
 		// Get next sequence
-		usage.NextSequence, err = s.hostnameRepo.GetNextSequenceNumber(ctx, templateID)
+		usage.NextSequence, err = s.hostnameRepo.GetNextSequenceNumber(ctx, templateID, scopeKey, alloc)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get next sequence number: %w", err)
 		}
@@ -135,10 +170,16 @@ func (s *SequenceService) GetSequenceUsage(ctx context.Context, templateID int64
 	return usage, nil
 }
 
-// FindSequenceGaps finds gaps in the sequence numbers for a template
-func (s *SequenceService) FindSequenceGaps(ctx context.Context, templateID int64, maxGaps int) ([]int, error) {
-	// Get all hostnames for the template
-	hostnames, err := s.hostnameRepo.GetByTemplateID(ctx, templateID, 1000, 0)
+// FindSequenceGaps finds gaps in the sequence numbers in the scope that params
+// select in a template
+func (s *SequenceService) FindSequenceGaps(ctx context.Context, templateID int64, params map[string]string, maxGaps int) ([]int, error) {
+	scopeKey, _, err := s.sequenceScope(ctx, templateID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all hostnames in the scope
+	hostnames, err := s.hostnameRepo.GetBySequenceScope(ctx, templateID, scopeKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get hostnames: %w", err)
 	}
@@ -1,25 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/bilbothegreedy/HNS/internal/auth"
 	"github.com/bilbothegreedy/HNS/internal/repository"
 	"github.com/bilbothegreedy/HNS/internal/web/helpers"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// oidcCallbackPath is where the OIDC provider sends web logins back to
+const oidcCallbackPath = "/login/oidc/callback"
+
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
 	BaseHandler
 	userRepo repository.UserRepository
+	oidc     *auth.OIDCManager // nil unless single sign-on is configured
 }
 
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(userRepo repository.UserRepository) *AuthHandler {
+func NewAuthHandler(userRepo repository.UserRepository, oidc *auth.OIDCManager) *AuthHandler {
 	return &AuthHandler{
 		BaseHandler: *NewBaseHandler(),
 		userRepo:    userRepo,
+		oidc:        oidc,
 	}
 }
 
@@ -38,7 +47,9 @@ func (h *AuthHandler) ShowLogin(c *gin.Context) {
 	}
 
 	c.HTML(http.StatusOK, "login.html", gin.H{
-		"Title": "Login",
+		"Title":       "Login",
+		"OIDCEnabled": h.oidc != nil,
+		"ReturnURL":   localURL(returnURL),
 	})
 }
 
@@ -92,6 +103,71 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/dashboard")
 }
 
+// LoginOIDC starts a login through the OIDC provider, redirecting to it
+func (h *AuthHandler) LoginOIDC(c *gin.Context) {
+	authURL, state, err := h.oidc.LoginURL(c.Request.Context(), oidcCallbackPath, localURL(c.Query("returnUrl")))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start OIDC login")
+		h.RedirectWithAlert(c, "/login", "danger", "Single sign-on is unavailable. Please try again later.")
+		return
+	}
+
+	helpers.SetOIDCState(c, state)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback handles the OIDC provider sending a user back after they signed in
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		h.RedirectWithAlert(c, "/login", "danger", "Single sign-on failed: "+reason)
+		return
+	}
+
+	// The login must have been started in this browser
+	state := c.Query("state")
+	if state == "" || state != helpers.TakeOIDCState(c) {
+		h.RedirectWithAlert(c, "/login", "warning", "Your single sign-on login has expired. Please try again.")
+		return
+	}
+
+	user, returnURL, err := h.oidc.CompleteLogin(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserInactive):
+			h.RedirectWithAlert(c, "/login", "warning", "Your account is inactive. Please contact an administrator.")
+		case errors.Is(err, auth.ErrOIDCLoginExpired):
+			h.RedirectWithAlert(c, "/login", "warning", "Your single sign-on login has expired. Please try again.")
+		default:
+			log.Error().Err(err).Msg("Failed to complete OIDC login")
+			h.RedirectWithAlert(c, "/login", "danger", "Single sign-on failed. Please contact an administrator.")
+		}
+		return
+	}
+
+	// Create session
+	helpers.SetUserSession(c, user)
+
+	// Update last login time
+	if err := h.userRepo.UpdateLastLogin(c.Request.Context(), user.ID); err != nil {
+		log.Warn().Err(err).Int64("userID", user.ID).Msg("Failed to update last login time")
+	}
+
+	if returnURL != "" {
+		c.Redirect(http.StatusFound, returnURL)
+		return
+	}
+	c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// localURL returns url if it is a path on this site, so logins can't be used
+// to redirect elsewhere, or "" if not
+func localURL(url string) string {
+	if !strings.HasPrefix(url, "/") || strings.HasPrefix(url, "//") || strings.HasPrefix(url, "/\\") {
+		return ""
+	}
+	return url
+}
+
 // Logout handles user logout
 func (h *AuthHandler) Logout(c *gin.Context) {
 	// Clear session
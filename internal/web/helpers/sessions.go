@@ -18,6 +18,7 @@ const (
 	LoggedInKey  = "loggedIn"
 	AlertTypeKey = "alertType"
 	AlertMsgKey  = "alertMessage"
+	OIDCStateKey = "oidcState"
 )
 
 // Alert represents a flash message to show to the user
@@ -98,6 +99,23 @@ func GetCurrentUserID(c *gin.Context) (int64, bool) {
 	return userID.(int64), true
 }
 
+// SetOIDCState remembers the state of the single sign-on login started in this
+// browser, so its callback can't be replayed into another
+func SetOIDCState(c *gin.Context, state string) {
+	session := sessions.Default(c)
+	session.Set(OIDCStateKey, state)
+	session.Save()
+}
+
+// TakeOIDCState gets and clears the state of the single sign-on login started in this browser
+func TakeOIDCState(c *gin.Context) string {
+	session := sessions.Default(c)
+	state, _ := session.Get(OIDCStateKey).(string)
+	session.Delete(OIDCStateKey)
+	session.Save()
+	return state
+}
+
 // SetAlert sets a flash message in the session
 func SetAlert(c *gin.Context, alertType, message string) {
 	session := sessions.Default(c)
@@ -1,22 +1,28 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
 	"github.com/bilbothegreedy/HNS/internal/web/helpers"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 )
 
 // AuthMiddleware handles authentication for web routes
 type AuthMiddleware struct {
 	userRepo repository.UserRepository
+	authz    *service.AuthorizationService
 }
 
 // NewAuthMiddleware creates a new AuthMiddleware
-func NewAuthMiddleware(userRepo repository.UserRepository) *AuthMiddleware {
+func NewAuthMiddleware(userRepo repository.UserRepository, authz *service.AuthorizationService) *AuthMiddleware {
 	return &AuthMiddleware{
 		userRepo: userRepo,
+		authz:    authz,
 	}
 }
 
@@ -59,36 +65,40 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Store user object in context
+		// Store user object in context, and the user as the actor of the request
 		c.Set("user", user)
+		actor := models.Actor{
+			UserID:     user.ID,
+			Username:   user.Username,
+			AuthMethod: "session",
+			ClientIP:   c.ClientIP(),
+		}
+		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), actor))
 
 		c.Next()
 	}
 }
 
-// RequireAdmin middleware checks if user is an admin
-func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+// RequirePermission middleware checks if the signed-in user holds a permission
+// everywhere. It must follow RequireAuth.
+func (m *AuthMiddleware) RequirePermission(permission models.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// First check if authenticated
-		if !helpers.IsAuthenticated(c) {
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+		err := m.authz.Authorize(c.Request.Context(), permission, 0)
+		if err == nil {
+			c.Next()
 			return
 		}
 
-		// Check if user is admin
-		isAdmin, exists := c.Get("isAdmin")
-		if !exists || !isAdmin.(bool) {
-			c.HTML(http.StatusForbidden, "403.html", gin.H{
-				"Title":       "Access Denied",
-				"Message":     "You do not have permission to access this page",
-				"CurrentYear": gin.H{},
-			})
-			c.Abort()
-			return
+		var authErr *service.AuthorizationError
+		if !errors.As(err, &authErr) {
+			log.Error().Err(err).Str("permission", string(permission)).Msg("Failed to check permissions")
 		}
-
-		c.Next()
+		c.HTML(http.StatusForbidden, "403.html", gin.H{
+			"Title":       "Access Denied",
+			"Message":     "You do not have permission to access this page",
+			"CurrentYear": gin.H{},
+		})
+		c.Abort()
 	}
 }
 
@@ -8,7 +8,9 @@ import (
 
 	"github.com/bilbothegreedy/HNS/internal/auth"
 	"github.com/bilbothegreedy/HNS/internal/dns"
+	"github.com/bilbothegreedy/HNS/internal/models"
 	"github.com/bilbothegreedy/HNS/internal/repository"
+	"github.com/bilbothegreedy/HNS/internal/service"
 	"github.com/bilbothegreedy/HNS/internal/web/handlers"
 	"github.com/bilbothegreedy/HNS/internal/web/helpers"
 	"github.com/bilbothegreedy/HNS/internal/web/middleware"
@@ -23,6 +25,8 @@ func SetupRouter(
 	templateRepo repository.TemplateRepository,
 	jwtManager *auth.JWTManager,
 	dnsChecker *dns.DNSChecker,
+	authz *service.AuthorizationService,
+	oidcManager *auth.OIDCManager,
 ) {
 	// 1. Set up template renderer FIRST - before any routes are registered
 	setupTemplates(router)
@@ -34,11 +38,11 @@ func SetupRouter(
 	router.Static("/static", "./internal/web/static")
 
 	// 4. Create middleware
-	authMiddleware := middleware.NewAuthMiddleware(userRepo)
+	authMiddleware := middleware.NewAuthMiddleware(userRepo, authz)
 	router.Use(authMiddleware.LoadUser())
 
 	// 5. Create handlers
-	authHandler := handlers.NewAuthHandler(userRepo)
+	authHandler := handlers.NewAuthHandler(userRepo, oidcManager)
 	dashboardHandler := handlers.NewDashboardHandler(hostnameRepo, templateRepo)
 	baseHandler := handlers.NewBaseHandler()
 
@@ -48,6 +52,12 @@ func SetupRouter(
 	router.POST("/login", authHandler.Login)
 	router.GET("/logout", authHandler.Logout)
 
+	// Single sign-on, if configured
+	if oidcManager != nil {
+		router.GET("/login/oidc", authHandler.LoginOIDC)
+		router.GET("/login/oidc/callback", authHandler.OIDCCallback)
+	}
+
 	// Redirect root to dashboard if logged in, otherwise to login
 	router.GET("/", func(c *gin.Context) {
 		if helpers.IsAuthenticated(c) {
@@ -69,7 +79,7 @@ func SetupRouter(
 
 	// Admin routes
 	admin := router.Group("/admin")
-	admin.Use(authMiddleware.RequireAuth(), authMiddleware.RequireAdmin())
+	admin.Use(authMiddleware.RequireAuth(), authMiddleware.RequirePermission(models.PermissionUserManage))
 	{
 		// Add admin routes here
 	}
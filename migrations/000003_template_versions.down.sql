@@ -0,0 +1,8 @@
+-- Revert: template_versions
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS template_version;
+
+DROP INDEX IF EXISTS idx_template_versions_template_id;
+DROP TABLE IF EXISTS template_versions;
+
+ALTER TABLE templates DROP COLUMN IF EXISTS version;
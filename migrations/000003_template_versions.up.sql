@@ -0,0 +1,58 @@
+-- Migration: template_versions
+
+-- Track the current version of each template
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- Store a full snapshot of every template version
+CREATE TABLE IF NOT EXISTS template_versions (
+    id SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    definition JSONB NOT NULL,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (template_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_versions_template_id ON template_versions(template_id);
+
+-- Record the template version that produced each hostname
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS template_version INTEGER NOT NULL DEFAULT 1;
+
+-- Snapshot existing templates as version 1
+INSERT INTO template_versions (template_id, version, definition, created_by, created_at)
+SELECT
+    t.id,
+    t.version,
+    jsonb_build_object(
+        'id', t.id,
+        'name', t.name,
+        'description', COALESCE(t.description, ''),
+        'max_length', t.max_length,
+        'sequence_start', t.sequence_start,
+        'sequence_length', t.sequence_length,
+        'sequence_padding', t.sequence_padding,
+        'sequence_increment', t.sequence_increment,
+        'sequence_position', COALESCE(t.sequence_position, 0),
+        'version', t.version,
+        'is_active', t.is_active,
+        'created_by', t.created_by,
+        'groups', COALESCE((
+            SELECT jsonb_agg(jsonb_build_object(
+                'id', g.id,
+                'template_id', g.template_id,
+                'name', g.name,
+                'length', g.length,
+                'position', g.position,
+                'is_required', g.is_required,
+                'validation_type', COALESCE(g.validation_type, ''),
+                'validation_value', COALESCE(g.validation_value, '')
+            ) ORDER BY g.position)
+            FROM template_groups g
+            WHERE g.template_id = t.id
+        ), '[]'::jsonb)
+    ),
+    t.created_by,
+    t.created_at
+FROM templates t
+ON CONFLICT (template_id, version) DO NOTHING;
@@ -0,0 +1,3 @@
+-- Revert: template_strict_validation
+
+ALTER TABLE templates DROP COLUMN IF EXISTS strict_validation;
@@ -0,0 +1,4 @@
+-- Migration: template_strict_validation
+
+-- Reject invalid group values instead of substituting defaults (on by default)
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS strict_validation BOOLEAN NOT NULL DEFAULT TRUE;
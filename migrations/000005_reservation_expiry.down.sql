@@ -0,0 +1,6 @@
+-- Revert: reservation_expiry
+
+DROP INDEX IF EXISTS idx_hostnames_expires_at;
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE templates DROP COLUMN IF EXISTS reservation_ttl;
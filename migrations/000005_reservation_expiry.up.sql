@@ -0,0 +1,14 @@
+-- Migration: reservation_expiry
+
+-- Per-template reservation TTL in seconds (0 uses the server default)
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS reservation_ttl INTEGER NOT NULL DEFAULT 0;
+
+-- When a reservation lapses if it has not been committed
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_hostnames_expires_at ON hostnames(expires_at) WHERE status = 'reserved';
+
+-- Reservations made before expiry existed get the default TTL (reservation.defaultTTL,
+-- 24h) from when they were reserved, so they lapse like any other
+UPDATE hostnames SET expires_at = reserved_at + INTERVAL '24 hours'
+WHERE status = 'reserved' AND expires_at IS NULL;
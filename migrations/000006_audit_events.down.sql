@@ -0,0 +1,10 @@
+-- Revert: audit_events
+
+DROP TRIGGER IF EXISTS audit_events_immutable ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_immutable();
+
+DROP INDEX IF EXISTS idx_audit_events_created_at;
+DROP INDEX IF EXISTS idx_audit_events_actor;
+DROP INDEX IF EXISTS idx_audit_events_entity;
+
+DROP TABLE IF EXISTS audit_events;
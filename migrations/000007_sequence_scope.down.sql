@@ -0,0 +1,7 @@
+-- Revert: sequence_scope
+
+DROP INDEX IF EXISTS idx_hostnames_sequence_scope;
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS sequence_scope_key;
+ALTER TABLE templates DROP COLUMN IF EXISTS sequence_scope_groups;
+ALTER TABLE templates DROP COLUMN IF EXISTS sequence_scope;
@@ -0,0 +1,12 @@
+-- Migration: sequence_scope
+
+-- Where a template's sequence numbers are counted: global (per template), prefix
+-- (per combination of all group values) or groups (per combination of the listed groups)
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS sequence_scope VARCHAR(20) NOT NULL DEFAULT 'global';
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS sequence_scope_groups TEXT[] NOT NULL DEFAULT '{}';
+
+-- The sequence counter a hostname was numbered in: empty for the global counter,
+-- otherwise the scope mode and the values of the groups in scope, e.g. "prefix:site=NYC"
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS sequence_scope_key TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_hostnames_sequence_scope ON hostnames(template_id, sequence_scope_key, sequence_num);
@@ -0,0 +1,9 @@
+-- Revert: allocation_strategy
+
+-- Fails if a sequence number has been reused, since the name then appears more than once
+DROP INDEX IF EXISTS idx_hostnames_name;
+DROP INDEX IF EXISTS idx_hostnames_active_name;
+ALTER TABLE hostnames ADD CONSTRAINT hostnames_name_key UNIQUE (name);
+
+ALTER TABLE templates DROP COLUMN IF EXISTS release_cooldown;
+ALTER TABLE templates DROP COLUMN IF EXISTS allocation_strategy;
@@ -0,0 +1,15 @@
+-- Migration: allocation_strategy
+
+-- How a template picks sequence numbers: next-highest, lowest-free or
+-- lowest-free-after-cooldown
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS allocation_strategy VARCHAR(30) NOT NULL DEFAULT 'next-highest';
+
+-- How long a released sequence number stays unavailable, in seconds (0 uses the server default)
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS release_cooldown INTEGER NOT NULL DEFAULT 0;
+
+-- Released and expired hostnames are kept as history, so a reused sequence number
+-- produces a second row with the same name. Names only need to be unique among
+-- hostnames that are still held.
+ALTER TABLE hostnames DROP CONSTRAINT IF EXISTS hostnames_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hostnames_active_name ON hostnames(name) WHERE status NOT IN ('released', 'expired');
+CREATE INDEX IF NOT EXISTS idx_hostnames_name ON hostnames(name);
@@ -0,0 +1,3 @@
+-- Revert: hostname_ip_address
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS ip_address;
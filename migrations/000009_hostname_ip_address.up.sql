@@ -0,0 +1,4 @@
+-- Migration: hostname_ip_address
+
+-- Address a hostname was committed with, used for its DNS records
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
@@ -0,0 +1,6 @@
+-- Revert: scan_jobs
+
+DROP INDEX IF EXISTS idx_scan_jobs_status;
+DROP INDEX IF EXISTS idx_scan_jobs_template_id;
+
+DROP TABLE IF EXISTS scan_jobs;
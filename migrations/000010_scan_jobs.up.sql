@@ -0,0 +1,21 @@
+-- Migration: scan_jobs
+
+-- DNS scans run in the background; progress and results are kept here
+CREATE TABLE IF NOT EXISTS scan_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    template_id BIGINT NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    options JSONB NOT NULL,
+    total INT NOT NULL DEFAULT 0,
+    checked INT NOT NULL DEFAULT 0,
+    existing INT NOT NULL DEFAULT 0,
+    result JSONB,
+    error TEXT,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_template_id ON scan_jobs(template_id);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_status ON scan_jobs(status);
@@ -0,0 +1,7 @@
+-- Revert: dns_drift
+
+DROP INDEX IF EXISTS idx_hostnames_dns_drift;
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS dns_drift;
+ALTER TABLE hostnames DROP COLUMN IF EXISTS dns_ip_address;
+ALTER TABLE hostnames DROP COLUMN IF EXISTS last_dns_check;
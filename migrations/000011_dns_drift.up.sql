@@ -0,0 +1,8 @@
+-- Migration: dns_drift
+
+-- Outcome of the last DNS reconciliation of each hostname
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS last_dns_check TIMESTAMP;
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS dns_ip_address VARCHAR(45);
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS dns_drift VARCHAR(20);
+
+CREATE INDEX IF NOT EXISTS idx_hostnames_dns_drift ON hostnames(dns_drift) WHERE dns_drift IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Revert: dns_conflict
+
+ALTER TABLE hostnames DROP COLUMN IF EXISTS conflict_reason;
+
+ALTER TABLE templates DROP COLUMN IF EXISTS dns_check_on_reserve;
@@ -0,0 +1,7 @@
+-- Migration: dns_conflict
+
+-- Check each candidate name in DNS before reserving it, skipping names that resolve
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS dns_check_on_reserve BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Why a hostname was recorded as a conflict instead of being reserved
+ALTER TABLE hostnames ADD COLUMN IF NOT EXISTS conflict_reason TEXT;
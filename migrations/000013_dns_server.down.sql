@@ -0,0 +1,4 @@
+-- Revert: dns_server
+
+DROP INDEX IF EXISTS idx_hostnames_updated_at;
+DROP INDEX IF EXISTS idx_hostnames_committed_name;
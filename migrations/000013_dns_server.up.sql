@@ -0,0 +1,7 @@
+-- Migration: dns_server
+
+-- Lets the built-in DNS server look committed hostnames up by name, whatever their case
+CREATE INDEX IF NOT EXISTS idx_hostnames_committed_name ON hostnames(LOWER(name)) WHERE status = 'committed';
+
+-- The zone's serial is the time of the latest hostname change
+CREATE INDEX IF NOT EXISTS idx_hostnames_updated_at ON hostnames(updated_at);
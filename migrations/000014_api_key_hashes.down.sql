@@ -0,0 +1,14 @@
+-- Revert: api_key_hashes
+
+-- Plaintext keys can't be recovered from their hashes, so every key is left
+-- unusable and must be issued again
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key VARCHAR(255);
+UPDATE api_keys SET key = 'revoked-' || id WHERE key IS NULL;
+ALTER TABLE api_keys ALTER COLUMN key SET NOT NULL;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_key_key UNIQUE (key);
+
+DROP INDEX IF EXISTS idx_api_keys_key_hash;
+DROP INDEX IF EXISTS idx_api_keys_prefix;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS key_hash;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS prefix;
@@ -0,0 +1,16 @@
+-- Migration: api_key_hashes
+
+-- Keys are issued as hns_<prefix>_<secret> and only their SHA-256 hash is kept.
+-- The prefix is public and finds the key whose hash to compare.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS prefix VARCHAR(32);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key_hash VARCHAR(64);
+
+-- Existing keys are rehashed in place. They have no prefix, so they are found by
+-- their hash until they are rotated.
+UPDATE api_keys SET key_hash = encode(sha256(convert_to(key, 'UTF8')), 'hex') WHERE key_hash IS NULL;
+
+ALTER TABLE api_keys ALTER COLUMN key_hash SET NOT NULL;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys(prefix) WHERE prefix IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash);
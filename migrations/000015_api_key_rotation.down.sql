@@ -0,0 +1,11 @@
+-- Revert: api_key_rotation
+
+DROP INDEX IF EXISTS idx_api_keys_expires_at;
+DROP INDEX IF EXISTS idx_api_keys_active_name;
+
+-- Rotated keys would clash with their successors' names
+DELETE FROM api_keys WHERE rotated_at IS NOT NULL;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_user_id_name_key UNIQUE (user_id, name);
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS rotated_to;
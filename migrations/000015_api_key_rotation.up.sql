@@ -0,0 +1,12 @@
+-- Migration: api_key_rotation
+
+-- A rotated key points at its successor and stays valid until its grace period ends
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_to INTEGER REFERENCES api_keys(id) ON DELETE SET NULL;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+
+-- A successor takes its predecessor's name, so names need only be unique among
+-- keys that haven't been rotated
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_user_id_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_active_name ON api_keys(user_id, name) WHERE rotated_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys(expires_at);
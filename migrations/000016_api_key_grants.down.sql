@@ -0,0 +1,6 @@
+-- Revert: api_key_grants
+
+-- Restricted keys would gain access to every template
+DELETE FROM api_keys WHERE grants IS NOT NULL;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS grants;
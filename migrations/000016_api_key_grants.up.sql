@@ -0,0 +1,5 @@
+-- Migration: api_key_grants
+
+-- Grants restrict a key to templates and group values, as a JSON array of
+-- {"template_ids": [...], "params": {"group": ["value", ...]}}; NULL leaves the key unrestricted
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS grants JSONB;
@@ -0,0 +1,11 @@
+-- Revert: rbac
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+
+-- Custom roles don't exist without the roles table
+UPDATE users SET role = 'user' WHERE role NOT IN ('admin', 'user');
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(20);
+
+DROP TABLE IF EXISTS role_assignments;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,64 @@
+-- Migration: rbac
+
+-- Roles are named sets of permissions. Built-in roles are created here and
+-- cannot be changed through the API.
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+-- Extra roles held by a user, either everywhere (template_id NULL) or for one template.
+-- A role that is still assigned cannot be deleted.
+CREATE TABLE IF NOT EXISTS role_assignments (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id),
+    template_id INTEGER REFERENCES templates(id) ON DELETE CASCADE,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_assignments_unique ON role_assignments(user_id, role_id, COALESCE(template_id, 0));
+CREATE INDEX IF NOT EXISTS idx_role_assignments_template_id ON role_assignments(template_id);
+
+INSERT INTO roles (name, description, builtin, created_at, updated_at) VALUES
+    ('admin', 'Full access to everything', TRUE, NOW(), NOW()),
+    ('user', 'Reserve, commit and release hostnames of any template', TRUE, NOW(), NOW()),
+    ('template-owner', 'Manage a template and its hostnames, when assigned for that template', TRUE, NOW(), NOW())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+JOIN (VALUES
+    ('admin', '*'),
+    ('user', 'hostname:reserve'),
+    ('user', 'hostname:commit'),
+    ('user', 'hostname:release'),
+    ('user', 'hostname:force-release'),
+    ('template-owner', 'template:update'),
+    ('template-owner', 'template:delete'),
+    ('template-owner', 'hostname:reserve'),
+    ('template-owner', 'hostname:commit'),
+    ('template-owner', 'hostname:release'),
+    ('template-owner', 'hostname:force-release')
+) AS p(role, permission) ON p.role = r.name
+ON CONFLICT DO NOTHING;
+
+-- A user's own role column names their global role
+INSERT INTO roles (name, created_at, updated_at)
+SELECT DISTINCT role, NOW(), NOW() FROM users
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(50);
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE;
@@ -0,0 +1,8 @@
+-- Revert: oidc_users
+
+-- Users provisioned by single sign-on keep their accounts, but have no
+-- password to log in with until one is set
+DROP INDEX IF EXISTS idx_users_oidc_subject;
+
+ALTER TABLE users DROP COLUMN IF EXISTS oidc_subject;
+ALTER TABLE users DROP COLUMN IF EXISTS oidc_issuer;
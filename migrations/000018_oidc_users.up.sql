@@ -0,0 +1,9 @@
+-- Migration: oidc_users
+
+-- Users signing in through an OpenID Connect provider are linked to their
+-- account there by its issuer and subject; both are NULL for local users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_issuer, oidc_subject)
+    WHERE oidc_subject IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Revert: hostname_name_case
+
+DROP INDEX IF EXISTS idx_hostnames_active_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hostnames_active_name ON hostnames(name) WHERE status NOT IN ('released', 'expired');
@@ -0,0 +1,6 @@
+-- Migration: hostname_name_case
+
+-- DNS names are case-insensitive, so two held hostnames may not differ only in
+-- case. This fails if such pairs already exist; release one of each pair first.
+DROP INDEX IF EXISTS idx_hostnames_active_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hostnames_active_name ON hostnames(LOWER(name)) WHERE status NOT IN ('released', 'expired');
@@ -0,0 +1,4 @@
+-- Revert: hostname_name_lower
+
+DROP INDEX IF EXISTS idx_hostnames_name;
+CREATE INDEX IF NOT EXISTS idx_hostnames_name ON hostnames(name);
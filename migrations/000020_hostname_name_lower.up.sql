@@ -0,0 +1,5 @@
+-- Migration: hostname_name_lower
+
+-- Hostnames are looked up by name whatever their case
+DROP INDEX IF EXISTS idx_hostnames_name;
+CREATE INDEX IF NOT EXISTS idx_hostnames_name ON hostnames(LOWER(name));
@@ -0,0 +1,3 @@
+-- Revert: scan_job_owner
+
+ALTER TABLE scan_jobs DROP COLUMN IF EXISTS created_by_id;
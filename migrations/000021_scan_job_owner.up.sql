@@ -0,0 +1,5 @@
+-- Migration: scan_job_owner
+
+-- Scan jobs remember the account that started them, so only that account can
+-- cancel them without DNS management permission
+ALTER TABLE scan_jobs ADD COLUMN IF NOT EXISTS created_by_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+-- Revert: zone_serial
+
+CREATE INDEX IF NOT EXISTS idx_hostnames_updated_at ON hostnames(updated_at);
+
+DROP TRIGGER IF EXISTS hostnames_bump_zone_serial ON hostnames;
+DROP FUNCTION IF EXISTS hostnames_bump_zone_serial();
+
+DROP TABLE IF EXISTS zone_serial;
@@ -0,0 +1,42 @@
+-- Migration: zone_serial
+
+-- The built-in DNS server's SOA serial. It only moves when a committed hostname's
+-- name or address changes, and every change takes the row lock, so it strictly
+-- increases in commit order. It starts past the old time-based serial so
+-- secondaries keep transferring.
+CREATE TABLE IF NOT EXISTS zone_serial (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    serial BIGINT NOT NULL
+);
+
+INSERT INTO zone_serial (id, serial)
+SELECT TRUE, COALESCE(EXTRACT(EPOCH FROM MAX(updated_at))::BIGINT, 0) + 1 FROM hostnames
+ON CONFLICT (id) DO NOTHING;
+
+-- Bump the serial whenever a row enters, leaves or changes within the zone
+CREATE OR REPLACE FUNCTION hostnames_bump_zone_serial() RETURNS trigger AS $$
+DECLARE
+    old_record TEXT;
+    new_record TEXT;
+BEGIN
+    IF TG_OP <> 'INSERT' AND OLD.status = 'committed' AND OLD.ip_address IS NOT NULL THEN
+        old_record := LOWER(OLD.name) || ' ' || OLD.ip_address;
+    END IF;
+    IF TG_OP <> 'DELETE' AND NEW.status = 'committed' AND NEW.ip_address IS NOT NULL THEN
+        new_record := LOWER(NEW.name) || ' ' || NEW.ip_address;
+    END IF;
+
+    IF old_record IS DISTINCT FROM new_record THEN
+        UPDATE zone_serial SET serial = serial + 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS hostnames_bump_zone_serial ON hostnames;
+CREATE TRIGGER hostnames_bump_zone_serial
+    AFTER INSERT OR UPDATE OR DELETE ON hostnames
+    FOR EACH ROW EXECUTE FUNCTION hostnames_bump_zone_serial();
+
+-- The serial no longer comes from the latest hostname change
+DROP INDEX IF EXISTS idx_hostnames_updated_at;